@@ -0,0 +1,214 @@
+package cronsun
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	client "github.com/coreos/etcd/clientv3"
+
+	"cronsun/conf"
+	"cronsun/log"
+	"cronsun/node/cron"
+)
+
+// 任务有效期的状态
+const (
+	JobActive    = "active"    // 有效期内
+	JobPending   = "pending"   // 未到开始时间
+	JobExpired   = "expired"   // 已过结束时间
+	JobExhausted = "exhausted" // 已达到最大执行次数
+)
+
+func (j *Job) checkActive() error {
+	if j.StartAt != nil && j.StartAt.IsZero() {
+		j.StartAt = nil
+	}
+	if j.EndAt != nil && j.EndAt.IsZero() {
+		j.EndAt = nil
+	}
+	if j.StartAt != nil && j.EndAt != nil && !j.EndAt.After(*j.StartAt) {
+		return ErrIllegalJobActiveTime
+	}
+
+	if j.MaxRuns < 0 {
+		j.MaxRuns = 0
+	}
+	return nil
+}
+
+// ActiveState 任务在 t 时刻的有效期状态，runs 为已执行的次数
+func (j *Job) ActiveState(t time.Time, runs int64) string {
+	switch {
+	case j.EndAt != nil && t.After(*j.EndAt):
+		return JobExpired
+	case j.MaxRuns > 0 && runs >= j.MaxRuns:
+		return JobExhausted
+	case j.StartAt != nil && t.Before(*j.StartAt):
+		return JobPending
+	}
+	return JobActive
+}
+
+// 有效期内的定时器，有效期外不再触发
+// 有效期在任务更新时可能改变，每次都从 job 中读取
+type activeSchedule struct {
+	cron.Schedule
+	job *Job
+}
+
+func (s *activeSchedule) Next(t time.Time) time.Time {
+	if start := s.job.StartAt; start != nil && t.Before(*start) {
+		t = start.Add(-time.Nanosecond)
+	}
+
+	next := s.Schedule.Next(t)
+	if end := s.job.EndAt; end != nil && next.After(*end) {
+		return time.Time{}
+	}
+	return next
+}
+
+// ActiveSchedule 用于加入 cron 的定时器，任务设置了有效期时只在有效期内触发
+func (c *Cmd) ActiveSchedule() cron.Schedule {
+	if c.Job.StartAt == nil && c.Job.EndAt == nil {
+		return c.JobRule.Schedule
+	}
+	return &activeSchedule{Schedule: c.JobRule.Schedule, job: c.Job}
+}
+
+// ExpireTimer 在任务的结束时间后暂停任务，没有设置结束时间时返回 nil
+// 结束时间后定时器不再触发，不会执行到执行前的检查，需要单独计时
+func (c *Cmd) ExpireTimer() *time.Timer {
+	if c.Job.EndAt == nil {
+		return nil
+	}
+	return time.AfterFunc(time.Until(*c.Job.EndAt)+time.Second, func() {
+		c.Job.checkActiveTime(time.Now())
+	})
+}
+
+// 执行前检查任务的有效期，返回 false 时不执行
+// 任务过期后自动暂停
+func (j *Job) checkActiveTime(t time.Time) bool {
+	switch j.ActiveState(t, 0) {
+	case JobPending:
+		return false
+	case JobExpired:
+		j.expire(JobExpired)
+		return false
+	}
+	return true
+}
+
+// RunsKey 记录任务执行次数的 key
+func RunsKey(jobID string) string {
+	return conf.Config.Lock + "runs/" + jobID
+}
+
+// 占用一次执行次数，达到 MaxRuns 时返回 false 并自动暂停任务
+// 每个结点的每次执行都计数，重试不计数
+func (j *Job) takeRun() bool {
+	if j.MaxRuns <= 0 {
+		return true
+	}
+
+	key := RunsKey(j.ID)
+	for {
+		resp, err := DefalutClient.Get(key)
+		if err != nil {
+			log.Warnf("job[%s] get runs err: %s", j.Key(), err.Error())
+			return false
+		}
+
+		var runs, rev int64
+		if len(resp.Kvs) > 0 {
+			runs, _ = strconv.ParseInt(string(resp.Kvs[0].Value), 10, 64)
+			rev = resp.Kvs[0].ModRevision
+		}
+		if runs >= j.MaxRuns {
+			j.expire(JobExhausted)
+			return false
+		}
+
+		// key 不存在时 ModRevision 为 0
+		ctx, cancel := NewEtcdTimeoutContext(DefalutClient)
+		tresp, err := DefalutClient.Txn(ctx).
+			If(client.Compare(client.ModRevision(key), "=", rev)).
+			Then(client.OpPut(key, strconv.FormatInt(runs+1, 10))).
+			Commit()
+		cancel()
+		if err != nil {
+			log.Warnf("job[%s] increase runs err: %s", j.Key(), err.Error())
+			return false
+		}
+		if !tresp.Succeeded {
+			continue
+		}
+
+		if runs+1 == j.MaxRuns {
+			j.expire(JobExhausted)
+		}
+		return true
+	}
+}
+
+// GetJobRuns 任务已执行的次数，key 为任务 id
+func GetJobRuns() (map[string]int64, error) {
+	prefix := RunsKey("")
+	resp, err := DefalutClient.Get(prefix, client.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	runs := make(map[string]int64, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		n, _ := strconv.ParseInt(string(kv.Value), 10, 64)
+		runs[strings.TrimPrefix(string(kv.Key), prefix)] = n
+	}
+	return runs, nil
+}
+
+// ResetsRuns 任务从暂停恢复或修改了 MaxRuns 时，执行次数重新计数
+// prev 为更新前的任务，新建的任务为 nil
+func (j *Job) ResetsRuns(prev *Job) bool {
+	if prev == nil || (j.MaxRuns <= 0 && prev.MaxRuns <= 0) {
+		return false
+	}
+	return (prev.Pause && !j.Pause) || j.MaxRuns != prev.MaxRuns
+}
+
+// DelJobRuns 删除任务的执行次数
+func DelJobRuns(jobID string) error {
+	_, err := DefalutClient.Delete(RunsKey(jobID))
+	return err
+}
+
+// 过期或达到最大执行次数后，通过更新 etcd 暂停任务
+func (j *Job) expire(state string) {
+	job, rev, err := GetJobAndRev(j.Group, j.ID)
+	if err != nil {
+		log.Warnf("job[%s] %s, get job err: %s", j.Key(), state, err.Error())
+		return
+	}
+	if job.Pause {
+		return
+	}
+
+	job.Pause = true
+	b, err := json.Marshal(job)
+	if err != nil {
+		log.Warnf("job[%s] %s, marshal job err: %s", j.Key(), state, err.Error())
+		return
+	}
+
+	// 其它结点已暂停任务时，rev 会改变
+	if _, err = DefalutClient.PutWithModRev(job.Key(), string(b), rev); err != nil {
+		if err != ErrValueMayChanged {
+			log.Warnf("job[%s] %s, pause job err: %s", j.Key(), state, err.Error())
+		}
+		return
+	}
+	log.Infof("job[%s] %s, paused", j.Key(), state)
+}
@@ -0,0 +1,139 @@
+package cronsun
+
+import (
+	"testing"
+	"time"
+
+	"cronsun/node/cron"
+)
+
+func TestJobActiveState(t *testing.T) {
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.Local)
+	end := time.Date(2018, 2, 1, 0, 0, 0, 0, time.Local)
+	tests := []struct {
+		start, end *time.Time
+		maxRuns    int64
+		t          time.Time
+		runs       int64
+		expected   string
+	}{
+		{nil, nil, 0, start, 100, JobActive},
+		{&start, &end, 0, start.Add(-time.Second), 0, JobPending},
+		{&start, &end, 0, start, 0, JobActive},
+		{&start, &end, 0, end, 0, JobActive},
+		{&start, &end, 0, end.Add(time.Second), 0, JobExpired},
+		{nil, nil, 3, start, 2, JobActive},
+		{nil, nil, 3, start, 3, JobExhausted},
+		{&start, nil, 3, start.Add(-time.Second), 3, JobExhausted},
+	}
+
+	for i, test := range tests {
+		j := &Job{StartAt: test.start, EndAt: test.end, MaxRuns: test.maxRuns}
+		if state := j.ActiveState(test.t, test.runs); state != test.expected {
+			t.Errorf("#%d: expected %s, got %s", i, test.expected, state)
+		}
+	}
+
+	j := &Job{StartAt: &end, EndAt: &start}
+	if err := j.checkActive(); err != ErrIllegalJobActiveTime {
+		t.Errorf("expected %v, got %v", ErrIllegalJobActiveTime, err)
+	}
+	j = &Job{StartAt: &time.Time{}, MaxRuns: -1}
+	if err := j.checkActive(); err != nil || j.StartAt != nil || j.MaxRuns != 0 {
+		t.Errorf("expected zero start time and negative max runs cleared, got %v %v %d", err, j.StartAt, j.MaxRuns)
+	}
+}
+
+func TestActiveSchedule(t *testing.T) {
+	sch, err := cron.Parse("0 0 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Cmd{Job: &Job{}, JobRule: &JobRule{Schedule: sch}}
+	if c.ActiveSchedule() != sch {
+		t.Error("expected the rule schedule without active time")
+	}
+
+	start := time.Date(2018, 1, 1, 10, 0, 0, 0, time.Local)
+	end := time.Date(2018, 1, 1, 12, 0, 0, 0, time.Local)
+	c.Job.StartAt, c.Job.EndAt = &start, &end
+	active := c.ActiveSchedule()
+	tests := []struct {
+		t, expected time.Time
+	}{
+		{start.Add(-5 * time.Hour), start},
+		{start, start.Add(time.Hour)},
+		{start.Add(time.Hour), end},
+		{end, time.Time{}},
+	}
+	for _, test := range tests {
+		if next := active.Next(test.t); !next.Equal(test.expected) {
+			t.Errorf("%s: expected %s, got %s", test.t, test.expected, next)
+		}
+	}
+
+	// 有效期改变后马上生效
+	later := end.Add(time.Hour)
+	c.Job.EndAt = &later
+	if next := active.Next(end); !next.Equal(later) {
+		t.Errorf("expected %s after changing end time, got %s", later, next)
+	}
+}
+
+func TestGetNextRunTimeInActiveTime(t *testing.T) {
+	start := time.Now().Add(48 * time.Hour)
+	j := &Job{Rules: []*JobRule{{Timer: "0 0 * * * *"}}, StartAt: &start}
+	if next := j.GetNextRunTime(); next.Before(start) || next.After(start.Add(time.Hour)) {
+		t.Errorf("expected next run time after %s, got %s", start, next)
+	}
+
+	end := time.Now().Add(-time.Hour)
+	j = &Job{Rules: []*JobRule{{Timer: "0 0 * * * *"}}, EndAt: &end}
+	if next := j.GetNextRunTime(); !next.IsZero() {
+		t.Errorf("expected no next run time after end time, got %s", next)
+	}
+}
+
+func TestResetsRuns(t *testing.T) {
+	tests := []struct {
+		prev     *Job
+		job      *Job
+		expected bool
+	}{
+		{nil, &Job{MaxRuns: 3}, false},
+		{&Job{Pause: true}, &Job{}, false},
+		{&Job{MaxRuns: 3}, &Job{MaxRuns: 3}, false},
+		{&Job{MaxRuns: 3, Pause: true}, &Job{MaxRuns: 3, Pause: true}, false},
+		{&Job{MaxRuns: 3}, &Job{MaxRuns: 3, Pause: true}, false},
+		// 达到最大执行次数后恢复执行
+		{&Job{MaxRuns: 3, Pause: true}, &Job{MaxRuns: 3}, true},
+		// 修改最大执行次数
+		{&Job{MaxRuns: 3, Pause: true}, &Job{MaxRuns: 5, Pause: true}, true},
+		{&Job{MaxRuns: 3}, &Job{MaxRuns: 0}, true},
+		{&Job{}, &Job{MaxRuns: 3}, true},
+	}
+
+	for i, test := range tests {
+		if r := test.job.ResetsRuns(test.prev); r != test.expected {
+			t.Errorf("#%d: expected %v, got %v", i, test.expected, r)
+		}
+	}
+}
+
+func TestExpireTimer(t *testing.T) {
+	c := &Cmd{Job: &Job{}, JobRule: &JobRule{}}
+	if tm := c.ExpireTimer(); tm != nil {
+		t.Error("expected no expire timer without end time")
+	}
+
+	end := time.Now().Add(time.Hour)
+	c.Job.EndAt = &end
+	tm := c.ExpireTimer()
+	if tm == nil {
+		t.Fatal("expected an expire timer with end time")
+	}
+	if !tm.Stop() {
+		t.Error("expected the expire timer to wait for the end time")
+	}
+}
@@ -63,7 +63,7 @@ func main() {
 	// 注册退出事件
 	event.On(event.EXIT, n.Stop, conf.Exit, cronsun.Exit)
 	// 注册监听配置更新事件
-	event.On(event.WAIT, cronsun.Reload)
+	event.On(event.WAIT, cronsun.Reload, n.ReloadLabels)
 	// 监听退出信号
 	event.Wait()
 	// 处理退出事件
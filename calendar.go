@@ -0,0 +1,340 @@
+package cronsun
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	client "github.com/coreos/etcd/clientv3"
+
+	"cronsun/conf"
+	"cronsun/log"
+)
+
+const calendarDateLayout = "2006-01-02"
+
+// 日历，如交易所节假日、封网期
+// 规则可以引用日历，只在日历包含的日期执行，或者不在日历包含的日期执行
+// 注册到 /cronsun/calendar/<id>
+type Calendar struct {
+	ID    string          `json:"id"`
+	Name  string          `json:"name"`
+	Dates []*CalendarDate `json:"dates"`
+}
+
+// 日历包含的日期或日期范围，格式为 2006-01-02
+// End 为空时只包含 Start 一天
+type CalendarDate struct {
+	Start string `json:"start"`
+	End   string `json:"end,omitempty"`
+	Note  string `json:"note,omitempty"`
+}
+
+func (d *CalendarDate) check() error {
+	d.Start, d.End = strings.TrimSpace(d.Start), strings.TrimSpace(d.End)
+	d.Note = strings.TrimSpace(d.Note)
+	if _, err := time.Parse(calendarDateLayout, d.Start); err != nil {
+		return ErrIllegalCalendarDate
+	}
+
+	if len(d.End) == 0 || d.End == d.Start {
+		d.End = ""
+		return nil
+	}
+	if _, err := time.Parse(calendarDateLayout, d.End); err != nil || d.End < d.Start {
+		return ErrIllegalCalendarDate
+	}
+	return nil
+}
+
+// 日期格式固定，可以直接比较字符串
+func (d *CalendarDate) contains(date string) bool {
+	if len(d.End) == 0 {
+		return date == d.Start
+	}
+	return d.Start <= date && date <= d.End
+}
+
+func (c *Calendar) Check() error {
+	c.ID = strings.TrimSpace(c.ID)
+	if !IsValidAsKeyPath(c.ID) {
+		return ErrIllegalCalendarId
+	}
+
+	c.Name = strings.TrimSpace(c.Name)
+	if len(c.Name) == 0 {
+		return ErrEmptyCalendarName
+	}
+
+	for _, d := range c.Dates {
+		if err := d.check(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Contains 返回包含 t 所在日期的日期范围，按 t 的时区计算日期
+func (c *Calendar) Contains(t time.Time) *CalendarDate {
+	date := t.Format(calendarDateLayout)
+	for _, d := range c.Dates {
+		if d.contains(date) {
+			return d
+		}
+	}
+	return nil
+}
+
+func CalendarKey(id string) string {
+	return conf.Config.Calendar + id
+}
+
+func (c *Calendar) Key() string {
+	return CalendarKey(c.ID)
+}
+
+func (c *Calendar) Put(modRev int64) (*client.PutResponse, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return DefalutClient.PutWithModRev(c.Key(), string(b), modRev)
+}
+
+func GetCalendarById(id string) (c *Calendar, err error) {
+	if len(id) == 0 {
+		return
+	}
+	resp, err := DefalutClient.Get(CalendarKey(id))
+	if err != nil || resp.Count == 0 {
+		return
+	}
+
+	err = json.Unmarshal(resp.Kvs[0].Value, &c)
+	return
+}
+
+func GetCalendars() (cals []*Calendar, err error) {
+	resp, err := DefalutClient.Get(conf.Config.Calendar, client.WithPrefix())
+	if err != nil {
+		return
+	}
+
+	cals = make([]*Calendar, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		c, e := GetCalendarFromKv(kv.Key, kv.Value)
+		if e != nil {
+			log.Warnf(e.Error())
+			continue
+		}
+		cals = append(cals, c)
+	}
+	return
+}
+
+func DeleteCalendarById(id string) (*client.DeleteResponse, error) {
+	return DefalutClient.Delete(CalendarKey(id))
+}
+
+func WatchCalendars() client.WatchChan {
+	return DefalutClient.Watch(conf.Config.Calendar, client.WithPrefix())
+}
+
+func GetCalendarFromKv(key, value []byte) (c *Calendar, err error) {
+	c = new(Calendar)
+	if err = json.Unmarshal(value, c); err != nil {
+		err = fmt.Errorf("calendar[%s] umarshal err: %s", string(key), err.Error())
+	}
+	return
+}
+
+// 结点使用的日历，由 node 在启动和监听日历时维护
+var calendars = struct {
+	sync.RWMutex
+	cals map[string]*Calendar
+}{cals: make(map[string]*Calendar)}
+
+// LoadCalendars 读取所有日历
+func LoadCalendars() error {
+	cals, err := GetCalendars()
+	if err != nil {
+		return err
+	}
+
+	calendars.Lock()
+	calendars.cals = make(map[string]*Calendar, len(cals))
+	for _, c := range cals {
+		calendars.cals[c.ID] = c
+	}
+	calendars.Unlock()
+	return nil
+}
+
+func PutCalendar(c *Calendar) {
+	calendars.Lock()
+	calendars.cals[c.ID] = c
+	calendars.Unlock()
+}
+
+func DelCalendar(id string) {
+	calendars.Lock()
+	delete(calendars.cals, id)
+	calendars.Unlock()
+}
+
+// 返回第一个包含 t 所在日期的日历，不存在的日历视为不包含任何日期
+func findCalendar(ids []string, t time.Time) (*Calendar, *CalendarDate) {
+	calendars.RLock()
+	defer calendars.RUnlock()
+	for _, id := range ids {
+		c, ok := calendars.cals[id]
+		if !ok {
+			continue
+		}
+		if d := c.Contains(t); d != nil {
+			return c, d
+		}
+	}
+	return nil, nil
+}
+
+// 去掉空白的 id
+func trimIDs(ids []string) []string {
+	list := ids[:0]
+	for _, id := range ids {
+		if id = strings.TrimSpace(id); len(id) > 0 {
+			list = append(list, id)
+		}
+	}
+	return list
+}
+
+// 按规则引用的日历判断 t 时刻的触发是否被禁止，返回禁止的原因
+// 日期按规则的时区计算
+func (rule *JobRule) suppressed(t time.Time) string {
+	t = t.In(rule.Location())
+	if len(rule.IncludeCalendars) > 0 {
+		if c, _ := findCalendar(rule.IncludeCalendars, t); c == nil {
+			return fmt.Sprintf("%s not in calendars%v", t.Format(calendarDateLayout), rule.IncludeCalendars)
+		}
+	}
+
+	if c, d := findCalendar(rule.ExcludeCalendars, t); c != nil {
+		msg := fmt.Sprintf("%s in calendar[%s]", t.Format(calendarDateLayout), c.Name)
+		if len(d.Note) > 0 {
+			msg += " " + d.Note
+		}
+		return msg
+	}
+	return ""
+}
+
+// ParseICalendar 从 iCalendar 文件中读取日期，每个 VEVENT 为一个日期范围
+// 只使用 DTSTART、DTEND 和 SUMMARY，不支持 RRULE 等重复规则
+func ParseICalendar(r io.Reader) ([]*CalendarDate, error) {
+	var (
+		lines   []string
+		scanner = bufio.NewScanner(r)
+	)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		// 以空格或 tab 开头的行是上一行的折行
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var (
+		dates   []*CalendarDate
+		event   bool
+		start   time.Time
+		end     time.Time
+		allDay  bool
+		summary string
+	)
+	for i, line := range lines {
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			continue
+		}
+		name, value, params := line[:colon], strings.TrimSpace(line[colon+1:]), ""
+		if semi := strings.Index(name, ";"); semi >= 0 {
+			name, params = name[:semi], name[semi:]
+		}
+		name = strings.ToUpper(name)
+
+		switch {
+		case name == "BEGIN" && strings.ToUpper(value) == "VEVENT":
+			event, start, end, allDay, summary = true, time.Time{}, time.Time{}, false, ""
+		case !event:
+		case name == "DTSTART":
+			t, dateOnly, err := parseICalTime(value, params)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", i+1, err.Error())
+			}
+			start, allDay = t, dateOnly
+		case name == "DTEND":
+			t, _, err := parseICalTime(value, params)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", i+1, err.Error())
+			}
+			end = t
+		case name == "SUMMARY":
+			summary = strings.NewReplacer(`\,`, ",", `\;`, ";", `\n`, " ", `\N`, " ", `\\`, `\`).Replace(value)
+		case name == "END" && strings.ToUpper(value) == "VEVENT":
+			event = false
+			if start.IsZero() {
+				return nil, fmt.Errorf("line %d: event without DTSTART", i+1)
+			}
+
+			d := &CalendarDate{Start: start.Format(calendarDateLayout), Note: summary}
+			if !end.IsZero() {
+				// 全天事件的 DTEND 不包含在事件内
+				if allDay {
+					end = end.AddDate(0, 0, -1)
+				}
+				if e := end.Format(calendarDateLayout); e > d.Start {
+					d.End = e
+				}
+			}
+			dates = append(dates, d)
+		}
+	}
+	return dates, nil
+}
+
+// 解析 DATE 或 DATE-TIME 值，带时区的时间转换为对应时区的日期
+func parseICalTime(value, params string) (t time.Time, dateOnly bool, err error) {
+	if len(value) == 8 {
+		t, err = time.Parse("20060102", value)
+		return t, true, err
+	}
+
+	loc := time.Local
+	if strings.HasSuffix(value, "Z") {
+		loc = time.UTC
+		value = strings.TrimSuffix(value, "Z")
+	} else if i := strings.Index(strings.ToUpper(params), ";TZID="); i >= 0 {
+		tz := params[i+len(";TZID="):]
+		if j := strings.Index(tz, ";"); j >= 0 {
+			tz = tz[:j]
+		}
+		if l, e := time.LoadLocation(strings.Trim(tz, `"`)); e == nil {
+			loc = l
+		}
+	}
+
+	t, err = time.ParseInLocation("20060102T150405", value, loc)
+	return t, false, err
+}
@@ -0,0 +1,131 @@
+package cronsun
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCalendarCheck(t *testing.T) {
+	tests := []struct {
+		start, end string
+		err        error
+	}{
+		{"2018-01-01", "", nil},
+		{" 2018-01-01 ", "2018-01-01", nil},
+		{"2018-01-01", "2018-01-07", nil},
+		{"2018-1-1", "", ErrIllegalCalendarDate},
+		{"2018-01-07", "2018-01-01", ErrIllegalCalendarDate},
+		{"2018-01-01", "tomorrow", ErrIllegalCalendarDate},
+	}
+
+	for _, test := range tests {
+		c := &Calendar{ID: "holiday", Name: "holiday", Dates: []*CalendarDate{{Start: test.start, End: test.end}}}
+		if err := c.Check(); err != test.err {
+			t.Errorf("%q-%q: expected %v, got %v", test.start, test.end, test.err, err)
+		}
+	}
+
+	if err := (&Calendar{ID: "a/b", Name: "x"}).Check(); err != ErrIllegalCalendarId {
+		t.Errorf("expected %v, got %v", ErrIllegalCalendarId, err)
+	}
+	if err := (&Calendar{ID: "x", Name: " "}).Check(); err != ErrEmptyCalendarName {
+		t.Errorf("expected %v, got %v", ErrEmptyCalendarName, err)
+	}
+}
+
+func TestJobRuleSuppressed(t *testing.T) {
+	PutCalendar(&Calendar{ID: "holiday", Name: "Holiday", Dates: []*CalendarDate{
+		{Start: "2018-01-01", Note: "New Year"},
+		{Start: "2018-02-15", End: "2018-02-21", Note: "Spring Festival"},
+	}})
+	PutCalendar(&Calendar{ID: "freeze", Name: "Freeze", Dates: []*CalendarDate{{Start: "2018-03-25", End: "2018-03-31"}}})
+	defer DelCalendar("holiday")
+	defer DelCalendar("freeze")
+
+	day := func(s string) time.Time {
+		t, _ := time.ParseInLocation("2006-01-02 15:04", s, time.Local)
+		return t
+	}
+	tests := []struct {
+		include, exclude []string
+		t                time.Time
+		suppressed       string
+	}{
+		{nil, nil, day("2018-01-01 10:00"), ""},
+		{nil, []string{"holiday"}, day("2018-01-01 10:00"), "in calendar[Holiday] New Year"},
+		{nil, []string{"holiday"}, day("2018-01-02 10:00"), ""},
+		{nil, []string{"holiday"}, day("2018-02-18 10:00"), "Spring Festival"},
+		{nil, []string{"holiday"}, day("2018-02-22 00:00"), ""},
+		{nil, []string{"missing", "freeze"}, day("2018-03-31 23:59"), "in calendar[Freeze]"},
+		{[]string{"freeze"}, nil, day("2018-03-28 10:00"), ""},
+		{[]string{"freeze"}, nil, day("2018-04-01 10:00"), "not in calendars[freeze]"},
+		{[]string{"missing"}, nil, day("2018-04-01 10:00"), "not in calendars[missing]"},
+	}
+
+	for i, test := range tests {
+		rule := &JobRule{IncludeCalendars: test.include, ExcludeCalendars: test.exclude}
+		msg := rule.suppressed(test.t)
+		if len(test.suppressed) == 0 && len(msg) > 0 || !strings.Contains(msg, test.suppressed) {
+			t.Errorf("#%d: expected %q, got %q", i, test.suppressed, msg)
+		}
+	}
+
+	// 按规则的时区计算日期
+	rule := &JobRule{Timer: "0 0 9 * * *", Timezone: "Asia/Tokyo", ExcludeCalendars: []string{"holiday"}}
+	if err := rule.Valid(); err != nil {
+		t.Fatal(err)
+	}
+	if msg := rule.suppressed(time.Date(2017, 12, 31, 16, 0, 0, 0, time.UTC)); len(msg) == 0 {
+		t.Error("expected 2018-01-01 in Asia/Tokyo suppressed")
+	}
+}
+
+func TestParseICalendar(t *testing.T) {
+	ics := "BEGIN:VCALENDAR\r\n" +
+		"VERSION:2.0\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"DTSTART;VALUE=DATE:20180101\r\n" +
+		"DTEND;VALUE=DATE:20180102\r\n" +
+		"SUMMARY:New Year\\, holiday\r\n" +
+		"END:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"DTSTART;VALUE=DATE:20180215\r\n" +
+		"DTEND;VALUE=DATE:20180222\r\n" +
+		"SUMMARY:Spring\r\n" +
+		"  Festival\r\n" +
+		"END:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"DTSTART;TZID=Asia/Shanghai:20180325T090000\r\n" +
+		"DTEND;TZID=Asia/Shanghai:20180326T180000\r\n" +
+		"SUMMARY:Freeze\r\n" +
+		"END:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"DTSTART:20180501T000000Z\r\n" +
+		"END:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+
+	dates, err := ParseICalendar(strings.NewReader(ics))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []CalendarDate{
+		{Start: "2018-01-01", Note: "New Year, holiday"},
+		{Start: "2018-02-15", End: "2018-02-21", Note: "Spring Festival"},
+		{Start: "2018-03-25", End: "2018-03-26", Note: "Freeze"},
+		{Start: "2018-05-01"},
+	}
+	if len(dates) != len(expected) {
+		t.Fatalf("expected %d dates, got %d", len(expected), len(dates))
+	}
+	for i := range dates {
+		if *dates[i] != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], *dates[i])
+		}
+	}
+
+	if _, err = ParseICalendar(strings.NewReader("BEGIN:VEVENT\nDTSTART:2018\nEND:VEVENT\n")); err == nil {
+		t.Error("expected error for invalid DTSTART")
+	}
+}
@@ -0,0 +1,255 @@
+package cronsun
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	client "github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+)
+
+// 内存中的 etcd，只实现 Get、Put、lease、比较版本的 Txn 和删除事件的 watch
+type fakeEtcd struct {
+	client.KV
+	client.Lease
+	client.Watcher
+
+	mu       sync.Mutex
+	rev      int64
+	lease    client.LeaseID
+	kvs      map[string]*mvccpb.KeyValue
+	leases   map[client.LeaseID]bool
+	deletes  []*mvccpb.KeyValue
+	watches  []*fakeWatch
+	aliveErr error // KeepAliveOnce 返回的错误
+	txnErr   error // Txn 提交时返回的错误
+}
+
+type fakeWatch struct {
+	key, end []byte
+	ch       chan client.WatchResponse
+}
+
+// 替换 DefalutClient，测试结束后恢复
+func newFakeEtcd(t *testing.T) *fakeEtcd {
+	f := &fakeEtcd{
+		kvs:    make(map[string]*mvccpb.KeyValue),
+		leases: make(map[client.LeaseID]bool),
+	}
+	dc := DefalutClient
+	DefalutClient = &Client{
+		Client:     &client.Client{KV: f, Lease: f, Watcher: f},
+		reqTimeout: time.Second,
+	}
+	t.Cleanup(func() { DefalutClient = dc })
+	return f
+}
+
+// 读取 OpOption 设置的未导出字段
+func opField(op client.Op, name string) reflect.Value {
+	return reflect.ValueOf(op).FieldByName(name)
+}
+
+func inRange(k, key, end []byte) bool {
+	if len(end) == 0 {
+		return bytes.Equal(k, key)
+	}
+	return bytes.Compare(k, key) >= 0 && bytes.Compare(k, end) < 0
+}
+
+func (f *fakeEtcd) Put(ctx context.Context, key, val string, opts ...client.OpOption) (*client.PutResponse, error) {
+	op := client.OpPut(key, val, opts...)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.rev++
+	kv, ok := f.kvs[key]
+	if !ok {
+		kv = &mvccpb.KeyValue{Key: []byte(key), CreateRevision: f.rev}
+		f.kvs[key] = kv
+	}
+	kv.Value, kv.ModRevision = []byte(val), f.rev
+	kv.Lease = opField(op, "leaseID").Int()
+	return &client.PutResponse{Header: &pb.ResponseHeader{Revision: f.rev}}, nil
+}
+
+func (f *fakeEtcd) Get(ctx context.Context, key string, opts ...client.OpOption) (*client.GetResponse, error) {
+	op := client.OpGet(key, opts...)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var kvs []*mvccpb.KeyValue
+	for _, kv := range f.kvs {
+		if inRange(kv.Key, op.KeyBytes(), op.RangeBytes()) {
+			c := *kv
+			if op.IsKeysOnly() {
+				c.Value = nil
+			}
+			kvs = append(kvs, &c)
+		}
+	}
+	sort.Slice(kvs, func(i, k int) bool {
+		if sop := opField(op, "sort"); !sop.IsNil() && client.SortTarget(sop.Elem().FieldByName("Target").Int()) == client.SortByCreateRevision {
+			return kvs[i].CreateRevision < kvs[k].CreateRevision
+		}
+		return bytes.Compare(kvs[i].Key, kvs[k].Key) < 0
+	})
+
+	count := int64(len(kvs))
+	if limit := opField(op, "limit").Int(); limit > 0 && int64(len(kvs)) > limit {
+		kvs = kvs[:limit]
+	}
+	return &client.GetResponse{Header: &pb.ResponseHeader{Revision: f.rev}, Kvs: kvs, Count: count}, nil
+}
+
+func (f *fakeEtcd) Txn(ctx context.Context) client.Txn {
+	return &fakeTxn{f: f}
+}
+
+// 只支持比较 key 的创建和修改版本，成功后执行 Put
+type fakeTxn struct {
+	f    *fakeEtcd
+	cmps []client.Cmp
+	ops  []client.Op
+}
+
+func (t *fakeTxn) If(cs ...client.Cmp) client.Txn   { t.cmps = cs; return t }
+func (t *fakeTxn) Then(ops ...client.Op) client.Txn { t.ops = ops; return t }
+func (t *fakeTxn) Else(ops ...client.Op) client.Txn { return t }
+
+func (t *fakeTxn) Commit() (*client.TxnResponse, error) {
+	f := t.f
+	f.mu.Lock()
+	if f.txnErr != nil {
+		f.mu.Unlock()
+		return nil, f.txnErr
+	}
+	for _, c := range t.cmps {
+		var rev int64
+		if kv, ok := f.kvs[string(c.KeyBytes())]; ok {
+			rev = kv.CreateRevision
+			if c.Target == pb.Compare_MOD {
+				rev = kv.ModRevision
+			}
+		}
+		var expected int64
+		switch u := c.TargetUnion.(type) {
+		case *pb.Compare_CreateRevision:
+			expected = u.CreateRevision
+		case *pb.Compare_ModRevision:
+			expected = u.ModRevision
+		}
+		if rev != expected {
+			f.mu.Unlock()
+			return &client.TxnResponse{}, nil
+		}
+	}
+	f.mu.Unlock()
+
+	resp := &client.TxnResponse{Succeeded: true}
+	for _, op := range t.ops {
+		presp, _ := f.Put(context.Background(), string(op.KeyBytes()), string(op.ValueBytes()),
+			client.WithLease(client.LeaseID(opField(op, "leaseID").Int())))
+		resp.Responses = append(resp.Responses, &pb.ResponseOp{Response: &pb.ResponseOp_ResponsePut{ResponsePut: (*pb.PutResponse)(presp)}})
+	}
+	return resp, nil
+}
+
+func (f *fakeEtcd) Grant(ctx context.Context, ttl int64) (*client.LeaseGrantResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lease++
+	f.leases[f.lease] = true
+	return &client.LeaseGrantResponse{ID: f.lease, TTL: ttl}, nil
+}
+
+func (f *fakeEtcd) Revoke(ctx context.Context, id client.LeaseID) (*client.LeaseRevokeResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.leases[id] {
+		return nil, rpctypes.ErrLeaseNotFound
+	}
+	delete(f.leases, id)
+
+	for k, kv := range f.kvs {
+		if client.LeaseID(kv.Lease) != id {
+			continue
+		}
+		f.rev++
+		delete(f.kvs, k)
+		del := &mvccpb.KeyValue{Key: kv.Key, ModRevision: f.rev}
+		f.deletes = append(f.deletes, del)
+		for _, w := range f.watches {
+			if inRange(del.Key, w.key, w.end) {
+				w.ch <- client.WatchResponse{Events: []*client.Event{{Type: mvccpb.DELETE, Kv: del}}}
+			}
+		}
+	}
+	return &client.LeaseRevokeResponse{}, nil
+}
+
+func (f *fakeEtcd) KeepAliveOnce(ctx context.Context, id client.LeaseID) (*client.LeaseKeepAliveResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.aliveErr != nil {
+		return nil, f.aliveErr
+	}
+	if !f.leases[id] {
+		return nil, rpctypes.ErrLeaseNotFound
+	}
+	return &client.LeaseKeepAliveResponse{ID: id, TTL: semaphoreTtl}, nil
+}
+
+func (f *fakeEtcd) setAliveErr(err error) {
+	f.mu.Lock()
+	f.aliveErr = err
+	f.mu.Unlock()
+}
+
+func (f *fakeEtcd) leaseCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.leases)
+}
+
+// 只发送删除事件，从 WithRev 指定的版本开始
+func (f *fakeEtcd) Watch(ctx context.Context, key string, opts ...client.OpOption) client.WatchChan {
+	op := client.OpGet(key, opts...)
+	w := &fakeWatch{key: op.KeyBytes(), end: op.RangeBytes(), ch: make(chan client.WatchResponse, 16)}
+
+	f.mu.Lock()
+	for _, del := range f.deletes {
+		if del.ModRevision >= opField(op, "rev").Int() && inRange(del.Key, w.key, w.end) {
+			w.ch <- client.WatchResponse{Events: []*client.Event{{Type: mvccpb.DELETE, Kv: del}}}
+		}
+	}
+	f.watches = append(f.watches, w)
+	f.mu.Unlock()
+
+	ch := make(chan client.WatchResponse)
+	go func() {
+		defer close(ch)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case wresp := <-w.ch:
+				select {
+				case ch <- wresp:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return ch
+}
+
+func (f *fakeEtcd) Close() error { return nil }
@@ -56,9 +56,16 @@ type Conf struct {
 	Lock    string // job lock 路径
 	Group   string // 节点分组
 	Noticer string // 通知
+	// 日历，用于节假日等日期禁止或只允许任务执行
+	Calendar string
+	// 任务的触发 token
+	Token string
 
 	PIDFile  string
 	UUIDFile string
+	// node 的标签，用于任务规则的标签选择器
+	// 另外会自动设置 os、arch、hostname、ip 标签
+	Labels map[string]string
 
 	Ttl        int64 // 节点超时时间，单位秒
 	ReqTimeout int   // 请求超时时间，单位秒
@@ -72,6 +79,12 @@ type Conf struct {
 	// 默认 300
 	LockTtl int64
 
+	// 任务输出的记录设置
+	JobOutput *JobOutputConf
+	// 记录每个规则最后一次触发时间的文件，用于结点重启后补执行错过的任务
+	// 为空时使用最后一条执行记录的时间
+	FireStateFile string
+
 	Etcd *etcdConfig
 	Mgo  *db.Config
 	Web  *webConfig
@@ -121,6 +134,22 @@ type MailConf struct {
 	*gomail.Dialer
 }
 
+type JobOutputConf struct {
+	// 标准输出和标准错误分别记录开头和结尾部分，中间部分会被丢弃
+	// 单位 KB，默认都为 64
+	HeadKB int64
+	TailKB int64
+	// 输出超出记录长度时，把完整输出写到 node 的此目录下
+	// 为空时不写入
+	SpillDir string
+	// 写入文件的最大长度，单位 MB，0 为不限制
+	SpillMaxMB int64
+	// 文件保留的天数，默认与执行记录的保留天数 Web.LogCleaner.ExpirationDays 相同
+	SpillMaxAgeDays int
+	// 目录中文件的最大总长度，超出时从最早的文件开始删除，单位 MB，0 为不限制
+	SpillDirMaxMB int64
+}
+
 type Security struct {
 	// 是不开启安全选项
 	// true 开启
@@ -132,6 +161,15 @@ type Security struct {
 	Users []string `json:"users"`
 	// 支持的执行的脚本扩展名
 	Ext []string `json:"ext"`
+	// HTTP 任务允许请求的主机，支持 *.example.com 匹配子域名
+	// 为空时不限制
+	Hosts []string `json:"hosts"`
+	// 脚本任务允许使用的解释器，如 sh, bash, python
+	// 为空时不限制
+	Interpreters []string `json:"interpreters"`
+	// 加密触发 token 的签名密钥，签名密钥加密后保存在 etcd 中
+	// 为空时不能创建需要签名的触发 token
+	TriggerKey string `json:"trigger_key"`
 }
 
 // 返回前后包含斜杆的 /a/b/ 的前缀
@@ -209,6 +247,17 @@ func (c *Conf) parse(confFile string) error {
 	if c.LockTtl < 2 {
 		c.LockTtl = 300
 	}
+	if c.JobOutput == nil {
+		c.JobOutput = new(JobOutputConf)
+	}
+	if c.JobOutput.HeadKB <= 0 {
+		c.JobOutput.HeadKB = 64
+	}
+	if c.JobOutput.TailKB <= 0 {
+		c.JobOutput.TailKB = 64
+	}
+	c.JobOutput.SpillDir = strings.TrimSpace(c.JobOutput.SpillDir)
+	c.FireStateFile = strings.TrimSpace(c.FireStateFile)
 	if c.Mail.Keepalive <= 0 {
 		c.Mail.Keepalive = 30
 	}
@@ -225,6 +274,9 @@ func (c *Conf) parse(confFile string) error {
 		if c.Web.LogCleaner.ExpirationDays <= 0 {
 			c.Web.LogCleaner.ExpirationDays = 1
 		}
+		if c.JobOutput.SpillMaxAgeDays <= 0 {
+			c.JobOutput.SpillMaxAgeDays = c.Web.LogCleaner.ExpirationDays
+		}
 	}
 
 	c.Node = cleanKeyPrefix(c.Node)
@@ -235,6 +287,14 @@ func (c *Conf) parse(confFile string) error {
 	c.Lock = cleanKeyPrefix(c.Lock)
 	c.Group = cleanKeyPrefix(c.Group)
 	c.Noticer = cleanKeyPrefix(c.Noticer)
+	if len(c.Calendar) == 0 {
+		c.Calendar = "/cronsun/calendar/"
+	}
+	c.Calendar = cleanKeyPrefix(c.Calendar)
+	if len(c.Token) == 0 {
+		c.Token = "/cronsun/token/"
+	}
+	c.Token = cleanKeyPrefix(c.Token)
 
 	return nil
 }
@@ -289,6 +349,7 @@ func (c *Conf) reload(confFile string) {
 
 	// etcd key 选项需要重启
 	cf.Node, cf.Proc, cf.Cmd, cf.Once, cf.Csctl, cf.Lock, cf.Group, cf.Noticer = c.Node, c.Proc, c.Cmd, c.Once, c.Csctl, c.Lock, c.Group, c.Noticer
+	cf.Calendar, cf.Token = c.Calendar, c.Token
 
 	*c = *cf
 	log.Infof("config file[%s] reload success", confFile)
@@ -4,6 +4,7 @@ import (
 	"context"
 	"cronsun/db"
 	"cronsun/log"
+	"errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -28,11 +29,55 @@ type JobLog struct {
 	Hostname  string             `bson:"hostname" json:"hostname"`         // 运行此次任务的节点主机名称，索引
 	IP        string             `bson:"ip" json:"ip"`                     // 运行此次任务的节点主机IP，索引
 	Command   string             `bson:"command" json:"command,omitempty"` // 执行的命令，包括参数
-	Output    string             `bson:"output" json:"output,omitempty"`   // 任务输出的所有内容
+	Output    string             `bson:"output" json:"output,omitempty"`   // 任务的标准输出，超出长度时只保留开头和结尾部分
+	Stderr    string             `bson:"stderr" json:"stderr,omitempty"`   // 任务的标准错误，超出长度时只保留开头和结尾部分
 	Success   bool               `bson:"success" json:"success"`           // 是否执行成功
+	Skipped   bool               `bson:"skipped" json:"skipped"`           // 是否跳过了本次执行，跳过时 Success 为 false
 	BeginTime time.Time          `bson:"beginTime" json:"beginTime"`       // 任务开始执行时间，精确到毫秒，索引
 	EndTime   time.Time          `bson:"endTime" json:"endTime"`           // 任务执行完毕时间，精确到毫秒
 	Cleanup   time.Time          `bson:"cleanup,omitempty" json:"-"`       // 日志清除时间标志
+
+	Truncated  bool   `bson:"truncated" json:"truncated"`                       // 输出是否被截断
+	OutputSize int64  `bson:"outputSize" json:"outputSize"`                     // 标准输出的完整长度
+	StderrSize int64  `bson:"stderrSize" json:"stderrSize"`                     // 标准错误的完整长度
+	OutputFile string `bson:"outputFile,omitempty" json:"outputFile,omitempty"` // 被截断时，node 上保存完整标准输出的文件
+	StderrFile string `bson:"stderrFile,omitempty" json:"stderrFile,omitempty"` // 被截断时，node 上保存完整标准错误的文件
+	TimedOut   bool   `bson:"timedOut" json:"timedOut"`                         // 是否因超时被结束
+	Attempt    int    `bson:"attempt" json:"attempt"`                           // 第几次执行，失败重试时递增，从 1 开始
+
+	TriggerType string    `bson:"triggerType" json:"triggerType"`           // 触发方式：cron, once, retry, depend, catchup
+	RuleID      string    `bson:"ruleId,omitempty" json:"ruleId,omitempty"` // 触发执行的定时器规则 id
+	FireTime    time.Time `bson:"fireTime" json:"fireTime"`                 // 计划执行时间，补执行时为错过的执行时间
+	ExitCode    int       `bson:"exitCode" json:"exitCode"`                 // 进程退出码，没有正常退出时为 -1，HTTP 任务为响应状态码
+	Signal      string    `bson:"signal,omitempty" json:"signal,omitempty"` // 结束进程的信号
+	UserTime    int64     `bson:"userTime" json:"userTime"`                 // 用户态 CPU 时间，单位毫秒
+	SysTime     int64     `bson:"sysTime" json:"sysTime"`                   // 内核态 CPU 时间，单位毫秒
+	MaxRSS      int64     `bson:"maxRss" json:"maxRss"`                     // 最大常驻内存，单位 KB
+
+	WindowClosed bool `bson:"windowClosed" json:"windowClosed"` // 是否因执行时间段结束被结束
+
+	DispatchedBy   string `bson:"dispatchedBy,omitempty" json:"dispatchedBy,omitempty"`     // 任一结点任务，调度本次执行的结点
+	DispatchReason string `bson:"dispatchReason,omitempty" json:"dispatchReason,omitempty"` // 任一结点任务，选择执行结点的原因
+	ExecutionID    string `bson:"executionId,omitempty" json:"executionId,omitempty"`       // 同一次执行的 id，分片任务的所有分片相同
+	ShardIndex     int    `bson:"shardIndex" json:"shardIndex"`                             // 分片序号，从 0 开始
+	ShardTotal     int    `bson:"shardTotal,omitempty" json:"shardTotal,omitempty"`         // 分片总数，非分片执行时为 0
+
+	TriggerSource string `bson:"triggerSource,omitempty" json:"triggerSource,omitempty"` // 触发来源，webhook 触发时为使用的 token
+	CallerIP      string `bson:"callerIp,omitempty" json:"callerIp,omitempty"`           // webhook 触发时调用方的 IP
+	File          string `bson:"file,omitempty" json:"file,omitempty"`                   // 文件触发时匹配的文件路径
+
+	HTTPStatus  int                 `bson:"httpStatus,omitempty" json:"httpStatus,omitempty"`   // HTTP 任务的响应状态码
+	HTTPHeaders map[string][]string `bson:"httpHeaders,omitempty" json:"httpHeaders,omitempty"` // HTTP 任务的响应头
+	HTTPTiming  *HTTPTiming         `bson:"httpTiming,omitempty" json:"httpTiming,omitempty"`   // HTTP 任务各阶段的耗时
+}
+
+// HTTP 任务各阶段的耗时，单位毫秒，没有经过的阶段为 0
+type HTTPTiming struct {
+	DNS       int64 `bson:"dns" json:"dns"`             // 域名解析
+	Connect   int64 `bson:"connect" json:"connect"`     // 建立 TCP 连接
+	TLS       int64 `bson:"tls" json:"tls"`             // TLS 握手
+	FirstByte int64 `bson:"firstByte" json:"firstByte"` // 从开始请求到收到响应的第一个字节
+	Total     int64 `bson:"total" json:"total"`         // 从开始请求到读完响应内容
 }
 
 type JobLatestLog struct {
@@ -44,6 +89,7 @@ type StatExecuted struct {
 	Total     int64  `bson:"total" json:"total"`
 	Successed int64  `bson:"successed" json:"successed"`
 	Failed    int64  `bson:"failed" json:"failed"`
+	Skipped   int64  `bson:"skipped" json:"skipped"`
 	Date      string `bson:"date" json:"date"`
 }
 
@@ -76,6 +122,8 @@ func CreateJobLog(jl JobLog, logger log.Logger) {
 	var inc = bson.M{"total": 1}
 	if jl.Success {
 		inc["successed"] = 1
+	} else if jl.Skipped {
+		inc["skipped"] = 1
 	} else {
 		inc["failed"] = 1
 	}
@@ -95,7 +143,7 @@ func CreateJobLog(jl JobLog, logger log.Logger) {
 
 }
 
-var selectForJobLogList = bson.M{"command": 0, "output": 0}
+var selectForJobLogList = bson.M{"command": 0, "output": 0, "stderr": 0}
 
 func GetJobLogList(query bson.M, page, size int, sort bson.D) (list []*JobLog, total int, err error) {
 	err = db.GetDb().WithC(Coll_JobLog, func(c *mongo.Collection) error {
@@ -146,6 +194,30 @@ func GetJobLatestLogList(query bson.M, page, size int, sort bson.D) (list []*Job
 	return
 }
 
+// 结点上任务的最后一条执行记录，没有记录时返回 nil
+func GetJobLatestLog(node, group, jobId string) (l *JobLatestLog, err error) {
+	err = db.GetDb().FindOne(Coll_JobLatestLog, bson.M{"node": node, "jobGroup": group, "jobId": jobId}, &l)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	return
+}
+
+// GetRuleLatestLog 结点上规则最后一次执行的记录，按计划执行时间排序
+// triggerTypes 为需要的触发方式
+func GetRuleLatestLog(node, group, jobId, ruleId string, triggerTypes []string) (l *JobLog, err error) {
+	query := bson.M{"node": node, "jobGroup": group, "jobId": jobId, "ruleId": ruleId, "triggerType": bson.M{"$in": triggerTypes}}
+	err = db.GetDb().WithC(Coll_JobLog, func(c *mongo.Collection) error {
+		findOptions := options.FindOne()
+		findOptions.SetSort(bson.D{{Key: "fireTime", Value: -1}})
+		return c.FindOne(context.Background(), query, findOptions).Decode(&l)
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	return
+}
+
 func GetJobLatestLogListByJobIds(jobIds []string) (m map[string]*JobLatestLog, err error) {
 	var list []*JobLatestLog
 
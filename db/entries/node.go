@@ -18,6 +18,8 @@ type Node struct {
 	PIDFile  string `bson:"-" json:"-"`
 	IP       string `bson:"ip" json:"ip"` // node ip
 	Hostname string `bson:"hostname" json:"hostname"`
+	// 结点标签，包括配置的标签和自动检测的 os、arch、hostname、ip
+	Labels map[string]string `bson:"labels" json:"labels"`
 
 	Version  string    `bson:"version" json:"version"`
 	UpTime   time.Time `bson:"up" json:"up"`     // 启动时间
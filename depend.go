@@ -0,0 +1,359 @@
+package cronsun
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	client "github.com/coreos/etcd/clientv3"
+
+	"cronsun/conf"
+	"cronsun/log"
+)
+
+// 上游任务执行结束后触发下游任务的条件
+const (
+	DependOnSuccess = "success" // 上游任务执行成功
+	DependOnFail    = "fail"    // 上游任务执行失败
+	DependOnDone    = "done"    // 上游任务执行结束，不论成功与否
+)
+
+// 任务依赖的上游任务
+type JobDepend struct {
+	Group string `json:"group"`
+	ID    string `json:"id"`
+	// 触发条件，默认为 success
+	On string `json:"on"`
+}
+
+func (d *JobDepend) Key() string {
+	return dependKey(d.Group, d.ID)
+}
+
+func (d *JobDepend) check() error {
+	if d == nil {
+		return ErrIllegalDependJob
+	}
+
+	d.ID = strings.TrimSpace(d.ID)
+	if len(d.ID) == 0 || !IsValidAsKeyPath(d.ID) {
+		return ErrIllegalDependJob
+	}
+
+	d.Group = strings.TrimSpace(d.Group)
+	if len(d.Group) == 0 {
+		d.Group = DefaultJobGroup
+	}
+	if !IsValidAsKeyPath(d.Group) {
+		return ErrIllegalDependJob
+	}
+
+	switch d.On {
+	case "":
+		d.On = DependOnSuccess
+	case DependOnSuccess, DependOnFail, DependOnDone:
+	default:
+		return ErrIllegalDependCondition
+	}
+
+	return nil
+}
+
+func (d *JobDepend) match(success bool) bool {
+	switch d.On {
+	case DependOnDone:
+		return true
+	case DependOnFail:
+		return !success
+	}
+	return success
+}
+
+func dependKey(group, id string) string {
+	return group + "/" + id
+}
+
+// 依赖关系索引，由 node 在加载和监听任务时维护
+// key: 下游任务 id
+type dependIndex struct {
+	lk   sync.RWMutex
+	jobs map[string]*Job
+}
+
+var depends = &dependIndex{
+	jobs: make(map[string]*Job),
+}
+
+// AddJobDepends 记录 job 的上游依赖，上游任务执行结束时据此触发 job
+func AddJobDepends(j *Job) {
+	depends.lk.Lock()
+	if len(j.Depends) == 0 {
+		delete(depends.jobs, j.ID)
+	} else {
+		depends.jobs[j.ID] = j
+	}
+	depends.lk.Unlock()
+}
+
+func DelJobDepends(id string) {
+	depends.lk.Lock()
+	delete(depends.jobs, id)
+	depends.lk.Unlock()
+}
+
+// 返回执行结果满足触发条件的下游任务
+func (di *dependIndex) downstream(group, id string, success bool) (jobs []*Job) {
+	key := dependKey(group, id)
+
+	di.lk.RLock()
+	defer di.lk.RUnlock()
+	for _, j := range di.jobs {
+		for _, d := range j.Depends {
+			if d.Key() == key && d.match(success) {
+				jobs = append(jobs, j)
+				break
+			}
+		}
+	}
+	return
+}
+
+// 上游任务的一次执行，多个结点执行同一次触发时相同
+func dependExecution(tr *Trigger) string {
+	if len(tr.ExecutionID) > 0 {
+		return tr.ExecutionID
+	}
+	return fmt.Sprintf("%s/%d", tr.RuleID, tr.FireTime.UnixNano())
+}
+
+// 记录上游任务的一次执行已触发下游任务的 key
+func dependTriggerKey(downstream, upstream string, tr *Trigger) string {
+	return conf.Config.Lock + "depends/" + downstream + "/" + upstream + "/" + dependExecution(tr)
+}
+
+// 通过 once 触发下游任务
+// 上游任务在多个结点执行时，每次执行只由第一个满足触发条件的结点触发一次
+func (j *Job) runDepends(tr *Trigger, success bool) {
+	for _, dj := range depends.downstream(j.Group, j.ID, success) {
+		if dj.Pause {
+			continue
+		}
+
+		ok, err := j.claimDepend(dj, tr)
+		if err != nil {
+			log.Warnf("job[%s] trigger downstream job[%s] err: %s", j.Key(), dj.Key(), err.Error())
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		// 所有结点使用相同的执行 id，任一结点和分片任务按执行 id 只调度一次
+		o := &Once{TriggerType: TriggerDepend, FireTime: time.Now(), ExecutionID: NextID()}
+		if err = putOnce(dj.Group, dj.ID, o); err != nil {
+			log.Warnf("job[%s] trigger downstream job[%s] err: %s", j.Key(), dj.Key(), err.Error())
+			continue
+		}
+		log.Infof("job[%s] triggered downstream job[%s]", j.Key(), dj.Key())
+	}
+}
+
+// 记录本次执行已触发下游任务，其它结点已触发时返回 false
+// 记录保存 ExecutionTTL 秒
+func (j *Job) claimDepend(dj *Job, tr *Trigger) (bool, error) {
+	resp, err := DefalutClient.Grant(ExecutionTTL)
+	if err != nil {
+		return false, err
+	}
+
+	key := dependTriggerKey(dj.ID, j.ID, tr)
+	ctx, cancel := NewEtcdTimeoutContext(DefalutClient)
+	tresp, err := DefalutClient.Txn(ctx).
+		If(client.Compare(client.CreateRevision(key), "=", 0)).
+		Then(client.OpPut(key, j.runOn, client.WithLease(resp.ID))).
+		Commit()
+	cancel()
+	if err != nil {
+		return false, err
+	}
+	return tresp.Succeeded, nil
+}
+
+// RunDepend 执行上游任务触发的一次执行
+// 和定时触发一样检查有效期、执行窗口、执行限制和重叠策略，任一结点和分片任务先调度
+func (c *Cmd) RunDepend(tr *Trigger) {
+	tr.RuleID = c.JobRule.ID
+	c.runTrigger(tr)
+}
+
+type DependGraphNode struct {
+	ID    string `json:"id"`
+	Group string `json:"group"`
+	Name  string `json:"name"`
+	Pause bool   `json:"pause"`
+}
+
+type DependGraphEdge struct {
+	From string `json:"from"` // 上游任务 group/id
+	To   string `json:"to"`   // 下游任务 group/id
+	On   string `json:"on"`
+}
+
+// 任务依赖图，只包含有依赖关系的任务
+type DependGraph struct {
+	Nodes []*DependGraphNode `json:"nodes"`
+	Edges []*DependGraphEdge `json:"edges"`
+}
+
+func NewDependGraph(jobs []*Job) *DependGraph {
+	g := &DependGraph{
+		Nodes: make([]*DependGraphNode, 0, 8),
+		Edges: make([]*DependGraphEdge, 0, 8),
+	}
+
+	all := make(map[string]*Job, len(jobs))
+	for _, j := range jobs {
+		all[dependKey(j.Group, j.ID)] = j
+	}
+
+	used := make(map[string]bool)
+	for _, j := range jobs {
+		for _, d := range j.Depends {
+			to := dependKey(j.Group, j.ID)
+			g.Edges = append(g.Edges, &DependGraphEdge{From: d.Key(), To: to, On: d.On})
+			used[d.Key()], used[to] = true, true
+		}
+	}
+
+	keys := make([]string, 0, len(used))
+	for k := range used {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		n := &DependGraphNode{}
+		if j, ok := all[k]; ok {
+			n.ID, n.Group, n.Name, n.Pause = j.ID, j.Group, j.Name, j.Pause
+		} else {
+			// 上游任务已被删除
+			ss := strings.SplitN(k, "/", 2)
+			n.Group, n.ID = ss[0], ss[1]
+		}
+		g.Nodes = append(g.Nodes, n)
+	}
+
+	return g
+}
+
+// 返回依赖关系中的一个环，没有环时返回 nil
+func findDependCycle(jobs []*Job) []string {
+	const (
+		white = iota
+		gray
+		black
+	)
+
+	// 上游 -> 下游
+	edges := make(map[string][]string, len(jobs))
+	for _, j := range jobs {
+		for _, d := range j.Depends {
+			edges[d.Key()] = append(edges[d.Key()], dependKey(j.Group, j.ID))
+		}
+	}
+
+	color := make(map[string]int, len(edges))
+	var path []string
+	var visit func(k string) []string
+	visit = func(k string) []string {
+		color[k] = gray
+		path = append(path, k)
+		for _, next := range edges[k] {
+			switch color[next] {
+			case gray:
+				for i := range path {
+					if path[i] == next {
+						return append(append([]string{}, path[i:]...), next)
+					}
+				}
+			case white:
+				if c := visit(next); c != nil {
+					return c
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		color[k] = black
+		return nil
+	}
+
+	keys := make([]string, 0, len(edges))
+	for k := range edges {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if color[k] == white {
+			if c := visit(k); c != nil {
+				return c
+			}
+		}
+	}
+
+	return nil
+}
+
+// GetAllJobs 获取所有任务，不做有效性验证
+func GetAllJobs() (jobs []*Job, err error) {
+	resp, err := DefalutClient.Get(conf.Config.Cmd, client.WithPrefix())
+	if err != nil {
+		return
+	}
+
+	jobs = make([]*Job, 0, resp.Count)
+	for _, kv := range resp.Kvs {
+		job := new(Job)
+		if e := json.Unmarshal(kv.Value, job); e != nil {
+			log.Warnf("job[%s] umarshal err: %s", string(kv.Key), e.Error())
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	return
+}
+
+// CheckDependCycle 检查保存 job 后依赖关系是否会形成环
+func CheckDependCycle(job *Job) error {
+	if len(job.Depends) == 0 {
+		return nil
+	}
+
+	jobs, err := GetAllJobs()
+	if err != nil {
+		return err
+	}
+
+	list := make([]*Job, 0, len(jobs)+1)
+	for _, j := range jobs {
+		if j.ID != job.ID {
+			list = append(list, j)
+		}
+	}
+	list = append(list, job)
+
+	if c := findDependCycle(list); c != nil {
+		return &DependCycleError{Path: c}
+	}
+	return nil
+}
+
+type DependCycleError struct {
+	Path []string
+}
+
+func (e *DependCycleError) Error() string {
+	return ErrDependCycle.Error() + " " + strings.Join(e.Path, " -> ")
+}
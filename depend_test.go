@@ -0,0 +1,119 @@
+package cronsun
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"cronsun/db/entries"
+)
+
+func TestFindDependCycle(t *testing.T) {
+	dep := func(id string) *JobDepend {
+		return &JobDepend{Group: DefaultJobGroup, ID: id, On: DependOnSuccess}
+	}
+	job := func(id string, ds ...*JobDepend) *Job {
+		return &Job{ID: id, Group: DefaultJobGroup, Depends: ds}
+	}
+
+	tests := []struct {
+		jobs     []*Job
+		expected string
+	}{
+		{[]*Job{job("a"), job("b", dep("a")), job("c", dep("b"))}, ""},
+		{[]*Job{job("a"), job("b", dep("a")), job("c", dep("a"), dep("b"))}, ""},
+		{[]*Job{job("a", dep("a"))}, "default/a -> default/a"},
+		{[]*Job{job("a", dep("c")), job("b", dep("a")), job("c", dep("b"))}, "default/a -> default/b -> default/c -> default/a"},
+		// 依赖已删除的任务
+		{[]*Job{job("b", dep("x"))}, ""},
+	}
+
+	for _, test := range tests {
+		actual := strings.Join(findDependCycle(test.jobs), " -> ")
+		if actual != test.expected {
+			t.Errorf("expected cycle %q, got %q", test.expected, actual)
+		}
+	}
+}
+
+func TestJobDependMatch(t *testing.T) {
+	tests := []struct {
+		on               string
+		success, matched bool
+	}{
+		{DependOnSuccess, true, true},
+		{DependOnSuccess, false, false},
+		{DependOnFail, true, false},
+		{DependOnFail, false, true},
+		{DependOnDone, true, true},
+		{DependOnDone, false, true},
+	}
+
+	for _, test := range tests {
+		d := &JobDepend{ID: "a", On: test.on}
+		if d.match(test.success) != test.matched {
+			t.Errorf("depend on %s with success %v: expected %v", test.on, test.success, test.matched)
+		}
+	}
+}
+
+func TestJobDependCheck(t *testing.T) {
+	d := &JobDepend{ID: " a "}
+	if err := d.check(); err != nil {
+		t.Fatal(err)
+	}
+	if d.ID != "a" || d.Group != DefaultJobGroup || d.On != DependOnSuccess {
+		t.Errorf("unexpected default values: %+v", d)
+	}
+
+	if err := (&JobDepend{ID: "a/b"}).check(); err != ErrIllegalDependJob {
+		t.Errorf("expected %v, got %v", ErrIllegalDependJob, err)
+	}
+	if err := (&JobDepend{ID: "a", On: "always"}).check(); err != ErrIllegalDependCondition {
+		t.Errorf("expected %v, got %v", ErrIllegalDependCondition, err)
+	}
+}
+
+func TestDependExecution(t *testing.T) {
+	fire := time.Unix(1500000000, 0)
+	tests := []struct {
+		a, b *Trigger
+		same bool
+	}{
+		// 多个结点执行同一次定时触发
+		{&Trigger{Type: TriggerCron, RuleID: "r1", FireTime: fire}, &Trigger{Type: TriggerCron, RuleID: "r1", FireTime: fire, Attempt: 2}, true},
+		{&Trigger{Type: TriggerCron, RuleID: "r1", FireTime: fire}, &Trigger{Type: TriggerCron, RuleID: "r1", FireTime: fire.Add(time.Minute)}, false},
+		{&Trigger{Type: TriggerCron, RuleID: "r1", FireTime: fire}, &Trigger{Type: TriggerCron, RuleID: "r2", FireTime: fire}, false},
+		// 手动执行和分片执行按执行 id
+		{&Trigger{Type: TriggerOnce, ExecutionID: "e1", FireTime: fire}, &Trigger{Type: TriggerOnce, ExecutionID: "e1", FireTime: fire.Add(time.Second)}, true},
+		{&Trigger{ExecutionID: "e1", ShardIndex: 0}, &Trigger{ExecutionID: "e2", ShardIndex: 0}, false},
+	}
+
+	for i, test := range tests {
+		if same := dependTriggerKey("d", "u", test.a) == dependTriggerKey("d", "u", test.b); same != test.same {
+			t.Errorf("#%d: expected same key %v, got %v", i, test.same, same)
+		}
+	}
+	if dependTriggerKey("d1", "u", tests[0].a) == dependTriggerKey("d2", "u", tests[0].a) {
+		t.Error("expected different keys for different downstream jobs")
+	}
+}
+
+func TestDependCmds(t *testing.T) {
+	n := &entries.Node{ID: "n1"}
+	rules := func() []*JobRule {
+		return []*JobRule{{ID: "r1", NodeIDs: []string{"n1"}}, {ID: "r2", NodeIDs: []string{"n2"}}}
+	}
+
+	j := &Job{ID: "j1", Rules: rules()}
+	if cmds := j.Cmds(n, nil); len(cmds) != 0 {
+		t.Errorf("expected no cmd for rules without timer, got %d", len(cmds))
+	}
+
+	// 上游任务触发的执行使用规则的执行限制
+	j = &Job{ID: "j1", Rules: rules(), Depends: []*JobDepend{{Group: DefaultJobGroup, ID: "u"}}}
+	cmds := j.Cmds(n, nil)
+	if _, ok := cmds["j1r1"]; !ok || len(cmds) != 1 {
+		t.Errorf("expected cmd of rule r1 for depend trigger, got %v", cmds)
+	}
+}
@@ -0,0 +1,341 @@
+package cronsun
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	client "github.com/coreos/etcd/clientv3"
+
+	"cronsun/conf"
+	"cronsun/db/entries"
+	"cronsun/log"
+)
+
+// KindAny 任务选择执行结点的方式
+const (
+	StrategyRoundRobin   = "round-robin"   // 轮流执行，默认
+	StrategyLeastRunning = "least-running" // 执行中的进程最少的结点
+	StrategyWeighted     = "weighted"      // 按结点的 weight 标签加权随机
+	StrategySticky       = "sticky"        // 固定在同一结点，结点不可用时切换
+)
+
+// LabelWeight 结点的权重标签，weighted 时使用，默认为 1，不大于 0 时不选择该结点
+const LabelWeight = "weight"
+
+func (j *Job) checkStrategy() error {
+	j.Strategy = strings.ToLower(strings.TrimSpace(j.Strategy))
+	switch j.Strategy {
+	case "":
+		if j.Kind == KindAny {
+			j.Strategy = StrategyRoundRobin
+		}
+	case StrategyRoundRobin, StrategyLeastRunning, StrategyWeighted, StrategySticky:
+	default:
+		return ErrIllegalStrategy
+	}
+	return nil
+}
+
+// 调度记录的 key，每次触发只由一个结点调度
+// 上游任务的触发在各结点可能按不同的规则执行，按触发的执行 id 调度
+func dispatchKey(c *Cmd, tr *Trigger) string {
+	if tr.Type == TriggerDepend && len(tr.ExecutionID) > 0 {
+		return fmt.Sprintf("dispatch/%s/depend/%s", c.Job.ID, tr.ExecutionID)
+	}
+	return fmt.Sprintf("dispatch/%s/%s/%d", c.Job.ID, c.JobRule.ID, tr.FireTime.Unix())
+}
+
+// 选择一个结点执行本次触发，分片任务分配到多个结点
+// 所有结点都会触发，只有取得调度记录的结点进行调度
+func (c *Cmd) dispatch(tr *Trigger) {
+	resp, err := DefalutClient.Grant(conf.Config.LockTtl)
+	if err != nil {
+		log.Warnf("job[%s] dispatch grant err: %s", c.Job.Key(), err.Error())
+		return
+	}
+	ok, err := DefalutClient.GetLock(dispatchKey(c, tr), resp.ID)
+	if err != nil || !ok {
+		if err != nil {
+			log.Warnf("job[%s] dispatch lock err: %s", c.Job.Key(), err.Error())
+		}
+		// 没有取得调度记录时释放 lease
+		if _, err = DefalutClient.Revoke(resp.ID); err != nil {
+			log.Warnf("job[%s] dispatch revoke err: %s", c.Job.Key(), err.Error())
+		}
+		return
+	}
+
+	nodes, err := c.eligibleNodes()
+	if err != nil {
+		log.Warnf("job[%s] get eligible nodes err: %s", c.Job.Key(), err.Error())
+		return
+	}
+	if c.Job.Kind == KindShard {
+		if len(nodes) == 0 {
+			c.Job.Skip(tr, fmt.Sprintf("job[%s] rule[%s] fire at %s has no eligible node", c.Job.Key(), c.JobRule.ID, tr.FireTime.Format(time.RFC3339)))
+			return
+		}
+		c.dispatchShards(tr, nodes)
+		return
+	}
+
+	n, reason, err := c.Job.pickNode(nodes)
+	if err != nil {
+		log.Warnf("job[%s] pick node err: %s", c.Job.Key(), err.Error())
+		return
+	}
+	if n == nil {
+		c.Job.Skip(tr, fmt.Sprintf("job[%s] rule[%s] fire at %s has no eligible node", c.Job.Key(), c.JobRule.ID, tr.FireTime.Format(time.RFC3339)))
+		return
+	}
+
+	err = putOnce(c.Job.Group, c.Job.ID, &Once{
+		NodeID:         n.ID,
+		TriggerType:    tr.Type,
+		RuleID:         c.JobRule.ID,
+		FireTime:       tr.FireTime,
+		DispatchedBy:   c.Job.runOn,
+		DispatchReason: reason,
+		ExecutionID:    tr.ExecutionID,
+	})
+	if err != nil {
+		log.Warnf("job[%s] dispatch to node[%s] err: %s", c.Job.Key(), n.ID, err.Error())
+		return
+	}
+	log.Infof("job[%s] rule[%s] dispatched to node[%s]: %s", c.Job.Key(), c.JobRule.ID, n.ID, reason)
+}
+
+// RunDispatched 执行调度到本结点的触发
+func (c *Cmd) RunDispatched(tr *Trigger) {
+	if !c.Job.checkWindow(tr) {
+		return
+	}
+	if !c.beginOverlap(tr) {
+		return
+	}
+	for tr != nil {
+		c.run(tr)
+		tr = c.endOverlap(tr)
+	}
+}
+
+// 在线且满足规则的结点，按 id 排序
+func (c *Cmd) eligibleNodes() ([]*entries.Node, error) {
+	resp, err := DefalutClient.Get(conf.Config.Node, client.WithPrefix(), client.WithKeysOnly())
+	if err != nil {
+		return nil, err
+	}
+	alive := make(map[string]bool, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		alive[GetIDFromKey(string(kv.Key))] = true
+	}
+
+	gs, err := GetGroups(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	all, err := entries.GetNodes()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make([]*entries.Node, 0, len(all))
+	for _, n := range all {
+		if alive[n.ID] && c.JobRule.runOn(n, gs) {
+			nodes = append(nodes, n)
+		}
+	}
+	sort.Slice(nodes, func(i, k int) bool { return nodes[i].ID < nodes[k].ID })
+	return nodes, nil
+}
+
+// 结点是否在规则中且没有被排除
+func (rule *JobRule) runOn(n *entries.Node, gs map[string]*Group) bool {
+	for _, id := range rule.ExcludeNodeIDs {
+		if n.ID == id {
+			return false
+		}
+	}
+	return rule.included(n, gs)
+}
+
+// 按任务的 Strategy 选择结点，返回选择的原因
+// 没有可选择的结点时返回 nil
+func (j *Job) pickNode(nodes []*entries.Node) (*entries.Node, string, error) {
+	if len(nodes) == 0 {
+		return nil, "", nil
+	}
+
+	switch j.Strategy {
+	case StrategyLeastRunning:
+		running, err := runningProcs()
+		if err != nil {
+			return nil, "", err
+		}
+		n, reason := pickLeastRunning(nodes, running)
+		return n, reason, nil
+	case StrategyWeighted:
+		n, reason := pickWeighted(nodes, rand.Int63n)
+		return n, reason, nil
+	case StrategySticky:
+		key := stickyKey(j.ID)
+		resp, err := DefalutClient.Get(key)
+		if err != nil {
+			return nil, "", err
+		}
+		var prev string
+		if len(resp.Kvs) > 0 {
+			prev = string(resp.Kvs[0].Value)
+		}
+
+		n, reason := pickSticky(nodes, prev)
+		if n.ID != prev {
+			if _, err = DefalutClient.Put(key, n.ID); err != nil {
+				return nil, "", err
+			}
+		}
+		return n, reason, nil
+	}
+
+	turn, err := incrCounter(roundRobinKey(j.ID))
+	if err != nil {
+		return nil, "", err
+	}
+	n, reason := pickRoundRobin(nodes, turn)
+	return n, reason, nil
+}
+
+func roundRobinKey(jobID string) string {
+	return conf.Config.Lock + "rr/" + jobID
+}
+
+func stickyKey(jobID string) string {
+	return conf.Config.Lock + "sticky/" + jobID
+}
+
+// DelJobDispatch 删除任务的调度记录
+func DelJobDispatch(jobID string) error {
+	if _, err := DefalutClient.Delete(roundRobinKey(jobID)); err != nil {
+		return err
+	}
+	_, err := DefalutClient.Delete(stickyKey(jobID))
+	return err
+}
+
+// 计数加一，返回加一前的值
+func incrCounter(key string) (int64, error) {
+	for {
+		resp, err := DefalutClient.Get(key)
+		if err != nil {
+			return 0, err
+		}
+
+		var n, rev int64
+		if len(resp.Kvs) > 0 {
+			n, _ = strconv.ParseInt(string(resp.Kvs[0].Value), 10, 64)
+			rev = resp.Kvs[0].ModRevision
+		}
+
+		ctx, cancel := NewEtcdTimeoutContext(DefalutClient)
+		tresp, err := DefalutClient.Txn(ctx).
+			If(client.Compare(client.ModRevision(key), "=", rev)).
+			Then(client.OpPut(key, strconv.FormatInt(n+1, 10))).
+			Commit()
+		cancel()
+		if err != nil {
+			return 0, err
+		}
+		if tresp.Succeeded {
+			return n, nil
+		}
+	}
+}
+
+// 每个结点执行中的进程数
+func runningProcs() (map[string]int, error) {
+	resp, err := DefalutClient.Get(conf.Config.Proc, client.WithPrefix(), client.WithKeysOnly())
+	if err != nil {
+		return nil, err
+	}
+
+	running := make(map[string]int)
+	for _, kv := range resp.Kvs {
+		if p, err := GetProcFromKey(string(kv.Key)); err == nil {
+			running[p.NodeID]++
+		}
+	}
+	return running, nil
+}
+
+func pickRoundRobin(nodes []*entries.Node, turn int64) (*entries.Node, string) {
+	i := int(turn % int64(len(nodes)))
+	return nodes[i], fmt.Sprintf("%s, turn %d of %d nodes", StrategyRoundRobin, i+1, len(nodes))
+}
+
+// 进程数相同时选择 id 最小的结点
+func pickLeastRunning(nodes []*entries.Node, running map[string]int) (*entries.Node, string) {
+	var n *entries.Node
+	for _, node := range nodes {
+		if n == nil || running[node.ID] < running[n.ID] {
+			n = node
+		}
+	}
+	return n, fmt.Sprintf("%s, %d running processes", StrategyLeastRunning, running[n.ID])
+}
+
+func nodeWeight(n *entries.Node) int64 {
+	w, ok := n.Labels[LabelWeight]
+	if !ok {
+		return 1
+	}
+	i, err := strconv.ParseInt(w, 10, 64)
+	if err != nil || i < 0 {
+		return 0
+	}
+	return i
+}
+
+// random 返回 [0, n) 之间的随机数
+func pickWeighted(nodes []*entries.Node, random func(n int64) int64) (*entries.Node, string) {
+	var total int64
+	for _, n := range nodes {
+		total += nodeWeight(n)
+	}
+	if total <= 0 {
+		return nil, ""
+	}
+
+	r := random(total)
+	for _, n := range nodes {
+		w := nodeWeight(n)
+		if r < w {
+			return n, fmt.Sprintf("%s, weight %d of %d", StrategyWeighted, w, total)
+		}
+		r -= w
+	}
+	return nil, ""
+}
+
+// 上次执行的结点不可用时，切换到 id 在其后的第一个结点
+func pickSticky(nodes []*entries.Node, prev string) (*entries.Node, string) {
+	for _, n := range nodes {
+		if n.ID == prev {
+			return n, StrategySticky + ", same as the last run"
+		}
+	}
+
+	if len(prev) == 0 {
+		return nodes[0], StrategySticky + ", first run"
+	}
+	for _, n := range nodes {
+		if n.ID > prev {
+			return n, fmt.Sprintf("%s, failover from unavailable node[%s]", StrategySticky, prev)
+		}
+	}
+	return nodes[0], fmt.Sprintf("%s, failover from unavailable node[%s]", StrategySticky, prev)
+}
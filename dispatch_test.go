@@ -0,0 +1,184 @@
+package cronsun
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"cronsun/conf"
+	"cronsun/db/entries"
+)
+
+func testNodes(ids ...string) []*entries.Node {
+	nodes := make([]*entries.Node, len(ids))
+	for i, id := range ids {
+		nodes[i] = &entries.Node{ID: id}
+	}
+	return nodes
+}
+
+func TestCheckStrategy(t *testing.T) {
+	tests := []struct {
+		kind     int
+		strategy string
+		expected string
+		err      error
+	}{
+		{KindAny, "", StrategyRoundRobin, nil},
+		{KindAny, " Sticky ", StrategySticky, nil},
+		{KindCommon, "", "", nil},
+		{KindAny, "fastest", "", ErrIllegalStrategy},
+	}
+
+	for i, test := range tests {
+		j := &Job{Kind: test.kind, Strategy: test.strategy}
+		if err := j.checkStrategy(); err != test.err {
+			t.Errorf("#%d: expected %v, got %v", i, test.err, err)
+			continue
+		}
+		if test.err == nil && j.Strategy != test.expected {
+			t.Errorf("#%d: expected %q, got %q", i, test.expected, j.Strategy)
+		}
+	}
+}
+
+func TestPickRoundRobin(t *testing.T) {
+	nodes := testNodes("a", "b", "c")
+	for turn, expected := range []string{"a", "b", "c", "a"} {
+		n, reason := pickRoundRobin(nodes, int64(turn))
+		if n.ID != expected {
+			t.Errorf("turn %d: expected %s, got %s", turn, expected, n.ID)
+		}
+		if !strings.HasPrefix(reason, StrategyRoundRobin) {
+			t.Errorf("turn %d: unexpected reason %q", turn, reason)
+		}
+	}
+}
+
+func TestPickLeastRunning(t *testing.T) {
+	nodes := testNodes("a", "b", "c")
+	tests := []struct {
+		running  map[string]int
+		expected string
+	}{
+		{nil, "a"},
+		{map[string]int{"a": 2, "b": 1, "c": 3}, "b"},
+		{map[string]int{"a": 2, "b": 1, "c": 1}, "b"},
+		{map[string]int{"a": 2, "b": 1, "x": 0}, "c"},
+	}
+
+	for i, test := range tests {
+		if n, _ := pickLeastRunning(nodes, test.running); n.ID != test.expected {
+			t.Errorf("#%d: expected %s, got %s", i, test.expected, n.ID)
+		}
+	}
+}
+
+func TestPickWeighted(t *testing.T) {
+	nodes := testNodes("a", "b", "c")
+	nodes[0].Labels = map[string]string{LabelWeight: "3"}
+	nodes[1].Labels = map[string]string{LabelWeight: "0"}
+	// c 没有 weight 标签，权重为 1
+	for r, expected := range []string{"a", "a", "a", "c"} {
+		n, _ := pickWeighted(nodes, func(total int64) int64 {
+			if total != 4 {
+				t.Fatalf("expected total weight 4, got %d", total)
+			}
+			return int64(r)
+		})
+		if n.ID != expected {
+			t.Errorf("random %d: expected %s, got %s", r, expected, n.ID)
+		}
+	}
+
+	nodes[0].Labels[LabelWeight], nodes[2].Labels = "-1", map[string]string{LabelWeight: "x"}
+	if n, _ := pickWeighted(nodes, func(int64) int64 { return 0 }); n != nil {
+		t.Errorf("expected no node, got %s", n.ID)
+	}
+}
+
+func TestPickSticky(t *testing.T) {
+	nodes := testNodes("a", "c", "e")
+	tests := []struct {
+		prev     string
+		expected string
+	}{
+		{"", "a"},
+		{"c", "c"},
+		{"b", "c"},
+		{"e", "e"},
+		{"f", "a"},
+	}
+
+	for _, test := range tests {
+		if n, _ := pickSticky(nodes, test.prev); n.ID != test.expected {
+			t.Errorf("prev %q: expected %s, got %s", test.prev, test.expected, n.ID)
+		}
+	}
+}
+
+func TestOnceTriggerDispatch(t *testing.T) {
+	o := ParseOnce([]byte(`{"node_id":"n1","trigger_type":"cron","rule_id":"r1","fire_time":"2018-01-01T00:00:00Z","dispatched_by":"n2","dispatch_reason":"round-robin, turn 1 of 2 nodes"}`))
+	tr := o.Trigger()
+	if tr.Type != TriggerCron || tr.RuleID != "r1" || tr.FireTime.Year() != 2018 ||
+		tr.DispatchedBy != "n2" || tr.DispatchReason != "round-robin, turn 1 of 2 nodes" {
+		t.Errorf("unexpected trigger %+v", tr)
+	}
+
+	if tr = ParseOnce([]byte("n1")).Trigger(); tr.FireTime.IsZero() {
+		t.Errorf("expected fire time of manual run")
+	}
+}
+
+func TestDispatchKey(t *testing.T) {
+	job := &Job{ID: "j1", Kind: KindAny}
+	c1 := &Cmd{Job: job, JobRule: &JobRule{ID: "r1"}}
+	c2 := &Cmd{Job: job, JobRule: &JobRule{ID: "r2"}}
+	fire := time.Unix(1514764800, 0)
+
+	// 上游任务的触发在不同结点按不同的规则执行时也只调度一次
+	tr := &Trigger{Type: TriggerDepend, FireTime: fire, ExecutionID: "e1"}
+	if k1, k2 := dispatchKey(c1, tr), dispatchKey(c2, tr); k1 != k2 {
+		t.Errorf("expected same depend dispatch key, got %s and %s", k1, k2)
+	}
+	if k1, k2 := dispatchKey(c1, tr), dispatchKey(c1, &Trigger{Type: TriggerDepend, FireTime: fire, ExecutionID: "e2"}); k1 == k2 {
+		t.Errorf("expected different dispatch keys for executions, got %s", k1)
+	}
+
+	// 定时触发按规则调度
+	tr = &Trigger{Type: TriggerCron, FireTime: fire}
+	if k1, k2 := dispatchKey(c1, tr), dispatchKey(c2, tr); k1 == k2 {
+		t.Errorf("expected different cron dispatch keys for rules, got %s", k1)
+	}
+}
+
+func TestDispatchRevoke(t *testing.T) {
+	f := newFakeEtcd(t)
+	lockTtl := conf.Config.LockTtl
+	conf.Config.LockTtl = 300
+	defer func() { conf.Config.LockTtl = lockTtl }()
+
+	c := &Cmd{Job: &Job{ID: "j1", Group: "g", Kind: KindAny}, JobRule: &JobRule{ID: "r1"}}
+	tr := &Trigger{Type: TriggerCron, FireTime: time.Unix(1514764800, 0)}
+	resp, err := DefalutClient.Grant(conf.Config.LockTtl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := DefalutClient.GetLock(dispatchKey(c, tr), resp.ID); !ok || err != nil {
+		t.Fatalf("expected lock, got %v, %v", ok, err)
+	}
+
+	// 其它结点已调度时释放 lease
+	c.dispatch(tr)
+	if n := f.leaseCount(); n != 1 {
+		t.Errorf("expected only the winner lease left, got %d", n)
+	}
+
+	// 取锁出错时也释放
+	f.txnErr = errors.New("etcd unavailable")
+	c.dispatch(&Trigger{Type: TriggerCron, FireTime: time.Unix(1514764860, 0)})
+	if n := f.leaseCount(); n != 1 {
+		t.Errorf("expected lease revoked after lock err, got %d", n)
+	}
+}
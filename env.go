@@ -0,0 +1,98 @@
+package cronsun
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 内置环境变量的前缀，任务不能自定义此前缀的环境变量
+const EnvPrefix = "CRONSUN_"
+
+// 执行任务时内置的环境变量
+const (
+	EnvJobID     = EnvPrefix + "JOB_ID"
+	EnvJobGroup  = EnvPrefix + "JOB_GROUP"
+	EnvJobName   = EnvPrefix + "JOB_NAME"
+	EnvNodeID    = EnvPrefix + "NODE_ID"
+	EnvHostname  = EnvPrefix + "HOSTNAME"
+	EnvIP        = EnvPrefix + "IP"
+	EnvRuleID    = EnvPrefix + "RULE_ID"
+	EnvFireTime  = EnvPrefix + "FIRE_TIME"  // 计划执行时间，RFC3339 格式
+	EnvFireStamp = EnvPrefix + "FIRE_STAMP" // 计划执行时间，unix 时间戳
+
+	EnvExecutionID = EnvPrefix + "EXECUTION_ID" // 同一次执行的 id，分片任务的所有分片相同
+	EnvShardIndex  = EnvPrefix + "SHARD_INDEX"  // 分片序号，从 0 开始
+	EnvShardTotal  = EnvPrefix + "SHARD_TOTAL"  // 分片总数
+
+	EnvPayload = EnvPrefix + "PAYLOAD" // 通过 webhook 触发时请求的 JSON 内容，同时写入标准输入
+	EnvFile    = EnvPrefix + "FILE"    // 文件触发时匹配的文件路径
+)
+
+var envKeyRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// 任务的环境变量
+type JobEnv struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// 请求中的 null 元素视为无效的环境变量
+func (e *JobEnv) check() error {
+	if e == nil {
+		return ErrIllegalJobEnv
+	}
+
+	e.Key = strings.TrimSpace(e.Key)
+	if !envKeyRegexp.MatchString(e.Key) || strings.HasPrefix(strings.ToUpper(e.Key), EnvPrefix) {
+		return ErrIllegalJobEnv
+	}
+	return nil
+}
+
+func (e *JobEnv) String() string {
+	return e.Key + "=" + e.Value
+}
+
+// 返回执行任务的环境变量
+// 在 node 进程的环境变量基础上，增加任务自定义的和内置的环境变量
+func (j *Job) environ(tr *Trigger) []string {
+	env := os.Environ()
+	for _, e := range j.Env {
+		env = append(env, e.String())
+	}
+	// 同名的环境变量以后面的为准
+	for _, e := range tr.Env {
+		env = append(env, e.String())
+	}
+
+	env = append(env,
+		EnvJobID+"="+j.ID,
+		EnvJobGroup+"="+j.Group,
+		EnvJobName+"="+j.Name,
+		EnvNodeID+"="+j.runOn,
+		EnvHostname+"="+j.hostname,
+		EnvIP+"="+j.ip,
+		EnvRuleID+"="+tr.RuleID,
+		EnvFireTime+"="+tr.FireTime.Format(time.RFC3339),
+		EnvFireStamp+"="+strconv.FormatInt(tr.FireTime.Unix(), 10),
+	)
+	if len(tr.ExecutionID) > 0 {
+		env = append(env, EnvExecutionID+"="+tr.ExecutionID)
+	}
+	if len(tr.Payload) > 0 {
+		env = append(env, EnvPayload+"="+tr.Payload)
+	}
+	if len(tr.File) > 0 {
+		env = append(env, EnvFile+"="+tr.File)
+	}
+	if tr.ShardTotal > 0 {
+		env = append(env,
+			EnvShardIndex+"="+strconv.Itoa(tr.ShardIndex),
+			EnvShardTotal+"="+strconv.Itoa(tr.ShardTotal),
+		)
+	}
+	return env
+}
@@ -11,10 +11,51 @@ var (
 	ErrIllegalJobId        = errors.New("Invalid id that includes illegal characters such as '/' '\\'.")
 	ErrIllegalJobGroupName = errors.New("Invalid job group name that includes illegal characters such as '/' '\\'.")
 
+	ErrIllegalJobEnv            = errors.New("Invalid job environment variable name, only letters, digits and '_' are allowed and the prefix 'CRONSUN_' is reserved.")
+	ErrIllegalJobWorkDir        = errors.New("Working directory of job should be an absolute path.")
+	ErrIllegalKillSignal        = errors.New("Invalid kill signal, should be one of SIGTERM, SIGINT, SIGHUP, SIGQUIT, SIGKILL, SIGUSR1 and SIGUSR2.")
+	ErrIllegalConcurrencyPolicy = errors.New("Invalid concurrency policy, should be skip or wait.")
+	ErrIllegalMisfirePolicy     = errors.New("Invalid misfire policy, should be one of ignore, once and all.")
+	ErrIllegalOverlapPolicy     = errors.New("Invalid overlap policy, should be one of allow, skip, queue and replace.")
+	ErrIllegalJobActiveTime     = errors.New("Invalid active time of job, end time should be after start time.")
+	ErrIllegalJobWindow         = errors.New("Invalid execution window of job, start and end should be different times formatted as 15:04 and timezone should be an IANA name.")
+	ErrIllegalStrategy          = errors.New("Invalid strategy, should be one of round-robin, least-running, weighted and sticky.")
+	ErrIllegalSpread            = errors.New("Invalid spread, should be random or hash.")
+	ErrIllegalRetryPolicy       = errors.New("Invalid retry policy, backoff should be one of fixed, linear and exponential, retry_on should be in exit, timeout and start, and max_delay should not be negative.")
+
+	ErrIllegalDependJob       = errors.New("Invalid depend job that has an empty id or includes illegal characters such as '/' '\\'.")
+	ErrIllegalDependCondition = errors.New("Invalid depend condition, should be one of success, fail and done.")
+	ErrDependCycle            = errors.New("Job depends form a cycle:")
+
+	ErrIllegalJobType     = errors.New("Invalid job type, should be one of cmd, http and script.")
+	ErrIllegalHTTPJob     = errors.New("Invalid http request of job")
+	ErrEmptyJobScript     = errors.New("Script of job is empty.")
+	ErrIllegalInterpreter = errors.New("Invalid script interpreter, should be one of sh, bash, python and python3.")
+
+	ErrIllegalFileWatch        = errors.New("Invalid file watch of job rule, dir should be an absolute path, pattern should be a valid glob, debounce and settle should not be negative.")
+	ErrIllegalTriggerSignature = errors.New("Invalid trigger signature.")
+	ErrIllegalTriggerPayload   = errors.New("Invalid trigger payload, should be a JSON value no larger than 64KB.")
+	ErrEmptyTriggerKey         = errors.New("Signing secret of trigger token is unavailable, trigger_key of security config is empty.")
+
+	ErrIllegalLabelSelector = errors.New("Invalid label selector, requirements should be like key=value, key!=value, key in (v1, v2), key notin (v1, v2), key or !key")
+
 	ErrEmptyNodeGroupName = errors.New("Name of node group is empty.")
 	ErrIllegalNodeGroupId = errors.New("Invalid node group id that includes illegal characters such as '/'.")
 
+	ErrIllegalNodeGroupHostname = errors.New("Invalid hostname pattern of node group, should be a glob such as web-* or a regular expression such as /^web-\\d+$/")
+	ErrIllegalNodeGroupCIDR     = errors.New("Invalid CIDR of node group, should be like 10.2.0.0/16")
+	ErrIllegalNodeGroupVersion  = errors.New("Invalid version constraint of node group, should be like >=0.3.5, <0.4")
+
+	ErrEmptyCalendarName   = errors.New("Name of calendar is empty.")
+	ErrIllegalCalendarId   = errors.New("Invalid calendar id that includes illegal characters such as '/'.")
+	ErrIllegalCalendarDate = errors.New("Invalid calendar date, should be formatted as 2006-01-02 and the end date should not be before the start date.")
+
 	ErrSecurityInvalidCmd  = errors.New("Security error: the suffix of script file is not on the whitelist.")
 	ErrSecurityInvalidUser = errors.New("Security error: the user is not on the whitelist.")
+	ErrSecurityInvalidHost = errors.New("Security error: the host of url is not on the whitelist.")
 	ErrNilRule             = errors.New("invalid job rule, empty timer.")
+
+	ErrSecurityInvalidInterpreter = errors.New("Security error: the script interpreter is not on the whitelist.")
+
+	ErrSemaphoreLost = errors.New("Semaphore lease of job expired, the slot may be taken by other nodes.")
 )
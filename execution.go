@@ -0,0 +1,198 @@
+package cronsun
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	client "github.com/coreos/etcd/clientv3"
+
+	"cronsun/conf"
+	"cronsun/log"
+)
+
+// 手动执行在每个结点上的状态
+const (
+	ExecutionPending     = "pending"       // 等待结点执行
+	ExecutionRunning     = "running"       // 结点正在执行
+	ExecutionDone        = "done"          // 结点执行结束
+	ExecutionNotPickedUp = "not picked up" // 结点不在线或没有执行
+)
+
+const (
+	// 手动执行记录的保存时间，单位秒
+	ExecutionTTL int64 = 24 * 3600
+	// 超过这个时间还没有开始执行的结点记为 not picked up
+	ExecutionPickupTimeout = time.Minute
+)
+
+// 一次手动执行的记录，用于查询各结点的执行状态
+type Execution struct {
+	ID    string `json:"id"`
+	Group string `json:"group"`
+	JobID string `json:"job_id"`
+	// 执行的结点
+	NodeIDs []string `json:"node_ids"`
+	// 追加在命令后的参数
+	Args []string `json:"args,omitempty"`
+	// 覆盖任务的环境变量
+	Env       []*JobEnv `json:"env,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// 触发方式，为空时为手动执行
+	TriggerType string `json:"trigger_type,omitempty"`
+	// webhook 触发的来源、调用方的 IP 和请求的 JSON 内容
+	Source   string `json:"source,omitempty"`
+	CallerIP string `json:"caller_ip,omitempty"`
+	Payload  string `json:"payload,omitempty"`
+}
+
+// 一次手动执行在一个结点上的状态
+type ExecutionNode struct {
+	NodeID    string    `json:"node_id"`
+	Status    string    `json:"status"`
+	Success   bool      `json:"success"`
+	UpdatedAt time.Time `json:"updated_at,omitempty"`
+}
+
+func ExecutionKey(id string) string {
+	return conf.Config.Lock + "executions/" + id
+}
+
+func executionNodeKey(id, nodeID string) string {
+	return ExecutionKey(id) + "/nodes/" + nodeID
+}
+
+func (e *Execution) check() error {
+	if len(e.Payload) > 0 && (len(e.Payload) > MaxTriggerPayload || !json.Valid([]byte(e.Payload))) {
+		return ErrIllegalTriggerPayload
+	}
+	for _, env := range e.Env {
+		if err := env.check(); err != nil {
+			return err
+		}
+	}
+	e.NodeIDs = trimIDs(e.NodeIDs)
+	return nil
+}
+
+// RunOnce 在 e.NodeIDs 上手动执行一次任务，e.ID 为生成的执行 id
+// 执行记录保存 ExecutionTTL 秒
+func RunOnce(e *Execution) error {
+	if err := e.check(); err != nil {
+		return err
+	}
+	e.ID = NextID()
+	e.CreatedAt = time.Now()
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	resp, err := DefalutClient.Grant(ExecutionTTL)
+	if err != nil {
+		return err
+	}
+	if _, err = DefalutClient.Put(ExecutionKey(e.ID), string(b), client.WithLease(resp.ID)); err != nil {
+		return err
+	}
+
+	return putOnce(e.Group, e.JobID, &Once{
+		NodeIDs:     e.NodeIDs,
+		TriggerType: e.TriggerType,
+		ExecutionID: e.ID,
+		Args:        e.Args,
+		Env:         e.Env,
+		Source:      e.Source,
+		CallerIP:    e.CallerIP,
+		Payload:     e.Payload,
+	})
+}
+
+// GetExecution 返回执行记录和已开始执行的结点的状态
+func GetExecution(id string) (*Execution, map[string]*ExecutionNode, error) {
+	resp, err := DefalutClient.Get(ExecutionKey(id)+"/", client.WithPrefix())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nodes := make(map[string]*ExecutionNode, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		n := &ExecutionNode{}
+		if err = json.Unmarshal(kv.Value, n); err != nil {
+			log.Warnf("execution[%s] unmarshal node status[%s] err: %s", id, kv.Key, err.Error())
+			continue
+		}
+		nodes[n.NodeID] = n
+	}
+
+	resp, err = DefalutClient.Get(ExecutionKey(id))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, nil, ErrNotFound
+	}
+
+	e := &Execution{}
+	if err = json.Unmarshal(resp.Kvs[0].Value, e); err != nil {
+		return nil, nil, err
+	}
+	return e, nodes, nil
+}
+
+// Nodes 返回每个执行结点的状态
+// 还没有开始执行的结点，在线且没有超过 ExecutionPickupTimeout 时为 pending
+func (e *Execution) Nodes(started map[string]*ExecutionNode, alive map[string]bool, now time.Time) []*ExecutionNode {
+	list := make([]*ExecutionNode, 0, len(e.NodeIDs))
+	for _, id := range e.NodeIDs {
+		if n, ok := started[id]; ok {
+			list = append(list, n)
+			continue
+		}
+
+		n := &ExecutionNode{NodeID: id, Status: ExecutionNotPickedUp}
+		if alive[id] && now.Sub(e.CreatedAt) < ExecutionPickupTimeout {
+			n.Status = ExecutionPending
+		}
+		list = append(list, n)
+	}
+	return list
+}
+
+// 记录本结点的执行状态，执行记录已过期时不记录
+func (j *Job) markExecution(tr *Trigger, status string, success bool) {
+	resp, err := DefalutClient.Get(ExecutionKey(tr.ExecutionID))
+	if err != nil {
+		log.Warnf("job[%s] get execution[%s] err: %s", j.Key(), tr.ExecutionID, err.Error())
+		return
+	}
+	if len(resp.Kvs) == 0 {
+		return
+	}
+
+	b, err := json.Marshal(&ExecutionNode{NodeID: j.runOn, Status: status, Success: success, UpdatedAt: time.Now()})
+	if err != nil {
+		return
+	}
+	_, err = DefalutClient.Put(executionNodeKey(tr.ExecutionID, j.runOn), string(b), client.WithLease(client.LeaseID(resp.Kvs[0].Lease)))
+	if err != nil {
+		log.Warnf("job[%s] mark execution[%s] %s err: %s", j.Key(), tr.ExecutionID, status, err.Error())
+	}
+}
+
+// 手动执行时在命令后追加的参数
+func (tr *Trigger) command(cmd []string) []string {
+	if len(tr.Args) == 0 {
+		return cmd
+	}
+	return append(append(make([]string, 0, len(cmd)+len(tr.Args)), cmd...), tr.Args...)
+}
+
+// 记录在 job log 中的参数
+func (tr *Trigger) argsString() string {
+	if len(tr.Args) == 0 {
+		return ""
+	}
+	return " " + strings.Join(tr.Args, " ")
+}
@@ -0,0 +1,77 @@
+package cronsun
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExecutionNodes(t *testing.T) {
+	now := time.Now()
+	e := &Execution{ID: "e1", NodeIDs: []string{"n1", "n2", "n3", "n4"}, CreatedAt: now.Add(-10 * time.Second)}
+	started := map[string]*ExecutionNode{
+		"n1": {NodeID: "n1", Status: ExecutionRunning},
+		"n2": {NodeID: "n2", Status: ExecutionDone, Success: true},
+	}
+	alive := map[string]bool{"n1": true, "n2": true, "n3": true}
+
+	expected := []string{ExecutionRunning, ExecutionDone, ExecutionPending, ExecutionNotPickedUp}
+	for i, n := range e.Nodes(started, alive, now) {
+		if n.NodeID != e.NodeIDs[i] || n.Status != expected[i] {
+			t.Errorf("#%d: expected %s %s, got %s %s", i, e.NodeIDs[i], expected[i], n.NodeID, n.Status)
+		}
+	}
+
+	// 超过 ExecutionPickupTimeout 还没有开始执行
+	nodes := e.Nodes(started, alive, now.Add(ExecutionPickupTimeout))
+	if nodes[2].Status != ExecutionNotPickedUp {
+		t.Errorf("expected %s, got %s", ExecutionNotPickedUp, nodes[2].Status)
+	}
+}
+
+func TestExecutionCheck(t *testing.T) {
+	e := &Execution{NodeIDs: []string{" n1 ", "", "n2"}, Env: []*JobEnv{{Key: " FOO ", Value: "1"}}}
+	if err := e.check(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(e.NodeIDs, []string{"n1", "n2"}) || e.Env[0].Key != "FOO" {
+		t.Errorf("unexpected execution after check %+v", e)
+	}
+
+	e.Env = append(e.Env, &JobEnv{Key: EnvJobID})
+	if err := e.check(); err != ErrIllegalJobEnv {
+		t.Errorf("expected %v, got %v", ErrIllegalJobEnv, err)
+	}
+}
+
+func TestOnceExecution(t *testing.T) {
+	o := ParseOnce([]byte(`{"node_ids":["n1","n3"],"execution_id":"e1","args":["-v","x y"],"env":[{"key":"FOO","value":"2"}]}`))
+	if !o.IsRunOn("n1") || o.IsRunOn("n2") || !o.IsRunOn("n3") {
+		t.Errorf("expected run on n1 and n3 only")
+	}
+
+	tr := o.Trigger()
+	if tr.Type != TriggerOnce || tr.ExecutionID != "e1" {
+		t.Errorf("unexpected trigger %+v", tr)
+	}
+	if cmd := tr.command([]string{"/bin/echo", "a"}); !reflect.DeepEqual(cmd, []string{"/bin/echo", "a", "-v", "x y"}) {
+		t.Errorf("unexpected command %v", cmd)
+	}
+
+	j := &Job{ID: "j1", Command: "/bin/echo a", Env: []*JobEnv{{Key: "FOO", Value: "1"}}}
+	if c := j.command(tr); c != "/bin/echo a -v x y" {
+		t.Errorf("unexpected job log command %q", c)
+	}
+
+	env := j.environ(tr)
+	var foo []string
+	for _, e := range env {
+		if len(e) > 4 && e[:4] == "FOO=" {
+			foo = append(foo, e)
+		}
+	}
+	// 同名的环境变量以后面的为准
+	if !reflect.DeepEqual(foo, []string{"FOO=1", "FOO=2"}) {
+		t.Errorf("expected env override after job env, got %v", foo)
+	}
+}
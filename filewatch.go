@@ -0,0 +1,258 @@
+package cronsun
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"cronsun/log"
+)
+
+// 文件触发的默认合并时间，单位秒
+const DefaultFileDebounce = 1
+
+// 规则的文件触发设置，结点上的目录中有匹配的文件出现或修改时执行任务
+// 文件在结点本地，任一结点任务和分片任务的文件触发也只在本结点执行
+type FileWatch struct {
+	// 监听的目录，结点上的绝对路径，不包括子目录
+	Dir string `json:"dir"`
+	// 匹配文件名的 glob，如 *.csv，为空时匹配所有文件
+	Pattern string `json:"pattern"`
+	// 同一文件的多个事件在这段时间内合并为一次，单位秒，不大于 0 时为 DefaultFileDebounce
+	Debounce int64 `json:"debounce"`
+	// 文件的大小和修改时间在这段时间内不再变化才执行，用于等待上传完成
+	// 单位秒，不大于 0 时不等待
+	Settle int64 `json:"settle"`
+	// 每个文件只执行一次，文件删除或移走后再出现时重新执行
+	OncePerFile bool `json:"once_per_file"`
+}
+
+func (w *FileWatch) check() error {
+	if w == nil {
+		return nil
+	}
+
+	w.Dir = strings.TrimSpace(w.Dir)
+	if !filepath.IsAbs(w.Dir) {
+		return ErrIllegalFileWatch
+	}
+	w.Pattern = strings.TrimSpace(w.Pattern)
+	if _, err := filepath.Match(w.Pattern, ""); err != nil {
+		return ErrIllegalFileWatch
+	}
+	if w.Debounce < 0 || w.Settle < 0 {
+		return ErrIllegalFileWatch
+	}
+	return nil
+}
+
+func (w *FileWatch) Equal(o *FileWatch) bool {
+	if w == nil || o == nil {
+		return w == o
+	}
+	return *w == *o
+}
+
+func (w *FileWatch) match(name string) bool {
+	if len(w.Pattern) == 0 {
+		return true
+	}
+	ok, _ := filepath.Match(w.Pattern, filepath.Base(name))
+	return ok
+}
+
+func (w *FileWatch) debounce() time.Duration {
+	if w.Debounce <= 0 {
+		return DefaultFileDebounce * time.Second
+	}
+	return time.Duration(w.Debounce) * time.Second
+}
+
+// FileWatcher 监听规则的目录，匹配的文件稳定后调用 run
+type FileWatcher struct {
+	watch *FileWatch
+	run   func(file string)
+
+	w      *fsnotify.Watcher
+	mu     sync.Mutex
+	timers map[string]*time.Timer
+	fired  map[string]bool
+	done   chan struct{}
+}
+
+func NewFileWatcher(watch *FileWatch, run func(file string)) *FileWatcher {
+	return &FileWatcher{
+		watch:  watch,
+		run:    run,
+		timers: make(map[string]*time.Timer),
+		fired:  make(map[string]bool),
+		done:   make(chan struct{}),
+	}
+}
+
+func (fw *FileWatcher) Start() (err error) {
+	if fw.w, err = fsnotify.NewWatcher(); err != nil {
+		return
+	}
+	if err = fw.w.Add(fw.watch.Dir); err != nil {
+		fw.w.Close()
+		return
+	}
+
+	go fw.loop()
+	return
+}
+
+func (fw *FileWatcher) Stop() {
+	fw.mu.Lock()
+	select {
+	case <-fw.done:
+		fw.mu.Unlock()
+		return
+	default:
+	}
+	close(fw.done)
+	for name, t := range fw.timers {
+		t.Stop()
+		delete(fw.timers, name)
+	}
+	fw.mu.Unlock()
+
+	// 不能在持有锁时关闭，处理中的事件需要取得锁
+	if err := fw.w.Close(); err != nil {
+		log.Warnf("close watcher of dir[%s] err: %s", fw.watch.Dir, err.Error())
+	}
+}
+
+func (fw *FileWatcher) loop() {
+	for {
+		select {
+		case <-fw.done:
+			return
+		case ev, ok := <-fw.w.Events:
+			if !ok {
+				return
+			}
+			fw.event(ev)
+		case err, ok := <-fw.w.Errors:
+			if !ok {
+				return
+			}
+			log.Warnf("watch dir[%s] err: %s", fw.watch.Dir, err.Error())
+		}
+	}
+}
+
+func (fw *FileWatcher) event(ev fsnotify.Event) {
+	if !fw.watch.match(ev.Name) {
+		return
+	}
+
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	if ev.Op&(fsnotify.Remove|fsnotify.Rename) > 0 {
+		if t, ok := fw.timers[ev.Name]; ok {
+			t.Stop()
+			delete(fw.timers, ev.Name)
+		}
+		delete(fw.fired, ev.Name)
+		return
+	}
+
+	if ev.Op&(fsnotify.Create|fsnotify.Write) == 0 {
+		return
+	}
+	if fw.watch.OncePerFile && fw.fired[ev.Name] {
+		return
+	}
+
+	// 同一文件的事件重新计时
+	if t, ok := fw.timers[ev.Name]; ok {
+		t.Stop()
+	}
+	fw.wait(ev.Name, fw.watch.debounce(), nil)
+}
+
+// 等待 d 后检查文件，调用时需要持有锁
+func (fw *FileWatcher) wait(name string, d time.Duration, prev os.FileInfo) {
+	t := new(*time.Timer)
+	*t = time.AfterFunc(d, func() { fw.settle(name, prev, t) })
+	fw.timers[name] = *t
+}
+
+// 文件在 Settle 时间内没有变化时执行，否则重新等待
+func (fw *FileWatcher) settle(name string, prev os.FileInfo, t **time.Timer) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	// 已停止或者有新的事件重新计时，t 在持有锁时设置
+	if fw.timers[name] != *t {
+		return
+	}
+	delete(fw.timers, name)
+
+	fi, err := os.Stat(name)
+	if err != nil || !fi.Mode().IsRegular() {
+		return
+	}
+
+	if fw.watch.Settle > 0 && (prev == nil || fi.Size() != prev.Size() || !fi.ModTime().Equal(prev.ModTime())) {
+		fw.wait(name, time.Duration(fw.watch.Settle)*time.Second, fi)
+		return
+	}
+
+	if fw.watch.OncePerFile {
+		if fw.fired[name] {
+			return
+		}
+		fw.fired[name] = true
+	}
+	go fw.runWithRecovery(name)
+}
+
+func (fw *FileWatcher) runWithRecovery(name string) {
+	defer func() {
+		if r := recover(); r != nil {
+			const size = 64 << 10
+			buf := make([]byte, size)
+			buf = buf[:runtime.Stack(buf, false)]
+			log.Warnf("panic running file[%s] of dir[%s]: %v\n%s", name, fw.watch.Dir, r, buf)
+		}
+	}()
+	fw.run(name)
+}
+
+// RunFile 执行文件触发的任务，文件在本结点上，不调度到其它结点
+func (c *Cmd) RunFile(file string) {
+	tr := &Trigger{
+		Type:     TriggerFile,
+		RuleID:   c.JobRule.ID,
+		FireTime: time.Now(),
+		File:     file,
+	}
+
+	if !c.Job.checkActiveTime(tr.FireTime) {
+		return
+	}
+	if msg := c.JobRule.suppressed(tr.FireTime); len(msg) > 0 {
+		c.Job.Skip(tr, fmt.Sprintf("job[%s] rule[%s] file[%s] suppressed by calendar: %s", c.Job.Key(), c.JobRule.ID, file, msg))
+		return
+	}
+	if !c.Job.checkWindow(tr) {
+		return
+	}
+	if !c.beginOverlap(tr) {
+		return
+	}
+	for tr != nil {
+		c.run(tr)
+		tr = c.endOverlap(tr)
+	}
+}
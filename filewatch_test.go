@@ -0,0 +1,170 @@
+package cronsun
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	client "github.com/coreos/etcd/clientv3"
+
+	"cronsun/conf"
+)
+
+func TestFileWatchCheck(t *testing.T) {
+	tests := []struct {
+		watch *FileWatch
+		err   error
+	}{
+		{nil, nil},
+		{&FileWatch{Dir: "/data/in"}, nil},
+		{&FileWatch{Dir: " /data/in ", Pattern: " *.csv "}, nil},
+		{&FileWatch{Dir: "/data/in", Debounce: 5, Settle: 10, OncePerFile: true}, nil},
+		{&FileWatch{}, ErrIllegalFileWatch},
+		{&FileWatch{Dir: "data/in"}, ErrIllegalFileWatch},
+		{&FileWatch{Dir: "/data/in", Pattern: "[a-"}, ErrIllegalFileWatch},
+		{&FileWatch{Dir: "/data/in", Debounce: -1}, ErrIllegalFileWatch},
+		{&FileWatch{Dir: "/data/in", Settle: -1}, ErrIllegalFileWatch},
+	}
+
+	for i, test := range tests {
+		if err := test.watch.check(); err != test.err {
+			t.Errorf("#%d: expected %v, got %v", i, test.err, err)
+		}
+	}
+}
+
+func TestFileWatchMatch(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		match         bool
+	}{
+		{"", "/data/in/a.csv", true},
+		{"*.csv", "/data/in/a.csv", true},
+		{"*.csv", "/data/in/a.csv.part", false},
+		{"a?.txt", "/data/in/ab.txt", true},
+		{"a?.txt", "/data/in/abc.txt", false},
+	}
+
+	for i, test := range tests {
+		if m := (&FileWatch{Pattern: test.pattern}).match(test.name); m != test.match {
+			t.Errorf("#%d: expected %v, got %v", i, test.match, m)
+		}
+	}
+
+	w := &FileWatch{Dir: "/data/in", Pattern: "*.csv"}
+	if !w.Equal(&FileWatch{Dir: "/data/in", Pattern: "*.csv"}) || w.Equal(&FileWatch{Dir: "/data/in"}) || w.Equal(nil) {
+		t.Error("unexpected FileWatch.Equal result")
+	}
+	if !(*FileWatch)(nil).Equal(nil) {
+		t.Error("expected nil watches to be equal")
+	}
+}
+
+func TestFileWatcher(t *testing.T) {
+	dir := t.TempDir()
+	files := make(chan string, 8)
+	fw := NewFileWatcher(&FileWatch{Dir: dir, Pattern: "*.csv", OncePerFile: true}, func(file string) {
+		files <- file
+	})
+	if err := fw.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer fw.Stop()
+
+	expect := func(name string) {
+		t.Helper()
+		select {
+		case f := <-files:
+			if f != name {
+				t.Errorf("expected %s, got %s", name, f)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("expected %s to trigger", name)
+		}
+	}
+	none := func() {
+		t.Helper()
+		select {
+		case f := <-files:
+			t.Errorf("unexpected trigger %s", f)
+		case <-time.After(1500 * time.Millisecond):
+		}
+	}
+
+	name := filepath.Join(dir, "a.csv")
+	// 多次写入合并为一次执行
+	for i := 0; i < 3; i++ {
+		if err := os.WriteFile(name, []byte("1,2,3\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	expect(name)
+
+	// 每个文件只执行一次，删除后再出现时重新执行
+	if err := os.WriteFile(name, []byte("4,5,6\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	none()
+
+	if err := os.Remove(name); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	expect(name)
+}
+
+func TestFileEnv(t *testing.T) {
+	var file string
+	for _, e := range (&Job{}).environ(&Trigger{Type: TriggerFile, File: "/data/in/a.csv"}) {
+		if len(e) > len(EnvFile) && e[:len(EnvFile)+1] == EnvFile+"=" {
+			file = e[len(EnvFile)+1:]
+		}
+	}
+	if file != "/data/in/a.csv" {
+		t.Errorf("expected %s=/data/in/a.csv, got %q", EnvFile, file)
+	}
+}
+
+func TestRunFileWatchOnly(t *testing.T) {
+	cli, err := client.New(client.Config{Endpoints: []string{"127.0.0.1:1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	dc := DefalutClient
+	DefalutClient = &Client{Client: cli, reqTimeout: 100 * time.Millisecond}
+	defer func() { DefalutClient = dc }()
+
+	lockTtl := conf.Config.LockTtl
+	conf.Config.LockTtl = 300
+	defer func() { conf.Config.LockTtl = lockTtl }()
+
+	for _, kind := range []int{KindAlone, KindInterval} {
+		c := &Cmd{
+			Job:     &Job{ID: "w1", Group: "default", Kind: kind, Timeout: 30},
+			JobRule: &JobRule{ID: "r1", Watch: &FileWatch{Dir: "/data/in"}},
+		}
+		if ttl := c.lockTtl(); ttl != 32 {
+			t.Errorf("kind %d: expected ttl 32, got %d", kind, ttl)
+		}
+		// 取不到锁时不执行
+		c.RunFile("/data/in/a.csv")
+	}
+}
+
+func TestFileWatcherRecover(t *testing.T) {
+	done := make(chan struct{})
+	fw := NewFileWatcher(&FileWatch{Dir: "/data/in"}, func(file string) {
+		defer close(done)
+		panic(file)
+	})
+	fw.runWithRecovery("/data/in/a.csv")
+	<-done
+}
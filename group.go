@@ -3,11 +3,15 @@ package cronsun
 import (
 	"encoding/json"
 	"fmt"
+	"net"
+	"path"
+	"regexp"
 	"strings"
 
 	client "github.com/coreos/etcd/clientv3"
 
 	"cronsun/conf"
+	"cronsun/db/entries"
 	"cronsun/log"
 )
 
@@ -18,6 +22,17 @@ type Group struct {
 	Name string `json:"name"`
 
 	NodeIDs []string `json:"nids"`
+
+	// 动态分组条件，结点满足所有设置了的条件时属于分组
+	// 同一条件的多个值满足任一个即可
+	// 主机名，支持通配符如 web-*，以 / 开头和结尾时为正则表达式，如 /^web-\d+$/
+	Hostnames []string `json:"hostnames"`
+	// IP 网段，如 10.2.0.0/16
+	CIDRs []string `json:"cidrs"`
+	// 结点版本约束，如 >=0.3.5, <0.4
+	Version string `json:"version"`
+
+	matcher *groupMatcher
 }
 
 func GetGroupById(gid string) (g *Group, err error) {
@@ -33,9 +48,9 @@ func GetGroupById(gid string) (g *Group, err error) {
 	return
 }
 
-// GetGroups 获取包含 nid 的 group
-// 如果 nid 为空，则获取所有的 group
-func GetGroups(nid string) (groups map[string]*Group, err error) {
+// GetGroups 获取包含结点 n 的 group
+// 如果 n 为 nil，则获取所有的 group
+func GetGroups(n *entries.Node) (groups map[string]*Group, err error) {
 	resp, err := DefalutClient.Get(conf.Config.Group, client.WithPrefix())
 	if err != nil {
 		return
@@ -53,7 +68,7 @@ func GetGroups(nid string) (groups map[string]*Group, err error) {
 			log.Warnf("group[%s] umarshal err: %s", string(g.Key), e.Error())
 			continue
 		}
-		if len(nid) == 0 || group.Included(nid) {
+		if n == nil || group.Included(n) {
 			groups[group.ID] = group
 		}
 	}
@@ -104,15 +119,121 @@ func (g *Group) Check() error {
 		return ErrEmptyNodeGroupName
 	}
 
-	return nil
+	g.Hostnames, g.CIDRs = trimIDs(g.Hostnames), trimIDs(g.CIDRs)
+	g.Version = strings.TrimSpace(g.Version)
+	g.matcher = nil
+	_, err := g.getMatcher()
+	return err
+}
+
+// Dynamic 是否设置了动态分组条件
+func (g *Group) Dynamic() bool {
+	return len(g.Hostnames) > 0 || len(g.CIDRs) > 0 || len(g.Version) > 0
 }
 
-func (g *Group) Included(nid string) bool {
+// Included 结点是否属于分组，在 NodeIDs 中或满足动态分组条件
+func (g *Group) Included(n *entries.Node) bool {
 	for i, count := 0, len(g.NodeIDs); i < count; i++ {
-		if nid == g.NodeIDs[i] {
+		if n.ID == g.NodeIDs[i] {
 			return true
 		}
 	}
 
+	if !g.Dynamic() {
+		return false
+	}
+
+	m, err := g.getMatcher()
+	if err != nil {
+		log.Warnf("group[%s] %s", g.ID, err.Error())
+		return false
+	}
+	return m.match(n)
+}
+
+// 解析后的动态分组条件
+type groupMatcher struct {
+	globs   []string
+	regexps []*regexp.Regexp
+	nets    []*net.IPNet
+	version versionConstraint
+}
+
+// 从 etcd 读取的分组在第一次使用时解析
+func (g *Group) getMatcher() (*groupMatcher, error) {
+	if g.matcher != nil {
+		return g.matcher, nil
+	}
+
+	m := &groupMatcher{}
+	for _, h := range g.Hostnames {
+		if len(h) > 1 && strings.HasPrefix(h, "/") && strings.HasSuffix(h, "/") {
+			re, err := regexp.Compile(h[1 : len(h)-1])
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrIllegalNodeGroupHostname, err.Error())
+			}
+			m.regexps = append(m.regexps, re)
+			continue
+		}
+
+		if _, err := path.Match(h, ""); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrIllegalNodeGroupHostname, h)
+		}
+		m.globs = append(m.globs, h)
+	}
+
+	for _, c := range g.CIDRs {
+		_, ipnet, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrIllegalNodeGroupCIDR, c)
+		}
+		m.nets = append(m.nets, ipnet)
+	}
+
+	var err error
+	if m.version, err = parseVersionConstraint(g.Version); err != nil {
+		return nil, err
+	}
+
+	g.matcher = m
+	return m, nil
+}
+
+func (m *groupMatcher) match(n *entries.Node) bool {
+	return m.matchHostname(n.Hostname) && m.matchIP(n.IP) && m.version.match(n.Version)
+}
+
+func (m *groupMatcher) matchHostname(hostname string) bool {
+	if len(m.globs) == 0 && len(m.regexps) == 0 {
+		return true
+	}
+
+	for _, glob := range m.globs {
+		if ok, _ := path.Match(glob, hostname); ok {
+			return true
+		}
+	}
+	for _, re := range m.regexps {
+		if re.MatchString(hostname) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *groupMatcher) matchIP(s string) bool {
+	if len(m.nets) == 0 {
+		return true
+	}
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return false
+	}
+	for _, ipnet := range m.nets {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
 	return false
 }
@@ -0,0 +1,64 @@
+package cronsun
+
+import (
+	"errors"
+	"testing"
+
+	"cronsun/db/entries"
+)
+
+func TestGroupIncluded(t *testing.T) {
+	web1 := &entries.Node{ID: "n1", Hostname: "web-1", IP: "10.2.3.4", Version: "v0.3.5 (build go1.10)"}
+	web2 := &entries.Node{ID: "n2", Hostname: "web-2", IP: "10.3.0.1", Version: "v0.4.0 (build go1.10)"}
+	db1 := &entries.Node{ID: "n3", Hostname: "db-1", IP: "10.2.0.9", Version: "v0.3.4 (build go1.10)"}
+
+	tests := []struct {
+		g        Group
+		expected []bool
+	}{
+		{Group{NodeIDs: []string{"n1", "n3"}}, []bool{true, false, true}},
+		{Group{Hostnames: []string{"web-*"}}, []bool{true, true, false}},
+		{Group{Hostnames: []string{`/^(web|db)-1$/`}}, []bool{true, false, true}},
+		{Group{CIDRs: []string{"10.2.0.0/16"}}, []bool{true, false, true}},
+		{Group{CIDRs: []string{"10.2.0.0/16", "10.3.0.0/24"}}, []bool{true, true, true}},
+		{Group{Version: ">=0.3.5"}, []bool{true, true, false}},
+		{Group{Version: ">=0.3.5, <0.4"}, []bool{true, false, false}},
+		{Group{Version: "0.3.4"}, []bool{false, false, true}},
+		// 所有动态条件都满足时才属于分组
+		{Group{Hostnames: []string{"web-*"}, CIDRs: []string{"10.2.0.0/16"}}, []bool{true, false, false}},
+		{Group{NodeIDs: []string{"n3"}, Hostnames: []string{"web-*"}, Version: "!=0.4"}, []bool{true, false, true}},
+	}
+
+	for i, test := range tests {
+		test.g.ID, test.g.Name = "g", "g"
+		if err := test.g.Check(); err != nil {
+			t.Fatalf("#%d: unexpected error %v", i, err)
+		}
+
+		for j, n := range []*entries.Node{web1, web2, db1} {
+			if in := test.g.Included(n); in != test.expected[j] {
+				t.Errorf("#%d %s: expected %v, got %v", i, n.Hostname, test.expected[j], in)
+			}
+		}
+	}
+}
+
+func TestGroupCheckDynamic(t *testing.T) {
+	tests := []struct {
+		g   Group
+		err error
+	}{
+		{Group{Hostnames: []string{"web-["}}, ErrIllegalNodeGroupHostname},
+		{Group{Hostnames: []string{"/web-(/"}}, ErrIllegalNodeGroupHostname},
+		{Group{CIDRs: []string{"10.2.0.0"}}, ErrIllegalNodeGroupCIDR},
+		{Group{Version: ">=x"}, ErrIllegalNodeGroupVersion},
+		{Group{Hostnames: []string{" web-* "}, CIDRs: []string{"10.2.0.0/16"}, Version: " >= 0.3 "}, nil},
+	}
+
+	for i, test := range tests {
+		test.g.ID, test.g.Name = "g", "g"
+		if err := test.g.Check(); !errors.Is(err, test.err) {
+			t.Errorf("#%d: expected %v, got %v", i, test.err, err)
+		}
+	}
+}
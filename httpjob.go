@@ -0,0 +1,344 @@
+package cronsun
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cronsun/conf"
+	"cronsun/db/entries"
+)
+
+// 任务的执行方式
+const (
+	JobTypeCmd    = "cmd"    // 执行命令，默认
+	JobTypeHTTP   = "http"   // 发送 HTTP 请求
+	JobTypeScript = "script" // 执行保存在任务中的脚本
+)
+
+// HTTP 任务的请求
+type HTTPJob struct {
+	// 请求方法，默认 GET
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+	// 视为成功的响应状态码，为空时 2xx 都视为成功
+	ExpectStatus []int `json:"expect_status"`
+	// 响应内容需要匹配的正则表达式，为空时不检查
+	// 响应内容超出长度时只匹配保留的开头和结尾部分
+	BodyMatch string `json:"body_match"`
+
+	// 不校验服务端证书
+	InsecureSkipVerify bool `json:"insecure_skip_verify"`
+	// PEM 格式的 CA 证书，为空时使用系统的 CA 证书
+	CACert string `json:"ca_cert"`
+	// 校验证书使用的主机名，为空时使用 url 中的主机名
+	ServerName string `json:"server_name"`
+}
+
+func (j *Job) checkType() error {
+	j.Type = strings.ToLower(strings.TrimSpace(j.Type))
+	switch j.Type {
+	case "", JobTypeCmd:
+		// 不修改 Command 的内容，简单判断是否为空
+		if len(strings.TrimSpace(j.Command)) == 0 {
+			return ErrEmptyJobCommand
+		}
+	case JobTypeHTTP:
+		if j.HTTP == nil {
+			return fmt.Errorf("%w: empty request", ErrIllegalHTTPJob)
+		}
+		return j.HTTP.check()
+	case JobTypeScript:
+		return j.Script.check()
+	default:
+		return ErrIllegalJobType
+	}
+	return nil
+}
+
+func (h *HTTPJob) check() error {
+	h.Method = strings.ToUpper(strings.TrimSpace(h.Method))
+	if len(h.Method) == 0 {
+		h.Method = http.MethodGet
+	}
+	if strings.ContainsAny(h.Method, " \t\r\n") {
+		return fmt.Errorf("%w: illegal method %q", ErrIllegalHTTPJob, h.Method)
+	}
+
+	h.URL = strings.TrimSpace(h.URL)
+	u, err := url.Parse(h.URL)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrIllegalHTTPJob, err.Error())
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return fmt.Errorf("%w: url should be an absolute http or https url", ErrIllegalHTTPJob)
+	}
+
+	for k, v := range h.Headers {
+		if len(k) == 0 || strings.ContainsAny(k, " \t\r\n:") || strings.ContainsAny(v, "\r\n") {
+			return fmt.Errorf("%w: illegal header %q", ErrIllegalHTTPJob, k)
+		}
+	}
+
+	for _, code := range h.ExpectStatus {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("%w: illegal status code %d", ErrIllegalHTTPJob, code)
+		}
+	}
+
+	if _, err = regexp.Compile(h.BodyMatch); err != nil {
+		return fmt.Errorf("%w: %s", ErrIllegalHTTPJob, err.Error())
+	}
+
+	h.ServerName = strings.TrimSpace(h.ServerName)
+	if _, err = h.tlsConfig(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (h *HTTPJob) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{
+		InsecureSkipVerify: h.InsecureSkipVerify,
+		ServerName:         h.ServerName,
+	}
+	if len(strings.TrimSpace(h.CACert)) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(h.CACert)) {
+			return nil, fmt.Errorf("%w: no certificate found in ca_cert", ErrIllegalHTTPJob)
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+// 记录在 job log 中的命令
+func (j *Job) command(tr *Trigger) string {
+	if j.Type == JobTypeHTTP && j.HTTP != nil {
+		return j.HTTP.Method + " " + j.HTTP.URL
+	}
+	if j.Type == JobTypeScript && j.Script != nil {
+		return j.Script.Interpreter + " <script sha256:" + j.Script.SHA256 + ">" + tr.argsString()
+	}
+	return j.Command + tr.argsString()
+}
+
+// 状态码是否符合预期
+func (h *HTTPJob) expect(code int) bool {
+	if len(h.ExpectStatus) == 0 {
+		return code >= 200 && code < 300
+	}
+	for _, c := range h.ExpectStatus {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// 开启安全选项时，url 的主机需要在 Security.Hosts 中
+func (h *HTTPJob) validHost() bool {
+	if h == nil {
+		return false
+	}
+	u, err := url.Parse(h.URL)
+	if err != nil {
+		return false
+	}
+	return validURLHost(u)
+}
+
+func validURLHost(u *url.URL) bool {
+	hosts := conf.Config.Security.Hosts
+	if !conf.Config.Security.Open || len(hosts) == 0 {
+		return true
+	}
+
+	host := strings.ToLower(u.Hostname())
+	for _, h := range hosts {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if h == host || h == strings.ToLower(u.Host) {
+			return true
+		}
+		if strings.HasPrefix(h, "*.") && strings.HasSuffix(host, h[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// 记录请求各阶段的耗时
+type httpTimer struct {
+	mu                             sync.Mutex
+	begin                          time.Time
+	dnsStart, connStart, tlsStart  time.Time
+	dns, connect, handshake, first time.Duration
+}
+
+func (t *httpTimer) trace() *httptrace.ClientTrace {
+	now := func(p *time.Time) {
+		t.mu.Lock()
+		*p = time.Now()
+		t.mu.Unlock()
+	}
+	since := func(d *time.Duration, start *time.Time) {
+		t.mu.Lock()
+		if !start.IsZero() {
+			*d = time.Since(*start)
+		}
+		t.mu.Unlock()
+	}
+
+	return &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { now(&t.dnsStart) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { since(&t.dns, &t.dnsStart) },
+		ConnectStart:         func(string, string) { now(&t.connStart) },
+		ConnectDone:          func(string, string, error) { since(&t.connect, &t.connStart) },
+		TLSHandshakeStart:    func() { now(&t.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { since(&t.handshake, &t.tlsStart) },
+		GotFirstResponseByte: func() { since(&t.first, &t.begin) },
+	}
+}
+
+func (t *httpTimer) timing() *entries.HTTPTiming {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return &entries.HTTPTiming{
+		DNS:       t.dns.Milliseconds(),
+		Connect:   t.connect.Milliseconds(),
+		TLS:       t.handshake.Milliseconds(),
+		FirstByte: t.first.Milliseconds(),
+		Total:     time.Since(t.begin).Milliseconds(),
+	}
+}
+
+// 发送 HTTP 请求，响应内容作为任务的输出
+// 超时、执行时间段结束和被新的执行替换时取消请求
+func (j *Job) execHTTP(tr *Trigger) *ExecResult {
+	t := time.Now()
+	h := j.HTTP
+	body, stderr := j.newOutputBuffer(t, "stdout"), j.newOutputBuffer(t, "stderr")
+
+	cfg, err := h.tlsConfig()
+	if err != nil {
+		r := newExecResult(t, body, stderr, nil, err)
+		r.StartError = true
+		return r
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ht := &httpTimer{begin: t}
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, ht.trace()), h.Method, h.URL, strings.NewReader(h.Body))
+	if err != nil {
+		r := newExecResult(t, body, stderr, nil, err)
+		r.StartError = true
+		return r
+	}
+	for k, v := range h.Headers {
+		req.Header.Set(k, v)
+	}
+	if host := req.Header.Get("Host"); len(host) > 0 {
+		req.Host = host
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = cfg
+	transport.DisableKeepAlives = true
+	client := &http.Client{
+		Transport: transport,
+		// 跳转后的主机也需要在白名单中
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			if !validURLHost(req.URL) {
+				return ErrSecurityInvalidHost
+			}
+			return nil
+		},
+	}
+
+	// 启动前已被新的执行替换
+	if !tr.requested(cancel) {
+		cancel()
+	}
+	defer tr.exited()
+
+	var timedOut int32
+	if j.Timeout > 0 {
+		timer := time.AfterFunc(time.Duration(j.Timeout)*time.Second, func() {
+			atomic.StoreInt32(&timedOut, 1)
+			cancel()
+		})
+		defer timer.Stop()
+	}
+
+	var windowClosed int32
+	end, _ := j.windowEnd(t)
+	if !end.IsZero() {
+		timer := time.AfterFunc(time.Until(end), func() {
+			atomic.StoreInt32(&windowClosed, 1)
+			cancel()
+		})
+		defer timer.Stop()
+	}
+
+	var (
+		status  int
+		headers map[string][]string
+	)
+	resp, err := client.Do(req)
+	if err == nil {
+		status, headers = resp.StatusCode, resp.Header
+		_, err = io.Copy(body, resp.Body)
+		resp.Body.Close()
+		if err == nil && !h.expect(status) {
+			err = fmt.Errorf("unexpected status: %s", resp.Status)
+		}
+		if err == nil && len(h.BodyMatch) > 0 {
+			if re, _ := regexp.Compile(h.BodyMatch); re != nil && !re.MatchString(body.String()) {
+				err = fmt.Errorf("response body does not match %q", h.BodyMatch)
+			}
+		}
+	}
+
+	var closed bool
+	if err != nil {
+		switch {
+		case atomic.LoadInt32(&timedOut) == 1:
+			err = fmt.Errorf("timeout after %ds: %s", j.Timeout, err.Error())
+		case atomic.LoadInt32(&windowClosed) == 1:
+			closed = true
+			err = fmt.Errorf("execution window closed at %s: %s", end.Format(time.RFC3339), err.Error())
+		case tr.isCanceled():
+			err = fmt.Errorf("%s: %s", tr.cancelReason(), err.Error())
+		}
+	}
+
+	r := newExecResult(t, body, stderr, nil, err)
+	r.TimedOut = err != nil && atomic.LoadInt32(&timedOut) == 1
+	r.WindowClosed = closed
+	r.HTTPTiming = ht.timing()
+	if status > 0 {
+		r.ExitCode, r.HTTPStatus, r.HTTPHeaders = status, status, headers
+	} else if !r.TimedOut && !r.WindowClosed {
+		// 没有收到响应
+		r.StartError = true
+	}
+	return r
+}
@@ -0,0 +1,138 @@
+package cronsun
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"cronsun/conf"
+)
+
+func TestJobCheckType(t *testing.T) {
+	tests := []struct {
+		job *Job
+		err error
+	}{
+		{&Job{Command: "echo"}, nil},
+		{&Job{Type: " CMD "}, ErrEmptyJobCommand},
+		{&Job{Type: "ftp"}, ErrIllegalJobType},
+		{&Job{Type: JobTypeHTTP}, ErrIllegalHTTPJob},
+		{&Job{Type: JobTypeHTTP, HTTP: &HTTPJob{URL: "http://127.0.0.1/ping"}}, nil},
+		{&Job{Type: JobTypeHTTP, HTTP: &HTTPJob{URL: "/ping"}}, ErrIllegalHTTPJob},
+		{&Job{Type: JobTypeHTTP, HTTP: &HTTPJob{URL: "ftp://127.0.0.1/"}}, ErrIllegalHTTPJob},
+		{&Job{Type: JobTypeHTTP, HTTP: &HTTPJob{URL: "http://a/", Method: "GE T"}}, ErrIllegalHTTPJob},
+		{&Job{Type: JobTypeHTTP, HTTP: &HTTPJob{URL: "http://a/", Headers: map[string]string{"X-A": "1\r\n"}}}, ErrIllegalHTTPJob},
+		{&Job{Type: JobTypeHTTP, HTTP: &HTTPJob{URL: "http://a/", ExpectStatus: []int{200, 600}}}, ErrIllegalHTTPJob},
+		{&Job{Type: JobTypeHTTP, HTTP: &HTTPJob{URL: "http://a/", BodyMatch: "("}}, ErrIllegalHTTPJob},
+		{&Job{Type: JobTypeHTTP, HTTP: &HTTPJob{URL: "http://a/", CACert: "not a pem"}}, ErrIllegalHTTPJob},
+	}
+
+	for i, test := range tests {
+		if err := test.job.checkType(); !errors.Is(err, test.err) {
+			t.Errorf("#%d: expected %v, got %v", i, test.err, err)
+		}
+	}
+
+	h := &HTTPJob{URL: " http://a/ ", Method: " post "}
+	if err := h.check(); err != nil || h.Method != http.MethodPost || h.URL != "http://a/" {
+		t.Errorf("unexpected request after check %+v, err: %v", h, err)
+	}
+}
+
+func TestHTTPJobValidHost(t *testing.T) {
+	security := conf.Config.Security
+	defer func() { conf.Config.Security = security }()
+	conf.Config.Security = &conf.Security{Open: true, Hosts: []string{"api.local", "*.example.com", "127.0.0.1:8080"}}
+
+	tests := []struct {
+		url      string
+		expected bool
+	}{
+		{"http://api.local/ping", true},
+		{"https://API.local:8443/ping", true},
+		{"https://a.b.example.com/", true},
+		{"https://example.com/", false},
+		{"http://127.0.0.1:8080/", true},
+		{"http://127.0.0.1:9090/", false},
+		{"http://evil.local/", false},
+	}
+	for _, test := range tests {
+		if ok := (&HTTPJob{URL: test.url}).validHost(); ok != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.url, test.expected, ok)
+		}
+	}
+
+	conf.Config.Security.Open = false
+	if !(&HTTPJob{URL: "http://evil.local/"}).validHost() {
+		t.Errorf("expected any host allowed when security is closed")
+	}
+}
+
+func TestJobExecHTTP(t *testing.T) {
+	output := conf.Config.JobOutput
+	defer func() { conf.Config.JobOutput = output }()
+	conf.Config.JobOutput = &conf.JobOutputConf{HeadKB: 4, TailKB: 4}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			w.Header().Set("X-Job", r.Header.Get("X-Job"))
+			b, _ := io.ReadAll(r.Body)
+			fmt.Fprintf(w, "%s %s", r.Method, b)
+		case "/slow":
+			time.Sleep(3 * time.Second)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, "busy")
+		}
+	}))
+	defer srv.Close()
+
+	j := &Job{ID: "http", Type: JobTypeHTTP, HTTP: &HTTPJob{
+		Method:    http.MethodPost,
+		URL:       srv.URL + "/ok",
+		Headers:   map[string]string{"X-Job": "j1"},
+		Body:      "ping",
+		BodyMatch: "^POST ping$",
+	}}
+	r := j.exec(&Trigger{})
+	if !r.Success || r.HTTPStatus != http.StatusOK || r.ExitCode != http.StatusOK || r.Output != "POST ping" {
+		t.Errorf("unexpected result %+v", r)
+	}
+	if got := r.HTTPHeaders["X-Job"]; len(got) != 1 || got[0] != "j1" {
+		t.Errorf("expected response header X-Job: j1, got %v", got)
+	}
+	if r.HTTPTiming == nil {
+		t.Errorf("expected timing")
+	}
+
+	j.HTTP.BodyMatch = "pong"
+	if r = j.exec(&Trigger{}); r.Success || !strings.Contains(r.Output, "does not match") {
+		t.Errorf("expected body mismatch, got %+v", r)
+	}
+
+	j.HTTP = &HTTPJob{Method: http.MethodGet, URL: srv.URL + "/busy"}
+	r = j.exec(&Trigger{})
+	if r.Success || r.HTTPStatus != http.StatusServiceUnavailable || r.StartError || !strings.Contains(r.Output, "unexpected status") {
+		t.Errorf("expected unexpected status, got %+v", r)
+	}
+	j.HTTP.ExpectStatus = []int{http.StatusServiceUnavailable}
+	if r = j.exec(&Trigger{}); !r.Success {
+		t.Errorf("expected success with expected status, got %s", r.Output)
+	}
+
+	j.HTTP, j.Timeout = &HTTPJob{Method: http.MethodGet, URL: srv.URL + "/slow"}, 1
+	if r = j.exec(&Trigger{}); r.Success || !r.TimedOut || r.StartError {
+		t.Errorf("expected timeout, got %+v", r)
+	}
+
+	j.HTTP, j.Timeout = &HTTPJob{Method: http.MethodGet, URL: "http://127.0.0.1:1/"}, 0
+	if r = j.exec(&Trigger{}); r.Success || !r.StartError || r.HTTPStatus != 0 {
+		t.Errorf("expected start error, got %+v", r)
+	}
+}
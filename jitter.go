@@ -0,0 +1,64 @@
+package cronsun
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// 多个结点同时触发时，错开执行的方式
+const (
+	SpreadRandom = "random" // 每次随机延迟，默认
+	SpreadHash   = "hash"   // 按结点 id 计算固定的延迟
+)
+
+func (rule *JobRule) checkJitter() error {
+	if rule.Jitter < 0 {
+		rule.Jitter = 0
+	}
+
+	rule.Spread = strings.ToLower(strings.TrimSpace(rule.Spread))
+	switch rule.Spread {
+	case "":
+		rule.Spread = SpreadRandom
+	case SpreadRandom, SpreadHash:
+	default:
+		return ErrIllegalSpread
+	}
+	return nil
+}
+
+// 延迟执行的窗口，不超过执行间隔的一半，避免与下一次执行交错
+func (c *Cmd) jitterWindow() time.Duration {
+	if c.JobRule.Jitter <= 0 || c.JobRule.Schedule == nil {
+		return 0
+	}
+
+	w := time.Duration(c.JobRule.Jitter) * time.Second
+	prev := c.JobRule.Schedule.Next(time.Now())
+	next := c.JobRule.Schedule.Next(prev)
+	if prev.IsZero() || next.IsZero() {
+		return w
+	}
+	if half := next.Sub(prev) / 2; w > half {
+		w = half
+	}
+	return w
+}
+
+// 本结点本次执行的延迟
+func (c *Cmd) jitterDelay() time.Duration {
+	w := c.jitterWindow()
+	if w <= 0 {
+		return 0
+	}
+
+	if c.JobRule.Spread == SpreadHash {
+		// 同一结点上的不同规则也错开
+		h := fnv.New64a()
+		h.Write([]byte(c.Job.runOn + "/" + c.GetID()))
+		return time.Duration(h.Sum64()%uint64(w/time.Millisecond+1)) * time.Millisecond
+	}
+	return time.Duration(rand.Int63n(int64(w) + 1))
+}
@@ -0,0 +1,95 @@
+package cronsun
+
+import (
+	"testing"
+	"time"
+
+	"cronsun/conf"
+	"cronsun/node/cron"
+)
+
+func TestJitterWindow(t *testing.T) {
+	tests := []struct {
+		timer    string
+		jitter   int64
+		expected time.Duration
+	}{
+		{"0 * * * * *", 0, 0},
+		{"0 * * * * *", 10, 10 * time.Second},
+		{"0 * * * * *", 60, 30 * time.Second},
+		{"*/3 * * * * *", 10, 1500 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		sch, err := cron.Parse(test.timer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := &Cmd{Job: &Job{}, JobRule: &JobRule{Schedule: sch, Jitter: test.jitter}}
+		if w := c.jitterWindow(); w != test.expected {
+			t.Errorf("%s jitter %d: expected window %s, got %s", test.timer, test.jitter, test.expected, w)
+		}
+	}
+}
+
+func TestJitterDelay(t *testing.T) {
+	sch, err := cron.Parse("0 * * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newCmd := func(node, spread string) *Cmd {
+		c := &Cmd{
+			Job:     &Job{ID: "job"},
+			JobRule: &JobRule{ID: "rule", Schedule: sch, Jitter: 20, Spread: spread},
+		}
+		c.Job.Init(node, "", "")
+		return c
+	}
+
+	for i := 0; i < 100; i++ {
+		if d := newCmd("node", SpreadRandom).jitterDelay(); d < 0 || d > 20*time.Second {
+			t.Fatalf("random delay %s out of window", d)
+		}
+	}
+
+	a, b := newCmd("node-a", SpreadHash), newCmd("node-b", SpreadHash)
+	da, db := a.jitterDelay(), b.jitterDelay()
+	if da < 0 || da > 20*time.Second || db < 0 || db > 20*time.Second {
+		t.Fatalf("hashed delays %s, %s out of window", da, db)
+	}
+	if da == db {
+		t.Errorf("expected different delays on different nodes, got %s", da)
+	}
+	if d := a.jitterDelay(); d != da {
+		t.Errorf("expected stable hashed delay %s, got %s", da, d)
+	}
+}
+
+func TestLockTtlCoversJitter(t *testing.T) {
+	sch, err := cron.Parse("0 * * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lockTtl := conf.Config.LockTtl
+	conf.Config.LockTtl = 10
+	defer func() { conf.Config.LockTtl = lockTtl }()
+
+	for _, kind := range []int{KindAlone, KindInterval} {
+		c := &Cmd{Job: &Job{Kind: kind}, JobRule: &JobRule{Schedule: sch}}
+		if ttl := c.lockTtl(); ttl > 10 {
+			t.Errorf("kind %d: expected ttl capped at 10, got %d", kind, ttl)
+		}
+
+		c.JobRule.Jitter = 20
+		if ttl := c.lockTtl(); ttl != 21 {
+			t.Errorf("kind %d: expected ttl 21 covering the jitter window, got %d", kind, ttl)
+		}
+
+		tr := &Trigger{FireTime: time.Now()}
+		if lk := c.newLock(tr); !lk.until.Equal(tr.FireTime.Add(20 * time.Second)) {
+			t.Errorf("kind %d: expected lock held until the end of the jitter window, got %s", kind, lk.until)
+		}
+	}
+}
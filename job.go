@@ -1,14 +1,14 @@
 package cronsun
 
 import (
-	"bytes"
-	"context"
 	"cronsun/db/entries"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
 	"os/exec"
 	"os/user"
+	"path"
 	"runtime"
 	"strconv"
 	"strings"
@@ -32,6 +32,8 @@ const (
 	KindCommon   = iota
 	KindAlone    // 任何时间段只允许单机执行
 	KindInterval // 一个任务执行间隔内允许执行一次
+	KindAny      // 每次触发只调度到一个结点执行
+	KindShard    // 每次触发分片到多个结点执行
 )
 
 // 需要执行的 cron cmd 命令
@@ -45,20 +47,72 @@ type Job struct {
 	Rules   []*JobRule `json:"rules"`
 	Pause   bool       `json:"pause"`   // 可手工控制的状态
 	Timeout int64      `json:"timeout"` // 任务执行时间超时设置，大于 0 时有效
+	// 任务的有效期，为空时不限制
+	// 有效期外不执行，过期后自动暂停
+	StartAt *time.Time `json:"start_at,omitempty"`
+	EndAt   *time.Time `json:"end_at,omitempty"`
+	// 最多执行的次数，每个结点的每次执行都计数，达到后自动暂停
+	// 不大于 0 时不限制
+	MaxRuns int64 `json:"max_runs"`
+	// 允许执行的时间段，为空时不限制
+	// 时间段外不执行，时间段结束时还在执行的进程按 KillSignal 结束
+	Windows []*JobWindow `json:"windows"`
 	// 设置任务在单个节点上可以同时允许多少个
 	// 针对两次任务执行间隔比任务执行时间要长的任务启用
 	Parallels int64 `json:"parallels"`
+	// 集群内同时执行的最大任务数，大于 0 时有效
+	// 通过 etcd 信号量控制，与 Parallels 同时生效
+	MaxConcurrency int64 `json:"max_concurrency"`
+	// 达到集群最大并发数时的处理方式
+	// skip: 跳过本次执行，默认
+	// wait: 等待其它结点执行完毕
+	ConcurrencyPolicy string `json:"concurrency_policy"`
+	// wait 时的最长等待时间，单位秒，不大于 0 时一直等待
+	ConcurrencyWait int64 `json:"concurrency_wait"`
+	// 同一规则上一次执行还未结束时，再次触发的处理方式
+	// allow: 同时执行，默认
+	// skip: 跳过本次执行
+	// queue: 上一次执行结束后马上执行
+	// replace: 结束上一次执行，重新开始
+	OverlapPolicy string `json:"overlap_policy"`
+	// 错过执行时间后的处理方式，如结点停止或主机休眠期间
+	// ignore: 忽略，默认
+	// once: 马上补执行一次
+	// all: 补执行每一次错过的执行
+	MisfirePolicy string `json:"misfire_policy"`
+	// all 时最多补执行的次数，只保留最近的几次
+	// 不大于 0 时为 DefaultMisfireLimit
+	MisfireLimit int `json:"misfire_limit"`
+	// 超时或被手动结束时发送给进程组的信号，默认 SIGTERM
+	KillSignal string `json:"kill_signal"`
+	// 发送信号后等待进程退出的时间，超过后发送 SIGKILL
+	// 单位秒，不大于 0 时为 DefaultKillGrace
+	KillGrace int64 `json:"kill_grace"`
 	// 执行任务失败重试次数
 	// 默认为 0，不重试
 	Retry int `json:"retry"`
 	// 执行任务失败重试时间间隔
 	// 单位秒，如果不大于 0 则马上重试
 	Interval int `json:"interval"`
+	// 重试策略，为空时按 Interval 固定间隔重试所有失败
+	RetryPolicy *RetryPolicy `json:"retry_policy"`
 	// 任务类型
 	// 0: 普通任务
 	// 1: 单机任务
+	// 2: 间隔任务
+	// 3: 任一结点任务
+	// 4: 分片任务
 	// 如果为单机任务，node 加载任务的时候 Parallels 设置 1
 	Kind int `json:"kind"`
+	// 任一结点任务选择执行结点的方式
+	// round-robin: 轮流执行，默认
+	// least-running: 执行中的进程最少的结点
+	// weighted: 按结点的 weight 标签加权随机
+	// sticky: 固定在同一结点，结点不可用时切换
+	Strategy string `json:"strategy"`
+	// 分片任务的分片数，分配到触发时在线的结点
+	// 不大于 0 时每个在线的结点一个分片
+	Shards int `json:"shards"`
 	// 平均执行时间，单位 ms
 	AvgTime int64 `json:"avg_time"`
 	// 执行失败发送通知
@@ -67,6 +121,20 @@ type Job struct {
 	To []string `json:"to"`
 	// 单独对任务指定日志清除时间
 	LogExpiration int `json:"log_expiration"`
+	// 上游依赖任务，上游任务执行结束后按条件触发本任务
+	// 只通过依赖触发的任务，rule 的 timer 可以为空
+	Depends []*JobDepend `json:"depends"`
+	// 自定义环境变量
+	Env []*JobEnv `json:"env"`
+	// 执行任务的工作目录，为空时使用 node 进程的工作目录
+	WorkDir string `json:"work_dir"`
+	// 任务的执行方式
+	// cmd: 执行命令，默认
+	// http: 发送 HTTP 请求，使用 HTTP 中的设置，Command 和 User 无效
+	// script: 执行保存在任务中的脚本，使用 Script 中的设置，Command 无效
+	Type   string     `json:"type"`
+	HTTP   *HTTPJob   `json:"http,omitempty"`
+	Script *JobScript `json:"script,omitempty"`
 
 	// 执行任务的结点，用于记录 job log
 	runOn    string
@@ -84,14 +152,37 @@ type JobRule struct {
 	GroupIDs       []string `json:"gids"`
 	NodeIDs        []string `json:"nids"`
 	ExcludeNodeIDs []string `json:"exclude_nids"`
+	// 结点标签选择器，如 env=prod, zone in (a, b), !gpu
+	// 与 NodeIDs、GroupIDs 一样，满足任一条件的结点都会执行
+	Selector string `json:"selector"`
+	// 定时器使用的时区，IANA 名称，如 Asia/Shanghai
+	// 为空时使用结点的本地时区，也可以在 Timer 前加 CRON_TZ= 指定
+	Timezone string `json:"timezone"`
+	// 多个结点同时触发时，每个结点延迟执行的最长时间，单位秒
+	// 不超过执行间隔的一半，不大于 0 时不延迟
+	Jitter int64 `json:"jitter"`
+	// 延迟时间的计算方式
+	// random: 每次随机延迟，默认
+	// hash: 按结点 id 计算固定的延迟
+	Spread string `json:"spread"`
+	// 引用的日历 id
+	// 设置了 include 时只在日历包含的日期执行，exclude 日历包含的日期不执行
+	IncludeCalendars []string `json:"include_calendars"`
+	ExcludeCalendars []string `json:"exclude_calendars"`
+	// 文件触发，结点上的目录中有匹配的文件出现或修改时执行，可以和 Timer 同时使用
+	Watch *FileWatch `json:"watch,omitempty"`
 
 	Schedule cron.Schedule `json:"-"`
+
+	selector       LabelSelector
+	parsedSelector string // 已解析的 Selector
 }
 
 // 任务锁
 type locker struct {
 	kind  int
 	ttl   int64
+	until time.Time // 单机任务在此之前不释放锁
 	lID   client.LeaseID
 	timer *time.Timer
 	done  chan struct{}
@@ -120,6 +211,15 @@ func (l *locker) unlock() {
 		return
 	}
 
+	// jitter 窗口内其它结点还会尝试执行，窗口结束后再释放锁
+	if d := time.Until(l.until); d > 0 {
+		time.AfterFunc(d, l.release)
+		return
+	}
+	l.release()
+}
+
+func (l *locker) release() {
 	close(l.done)
 	l.timer.Stop()
 	if _, err := DefalutClient.Revoke(l.lID); err != nil {
@@ -137,37 +237,110 @@ func (c *Cmd) GetID() string {
 }
 
 func (c *Cmd) Run() {
+	c.RunAt(time.Now())
+}
+
+// RunAt 执行 t 时刻触发的任务，设置了 Jitter 时延迟执行
+func (c *Cmd) RunAt(t time.Time) {
+	if d := c.jitterDelay(); d > 0 {
+		time.Sleep(d)
+	}
+
+	// 等待规则的补执行结束，不与补执行同时执行
+	l := catchUps.get(c.GetID())
+	l.RLock()
+	defer l.RUnlock()
+
+	c.runTrigger(&Trigger{
+		Type:     TriggerCron,
+		RuleID:   c.JobRule.ID,
+		FireTime: t,
+	})
+}
+
+func (c *Cmd) runTrigger(tr *Trigger) {
+	// 只记录定时器的触发时间，用于补执行
+	if tr.Type == TriggerCron || tr.Type == TriggerCatchUp {
+		fireStates.set(c.GetID(), tr.FireTime)
+	}
+	if !c.Job.checkActiveTime(time.Now()) {
+		return
+	}
+	if msg := c.JobRule.suppressed(tr.FireTime); len(msg) > 0 {
+		c.Job.Skip(tr, fmt.Sprintf("job[%s] rule[%s] fire at %s suppressed by calendar: %s",
+			c.Job.Key(), c.JobRule.ID, tr.FireTime.Format(time.RFC3339), msg))
+		return
+	}
+	if !c.Job.checkWindow(tr) {
+		return
+	}
+	if c.Job.Kind == KindAny || c.Job.Kind == KindShard {
+		c.dispatch(tr)
+		return
+	}
+	if !c.beginOverlap(tr) {
+		return
+	}
+	for tr != nil {
+		c.run(tr)
+		tr = c.endOverlap(tr)
+	}
+}
+
+func (c *Cmd) run(tr *Trigger) {
 	// 同时执行任务数限制
-	if c.Job.limit() {
+	if c.Job.limit(tr) {
 		return
 	}
 	defer c.Job.unlimit()
 
-	if c.Job.Kind != KindCommon {
-		lk := c.lock()
+	if c.Job.Kind == KindAlone || c.Job.Kind == KindInterval {
+		lk := c.lock(tr)
 		if lk == nil {
 			return
 		}
 		defer lk.unlock()
 	}
 
-	if c.Job.Retry <= 0 {
-		c.Job.Run()
+	// 集群并发数限制
+	sem, ok := c.Job.acquireSemaphore(tr)
+	if !ok {
 		return
 	}
+	defer sem.release()
 
-	for i := 0; i <= c.Job.Retry; i++ {
-		if c.Job.Run() {
-			return
+	// 最大执行次数
+	if !c.Job.takeRun() {
+		return
+	}
+
+	c.Job.RunWithRetry(tr)
+}
+
+// RunWithRetry 执行任务，失败时按重试策略重试
+// 每次执行都单独记录日志，只有最后一次失败才发送通知
+func (j *Job) RunWithRetry(tr *Trigger) bool {
+	for tr.Attempt = 1; ; tr.Attempt++ {
+		if tr.isCanceled() {
+			j.Skip(tr, fmt.Sprintf("job[%s] running on[%s] %s", j.Key(), j.runOn, tr.cancelReason()))
+			return false
+		}
+		if !j.checkWindow(tr) {
+			return false
 		}
 
-		if c.Job.Interval > 0 {
-			time.Sleep(time.Duration(c.Job.Interval) * time.Second)
+		r := j.exec(tr)
+		retry := !r.Success && !tr.isCanceled() && tr.Attempt <= j.Retry && j.RetryPolicy.retryable(r)
+		j.finish(tr, r, !retry)
+		if !retry {
+			return r.Success
 		}
+
+		tr.sleep(j.RetryPolicy.delay(j.Interval, tr.Attempt))
 	}
 }
 
-func (j *Job) limit() bool {
+func (j *Job) limit(tr *Trigger) bool {
 	if j.Parallels == 0 {
 		return false
 	}
@@ -175,7 +348,7 @@ func (j *Job) limit() bool {
 	count := atomic.AddInt64(j.Count, 1)
 	if j.Parallels < count {
 		atomic.AddInt64(j.Count, -1)
-		j.Fail(time.Now(), fmt.Sprintf("job[%s] running on[%s] running:[%d]", j.Key(), j.runOn, count))
+		j.Skip(tr, fmt.Sprintf("job[%s] running on[%s] running:[%d]", j.Key(), j.runOn, count))
 		return true
 	}
 
@@ -195,6 +368,11 @@ func (j *Job) Init(nodeID, hostname, ip string) {
 }
 
 func (c *Cmd) lockTtl() int64 {
+	// 只有文件触发或上游依赖的规则没有执行间隔，按超时时间加锁
+	if c.JobRule.Schedule == nil {
+		return c.timeoutLockTtl()
+	}
+
 	now := time.Now()
 	prev := c.JobRule.Schedule.Next(now)
 	ttl := int64(c.JobRule.Schedule.Next(prev).Sub(prev) / time.Second)
@@ -210,41 +388,60 @@ func (c *Cmd) lockTtl() int64 {
 		if ttl < 1 {
 			ttl = 1
 		}
-		return ttl
-	}
+	} else {
+		cost := c.Job.AvgTime / 1e3
+		if c.Job.AvgTime/1e3-cost*1e3 > 0 {
+			cost += 1
+		}
+		// 如果执行间隔时间不大于执行时间，把过期时间设置为执行时间的下限-1
+		// 以便下次执行的时候，能获取到 lock
+		if ttl >= cost {
+			ttl -= cost
+		}
 
-	cost := c.Job.AvgTime / 1e3
-	if c.Job.AvgTime/1e3-cost*1e3 > 0 {
-		cost += 1
-	}
-	// 如果执行间隔时间不大于执行时间，把过期时间设置为执行时间的下限-1
-	// 以便下次执行的时候，能获取到 lock
-	if ttl >= cost {
-		ttl -= cost
+		if ttl > conf.Config.LockTtl {
+			ttl = conf.Config.LockTtl
+		}
+
+		// 支持的最小时间间隔 2s
+		if ttl < 2 {
+			ttl = 2
+		}
 	}
 
-	if ttl > conf.Config.LockTtl {
-		ttl = conf.Config.LockTtl
+	// 其它结点在 jitter 窗口内还会尝试获取锁，过期时间不能小于窗口
+	// 窗口不超过执行间隔的一半，不会影响下次执行获取锁
+	if w := int64((c.jitterWindow()+time.Second-1)/time.Second) + 1; w > 1 && ttl < w {
+		ttl = w
 	}
 
-	// 支持的最小时间间隔 2s
+	return ttl
+}
+
+// 没有定时器的规则的锁的过期时间，单机任务执行期间会续期
+// 设置了超时时间时为超时时间多 2s，不超过 conf.Config.LockTtl
+func (c *Cmd) timeoutLockTtl() int64 {
+	ttl := conf.Config.LockTtl
+	if c.Job.Timeout > 0 && c.Job.Timeout+2 < ttl {
+		ttl = c.Job.Timeout + 2
+	}
 	if ttl < 2 {
 		ttl = 2
 	}
-
 	return ttl
 }
 
-func (c *Cmd) newLock() *locker {
+func (c *Cmd) newLock(tr *Trigger) *locker {
 	return &locker{
-		kind: c.Job.Kind,
-		ttl:  c.lockTtl(),
-		done: make(chan struct{}),
+		kind:  c.Job.Kind,
+		ttl:   c.lockTtl(),
+		until: tr.FireTime.Add(c.jitterWindow()),
+		done:  make(chan struct{}),
 	}
 }
 
-func (c *Cmd) lock() *locker {
-	lk := c.newLock()
+func (c *Cmd) lock(tr *Trigger) *locker {
+	lk := c.newLock(tr)
 	// 非法的 rule
 	if lk.ttl == 0 {
 		return nil
@@ -274,24 +471,29 @@ func (c *Cmd) lock() *locker {
 }
 
 // 优先取结点里的值，更新 group 时可用 gid 判断是否对 job 进行处理
-func (rule *JobRule) included(nid string, gs map[string]*Group) bool {
+// 结点的标签用于匹配规则的标签选择器，主机名、IP 和版本用于匹配动态分组
+func (rule *JobRule) included(n *entries.Node, gs map[string]*Group) bool {
 	for i, count := 0, len(rule.NodeIDs); i < count; i++ {
-		if nid == rule.NodeIDs[i] {
+		if n.ID == rule.NodeIDs[i] {
 			return true
 		}
 	}
 
 	for _, gid := range rule.GroupIDs {
-		if g, ok := gs[gid]; ok && g.Included(nid) {
+		if g, ok := gs[gid]; ok && g.Included(n) {
 			return true
 		}
 	}
 
-	return false
+	return rule.matchLabels(n.Labels)
 }
 
-// 验证 timer 字段
+// 验证 timer 字段，并解析标签选择器
 func (rule *JobRule) Valid() error {
+	if err := rule.parseSelector(); err != nil {
+		return err
+	}
+
 	// 注意 interface nil 的比较
 	if rule.Schedule != nil {
 		return nil
@@ -301,15 +503,58 @@ func (rule *JobRule) Valid() error {
 		return ErrNilRule
 	}
 
-	sch, err := cron.Parse(rule.Timer)
+	sch, err := cron.Parse(rule.spec())
 	if err != nil {
-		return fmt.Errorf("invalid JobRule[%s], parse err: %s", rule.Timer, err.Error())
+		return fmt.Errorf("invalid JobRule[%s], parse err: %w", rule.Timer, err)
 	}
 
 	rule.Schedule = sch
 	return nil
 }
 
+// 加上时区的定时器，Timer 中已指定时区时以 Timer 为准
+func (rule *JobRule) spec() string {
+	tz := strings.TrimSpace(rule.Timezone)
+	timer := strings.TrimSpace(rule.Timer)
+	if len(tz) == 0 || strings.HasPrefix(timer, "CRON_TZ=") || strings.HasPrefix(timer, "TZ=") {
+		return timer
+	}
+	return "CRON_TZ=" + tz + " " + timer
+}
+
+// 定时器使用的时区
+func (rule *JobRule) Location() *time.Location {
+	if sch, ok := rule.Schedule.(*cron.SpecSchedule); ok && sch.Location != nil {
+		return sch.Location
+	}
+	return time.Local
+}
+
+// 从 t 开始的 n 个执行时间，使用规则的时区
+func (rule *JobRule) NextRunTimes(t time.Time, n int) ([]time.Time, error) {
+	if err := rule.Valid(); err != nil {
+		return nil, err
+	}
+	return cron.NextN(rule.Schedule, t.In(rule.Location()), n), nil
+}
+
+// 定时器的预览，保存规则前查看实际的执行时间
+type TimerPreview struct {
+	Description string      // 定时器的英文描述，如 at 02:30:00 every Monday
+	Next        []time.Time // 接下来的执行时间
+}
+
+func (rule *JobRule) Preview(t time.Time, n int) (*TimerPreview, error) {
+	next, err := rule.NextRunTimes(t, n)
+	if err != nil {
+		return nil, err
+	}
+	return &TimerPreview{
+		Description: cron.Describe(rule.Schedule),
+		Next:        next,
+	}, nil
+}
+
 // Note: this function did't check the job.
 func GetJob(group, id string) (job *Job, err error) {
 	job, _, err = GetJobAndRev(group, id)
@@ -333,6 +578,7 @@ func GetJobAndRev(group, id string) (job *Job, rev int64, err error) {
 	}
 
 	job.splitCmd()
+	job.ParseSelectors()
 	return
 }
 
@@ -419,21 +665,53 @@ func (j *Job) GetNextRunTime() time.Time {
 	if len(j.Rules) < 1 {
 		return nextTime
 	}
-	for i, r := range j.Rules {
-		sch, err := cron.Parse(r.Timer)
+	now := time.Now()
+	if j.StartAt != nil && now.Before(*j.StartAt) {
+		now = j.StartAt.Add(-time.Nanosecond)
+	}
+	for _, r := range j.Rules {
+		if len(r.Timer) == 0 {
+			continue
+		}
+		next, err := r.NextRunTimes(now, 1)
 		if err != nil {
 			return nextTime
 		}
-		t := sch.Next(time.Now())
-		if i == 0 || t.UnixNano() < nextTime.UnixNano() {
+		if len(next) == 0 || (j.EndAt != nil && next[0].After(*j.EndAt)) {
+			continue
+		}
+		// 使用规则的时区显示
+		if t := next[0]; nextTime.IsZero() || t.UnixNano() < nextTime.UnixNano() {
 			nextTime = t
 		}
 	}
 	return nextTime
 }
 
-// Run 执行任务
-func (j *Job) Run() bool {
+// Run 执行任务并记录结果
+func (j *Job) Run(tr *Trigger) (success bool) {
+	tr.Attempt = 1
+	if (tr.Type == TriggerOnce || tr.Type == TriggerWebhook) && len(tr.ExecutionID) > 0 {
+		j.markExecution(tr, ExecutionRunning, false)
+		defer func() { j.markExecution(tr, ExecutionDone, success) }()
+	}
+	if !j.checkWindow(tr) {
+		return false
+	}
+
+	sem, ok := j.acquireSemaphore(tr)
+	if !ok {
+		return false
+	}
+	defer sem.release()
+
+	r := j.exec(tr)
+	j.finish(tr, r, true)
+	return r.Success
+}
+
+// 执行任务，返回执行结果，不记录日志
+func (j *Job) exec(tr *Trigger) *ExecResult {
 	var (
 		cmd         *exec.Cmd
 		proc        *Process
@@ -441,30 +719,46 @@ func (j *Job) Run() bool {
 		err         error
 	)
 
+	if j.Type == JobTypeHTTP {
+		return j.execHTTP(tr)
+	}
+
 	t := time.Now()
 
 	sysProcAttr, err = j.CreateCmdAttr()
 	if err != nil {
-		j.Fail(t, err.Error())
-		return false
+		return &ExecResult{BeginTime: t, Output: err.Error(), StartError: true, ExitCode: -1}
 	}
 
-	// 超时控制
-	if j.Timeout > 0 {
-		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(j.Timeout)*time.Second)
-		defer cancel()
-		cmd = exec.CommandContext(ctx, j.cmd[0], j.cmd[1:]...)
-	} else {
-		cmd = exec.Command(j.cmd[0], j.cmd[1:]...)
+	args := j.cmd
+	if j.Type == JobTypeScript {
+		var file string
+		args, file, err = j.Script.materialize(j, sysProcAttr)
+		if err != nil {
+			return &ExecResult{BeginTime: t, Output: err.Error(), StartError: true, ExitCode: -1}
+		}
+		defer func() {
+			if err := os.Remove(file); err != nil {
+				log.Warnf("job[%s] remove script file[%s] err: %s", j.Key(), file, err.Error())
+			}
+		}()
 	}
+	args = tr.command(args)
 
+	cmd = exec.Command(args[0], args[1:]...)
 	cmd.SysProcAttr = sysProcAttr
-	var b bytes.Buffer
-	cmd.Stdout = &b
-	cmd.Stderr = &b
+	cmd.Env = j.environ(tr)
+	cmd.Dir = j.WorkDir
+	if len(tr.Payload) > 0 {
+		cmd.Stdin = strings.NewReader(tr.Payload)
+	}
+	stdout, stderr := j.newOutputBuffer(t, "stdout"), j.newOutputBuffer(t, "stderr")
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
 	if err := cmd.Start(); err != nil {
-		j.Fail(t, fmt.Sprintf("%s\n%s", b.String(), err.Error()))
-		return false
+		r := newExecResult(t, stdout, stderr, nil, err)
+		r.StartError = true
+		return r
 	}
 
 	proc = &Process{
@@ -479,16 +773,68 @@ func (j *Job) Run() bool {
 	proc.Start()
 	defer proc.Stop()
 
-	if err := cmd.Wait(); err != nil {
-		j.Fail(t, fmt.Sprintf("%s\n%s", b.String(), err.Error()))
-		return false
+	// 启动前已被新的执行替换
+	if !tr.started(cmd.Process.Pid) {
+		go j.Kill(cmd.Process.Pid)
+	}
+	defer tr.exited()
+
+	// 超时控制，超时后结束整个进程组
+	var timedOut int32
+	if j.Timeout > 0 {
+		timer := time.AfterFunc(time.Duration(j.Timeout)*time.Second, func() {
+			atomic.StoreInt32(&timedOut, 1)
+			if err := j.Kill(cmd.Process.Pid); err != nil {
+				log.Warnf("job[%s] kill timeout process[%d] err: %s", j.Key(), cmd.Process.Pid, err.Error())
+			}
+		})
+		defer timer.Stop()
+	}
+
+	// 执行时间段结束时结束整个进程组
+	var windowClosed int32
+	end, _ := j.windowEnd(t)
+	if !end.IsZero() {
+		timer := time.AfterFunc(time.Until(end), func() {
+			atomic.StoreInt32(&windowClosed, 1)
+			if err := j.Kill(cmd.Process.Pid); err != nil {
+				log.Warnf("job[%s] kill process[%d] at the end of execution window err: %s", j.Key(), cmd.Process.Pid, err.Error())
+			}
+		})
+		defer timer.Stop()
 	}
 
-	j.Success(t, b.String())
-	return true
+	err = cmd.Wait()
+	if atomic.LoadInt32(&timedOut) == 1 {
+		if err == nil {
+			err = fmt.Errorf("timeout after %ds", j.Timeout)
+		} else {
+			err = fmt.Errorf("timeout after %ds: %s", j.Timeout, err.Error())
+		}
+		r := newExecResult(t, stdout, stderr, cmd.ProcessState, err)
+		r.TimedOut = true
+		return r
+	}
+
+	if atomic.LoadInt32(&windowClosed) == 1 {
+		msg := "execution window closed at " + end.Format(time.RFC3339)
+		if err == nil {
+			err = fmt.Errorf("%s", msg)
+		} else {
+			err = fmt.Errorf("%s: %s", msg, err.Error())
+		}
+		r := newExecResult(t, stdout, stderr, cmd.ProcessState, err)
+		r.WindowClosed = true
+		return r
+	}
+
+	if err != nil && tr.isCanceled() {
+		err = fmt.Errorf("%s: %s", tr.cancelReason(), err.Error())
+	}
+	return newExecResult(t, stdout, stderr, cmd.ProcessState, err)
 }
 
-func (j *Job) RunWithRecovery() {
+func (j *Job) RunWithRecovery(tr *Trigger) {
 	defer func() {
 		if r := recover(); r != nil {
 			const size = 64 << 10
@@ -497,7 +843,7 @@ func (j *Job) RunWithRecovery() {
 			log.Warnf("panic running job: %v\n%s", r, buf)
 		}
 	}()
-	j.Run()
+	j.Run(tr)
 }
 
 // 从 etcd 的 key 中取 id
@@ -544,43 +890,145 @@ func (j *Job) Check() error {
 
 	j.User = strings.TrimSpace(j.User)
 
+	for _, e := range j.Env {
+		if err := e.check(); err != nil {
+			return err
+		}
+	}
+
+	j.WorkDir = strings.TrimSpace(j.WorkDir)
+	if len(j.WorkDir) > 0 && !path.IsAbs(j.WorkDir) {
+		return ErrIllegalJobWorkDir
+	}
+
+	if err := j.checkKill(); err != nil {
+		return err
+	}
+
+	if j.Retry < 0 {
+		j.Retry = 0
+	}
+	if err := j.RetryPolicy.check(); err != nil {
+		return err
+	}
+
+	if err := j.checkConcurrency(); err != nil {
+		return err
+	}
+
+	if err := j.checkOverlap(); err != nil {
+		return err
+	}
+
+	if err := j.checkMisfire(); err != nil {
+		return err
+	}
+
+	if err := j.checkActive(); err != nil {
+		return err
+	}
+
+	if err := j.checkStrategy(); err != nil {
+		return err
+	}
+	j.checkShards()
+
 	for i := range j.Rules {
+		j.Rules[i].Timezone = strings.TrimSpace(j.Rules[i].Timezone)
+		if err := j.Rules[i].checkJitter(); err != nil {
+			return err
+		}
+		j.Rules[i].IncludeCalendars = trimIDs(j.Rules[i].IncludeCalendars)
+		j.Rules[i].ExcludeCalendars = trimIDs(j.Rules[i].ExcludeCalendars)
+		if err := j.Rules[i].checkSelector(); err != nil {
+			return err
+		}
+		if err := j.Rules[i].Watch.check(); err != nil {
+			return err
+		}
 		id := strings.TrimSpace(j.Rules[i].ID)
 		if id == "" || strings.HasPrefix(id, "NEW") {
 			j.Rules[i].ID = NextID()
 		}
 	}
 
-	// 不修改 Command 的内容，简单判断是否为空
-	if len(strings.TrimSpace(j.Command)) == 0 {
-		return ErrEmptyJobCommand
+	for _, d := range j.Depends {
+		if err := d.check(); err != nil {
+			return err
+		}
+	}
+
+	if err := j.checkType(); err != nil {
+		return err
 	}
 
 	return j.Valid()
 }
 
 // 执行结果写入 mongoDB
-func (j *Job) Success(t time.Time, out string) {
-	CreateJobLog(j, t, out, true)
+func (j *Job) Success(tr *Trigger, t time.Time, out string) {
+	j.finish(tr, &ExecResult{BeginTime: t, Output: out, Success: true}, true)
+}
+
+func (j *Job) Fail(tr *Trigger, t time.Time, msg string) {
+	j.finish(tr, &ExecResult{BeginTime: t, Output: msg, ExitCode: -1}, true)
+}
+
+// Skip 记录跳过的执行，不计入失败，也不发送通知
+// 跳过的分片视为失败
+func (j *Job) Skip(tr *Trigger, msg string) {
+	log.Infof("%s", msg)
+	r := &ExecResult{BeginTime: time.Now(), Output: msg, ExitCode: -1, Skipped: true}
+	CreateJobLog(j, tr, r)
+	if tr.ShardTotal > 0 {
+		j.complete(tr, r)
+	}
 }
 
-func (j *Job) Fail(t time.Time, msg string) {
-	j.Notify(t, msg)
-	CreateJobLog(j, t, msg, false)
+// final 为 false 时表示任务还会重试，只记录日志
+func (j *Job) finish(tr *Trigger, r *ExecResult, final bool) {
+	CreateJobLog(j, tr, r)
+	if final {
+		j.complete(tr, r)
+	}
+}
+
+// 执行结束，失败时发送通知，并触发下游任务
+// 分片执行在所有分片都结束后才算结束
+func (j *Job) complete(tr *Trigger, r *ExecResult) {
+	if tr.ShardTotal > 0 {
+		var done bool
+		if r, done = j.finishShard(tr, r); !done {
+			return
+		}
+	}
+
+	if !r.Success {
+		j.Notify(tr, r)
+	}
+	j.runDepends(tr, r.Success)
 }
 
-func (j *Job) Notify(t time.Time, msg string) {
+func (j *Job) Notify(tr *Trigger, r *ExecResult) {
 	if !conf.Config.Mail.Enable || !j.FailNotify {
 		return
 	}
 
-	ts := t.Format(time.RFC3339)
+	ts := r.BeginTime.Format(time.RFC3339)
+	trigger := tr.AttemptType()
+	if len(tr.RuleID) > 0 {
+		trigger += "[" + tr.RuleID + "]"
+	}
 	body := "Job: " + j.Key() + "\n" +
 		"Job name: " + j.Name + "\n" +
 		"Job cmd: " + j.Command + "\n" +
 		"Node: " + j.hostname + "|" + j.ip + "[" + j.runOn + "]\n" +
 		"Time: " + ts + "\n" +
-		"Error: " + msg
+		"Trigger: " + trigger + "\n"
+	if status := r.Status(); len(status) > 0 {
+		body += "Status: " + status + "\n"
+	}
+	body += "Error: " + r.Message()
 
 	m := Message{
 		Subject: "[Cronsun] node[" + j.hostname + "|" + j.ip + "] job[" + j.ShortName() + "] time[" + ts + "] exec failed",
@@ -611,7 +1059,7 @@ func (j *Job) Avg(t, et time.Time) {
 	j.AvgTime = (j.AvgTime + execTime) / 2
 }
 
-func (j *Job) Cmds(nid string, gs map[string]*Group) (cmds map[string]*Cmd) {
+func (j *Job) Cmds(n *entries.Node, gs map[string]*Group) (cmds map[string]*Cmd) {
 	cmds = make(map[string]*Cmd)
 	if j.Pause {
 		return
@@ -620,7 +1068,7 @@ func (j *Job) Cmds(nid string, gs map[string]*Group) (cmds map[string]*Cmd) {
 LOOP_TIMER_CMD:
 	for _, r := range j.Rules {
 		for _, id := range r.ExcludeNodeIDs {
-			if nid == id {
+			if n.ID == id {
 				// 在当前定时器规则中，任务不会在该节点执行（节点被排除）
 				// 但是任务可以在其它定时器中，在该节点被执行
 				// 比如，一个定时器设置在凌晨 1 点执行，但是此时不想在这个节点执行，然后，
@@ -630,7 +1078,13 @@ LOOP_TIMER_CMD:
 			}
 		}
 
-		if r.included(nid, gs) {
+		// 没有 timer 和文件触发的规则只用于选择执行结点
+		// 有上游依赖时用于执行上游任务触发的执行
+		if r.Schedule == nil && r.Watch == nil && len(j.Depends) == 0 {
+			continue
+		}
+
+		if r.included(n, gs) {
 			cmd := &Cmd{
 				Job:     j,
 				JobRule: r,
@@ -642,16 +1096,16 @@ LOOP_TIMER_CMD:
 	return
 }
 
-func (j Job) IsRunOn(nid string, gs map[string]*Group) bool {
+func (j Job) IsRunOn(n *entries.Node, gs map[string]*Group) bool {
 LOOP_TIMER:
 	for _, r := range j.Rules {
 		for _, id := range r.ExcludeNodeIDs {
-			if nid == id {
+			if n.ID == id {
 				continue LOOP_TIMER
 			}
 		}
 
-		if r.included(nid, gs) {
+		if r.included(n, gs) {
 			return true
 		}
 	}
@@ -669,15 +1123,33 @@ func (j *Job) Valid() error {
 		return err
 	}
 
+	if err := j.ValidWindows(); err != nil {
+		return err
+	}
+
 	security := conf.Config.Security
 	if !security.Open {
 		return nil
 	}
 
+	if j.Type == JobTypeHTTP {
+		if !j.HTTP.validHost() {
+			return ErrSecurityInvalidHost
+		}
+		return nil
+	}
+
 	if !j.validUser() {
 		return ErrSecurityInvalidUser
 	}
 
+	if j.Type == JobTypeScript {
+		if !j.Script.validInterpreter() {
+			return ErrSecurityInvalidInterpreter
+		}
+		return nil
+	}
+
 	if !j.validCmd() {
 		return ErrSecurityInvalidCmd
 	}
@@ -712,6 +1184,13 @@ func (j *Job) validCmd() bool {
 
 func (j *Job) ValidRules() error {
 	for _, r := range j.Rules {
+		if len(r.Timer) == 0 && (len(j.Depends) > 0 || r.Watch != nil) {
+			if err := r.parseSelector(); err != nil {
+				return err
+			}
+			continue
+		}
+
 		if err := r.Valid(); err != nil {
 			return err
 		}
@@ -762,7 +1241,8 @@ func (j *Job) CreateCmdAttr() (*syscall.SysProcAttr, error) {
 	return sysProcAttr, nil
 }
 
-func CreateJobLog(j *Job, t time.Time, rs string, success bool) {
+func CreateJobLog(j *Job, tr *Trigger, r *ExecResult) {
+	t := r.BeginTime
 	et := time.Now()
 	j.Avg(t, et)
 
@@ -776,9 +1256,44 @@ func CreateJobLog(j *Job, t time.Time, rs string, success bool) {
 		Hostname: j.hostname,
 		IP:       j.ip,
 
-		Command: j.Command,
-		Output:  rs,
-		Success: success,
+		Command: j.command(tr),
+		Output:  r.Output,
+		Stderr:  r.Stderr,
+		Success: r.Success,
+		Skipped: r.Skipped,
+		Attempt: tr.Attempt,
+
+		TriggerType: tr.AttemptType(),
+		RuleID:      tr.RuleID,
+		FireTime:    tr.FireTime,
+		ExitCode:    r.ExitCode,
+		Signal:      r.Signal,
+		UserTime:    r.UserTime.Milliseconds(),
+		SysTime:     r.SysTime.Milliseconds(),
+		MaxRSS:      r.MaxRSS,
+
+		OutputSize: r.OutputSize,
+		StderrSize: r.StderrSize,
+		OutputFile: r.OutputFile,
+		StderrFile: r.StderrFile,
+		Truncated:  r.Truncated,
+		TimedOut:   r.TimedOut,
+
+		WindowClosed: r.WindowClosed,
+
+		DispatchedBy:   tr.DispatchedBy,
+		DispatchReason: tr.DispatchReason,
+		ExecutionID:    tr.ExecutionID,
+		ShardIndex:     tr.ShardIndex,
+		ShardTotal:     tr.ShardTotal,
+
+		TriggerSource: tr.Source,
+		CallerIP:      tr.CallerIP,
+		File:          tr.File,
+
+		HTTPStatus:  r.HTTPStatus,
+		HTTPHeaders: r.HTTPHeaders,
+		HTTPTiming:  r.HTTPTiming,
 
 		BeginTime: t,
 		EndTime:   et,
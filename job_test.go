@@ -0,0 +1,156 @@
+package cronsun
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"cronsun/conf"
+	"cronsun/node/cron"
+)
+
+func TestJobRuleTimezone(t *testing.T) {
+	tests := []struct {
+		timer, tz string
+		spec      string
+		location  string
+	}{
+		{"0 0 9 * * *", "", "0 0 9 * * *", "Local"},
+		{"0 0 9 * * *", "Asia/Tokyo", "CRON_TZ=Asia/Tokyo 0 0 9 * * *", "Asia/Tokyo"},
+		{"CRON_TZ=UTC 0 0 9 * * *", "Asia/Tokyo", "CRON_TZ=UTC 0 0 9 * * *", "UTC"},
+	}
+
+	for _, test := range tests {
+		rule := &JobRule{Timer: test.timer, Timezone: test.tz}
+		if spec := rule.spec(); spec != test.spec {
+			t.Errorf("%q %q: expected spec %q, got %q", test.timer, test.tz, test.spec, spec)
+		}
+		if err := rule.Valid(); err != nil {
+			t.Fatal(err)
+		}
+		if loc := rule.Location().String(); loc != test.location {
+			t.Errorf("%q %q: expected location %s, got %s", test.timer, test.tz, test.location, loc)
+		}
+	}
+
+	rule := &JobRule{Timer: "0 0 9 * * *", Timezone: "Mars/Olympus"}
+	if err := rule.Valid(); err == nil {
+		t.Error("expected invalid time zone error")
+	}
+
+	j := &Job{Rules: []*JobRule{{Timer: "0 0 9 * * *", Timezone: "Asia/Tokyo"}}}
+	next := j.GetNextRunTime()
+	if next.Location().String() != "Asia/Tokyo" || next.Hour() != 9 {
+		t.Errorf("expected next run time at 09:00 Asia/Tokyo, got %s", next)
+	}
+	if !next.After(time.Now()) {
+		t.Errorf("expected next run time after now, got %s", next)
+	}
+}
+
+func TestJobRulePreview(t *testing.T) {
+	rule := &JobRule{Timer: "0 30 2 * * MON", Timezone: "Asia/Tokyo"}
+	p, err := rule.Preview(time.Now(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Description != "at 02:30:00 every Monday (Asia/Tokyo)" {
+		t.Errorf("unexpected description %q", p.Description)
+	}
+	if len(p.Next) != 3 {
+		t.Fatalf("expected 3 run times, got %v", p.Next)
+	}
+	for i, next := range p.Next {
+		if next.Location().String() != "Asia/Tokyo" || next.Weekday() != time.Monday || next.Hour() != 2 || next.Minute() != 30 {
+			t.Errorf("unexpected run time %s", next)
+		}
+		if i > 0 && next.Sub(p.Next[i-1]) != 7*24*time.Hour {
+			t.Errorf("expected weekly run times, got %s after %s", next, p.Next[i-1])
+		}
+	}
+
+	rule = &JobRule{Timer: "0 61 * * * *"}
+	var fe *cron.FieldError
+	if _, err = rule.Preview(time.Now(), 3); !errors.As(err, &fe) || fe.Field != "minute" {
+		t.Errorf("expected minute field error, got %v", err)
+	}
+}
+
+func TestJobEnvCheck(t *testing.T) {
+	tests := []struct {
+		env *JobEnv
+		err error
+	}{
+		{&JobEnv{Key: "PATH", Value: "/bin"}, nil},
+		{&JobEnv{Key: " _a1 "}, nil},
+		{&JobEnv{Key: "CRONSUN"}, nil},
+		{&JobEnv{Key: ""}, ErrIllegalJobEnv},
+		{&JobEnv{Key: "1A"}, ErrIllegalJobEnv},
+		{&JobEnv{Key: "A-B"}, ErrIllegalJobEnv},
+		{&JobEnv{Key: "A=B"}, ErrIllegalJobEnv},
+		{&JobEnv{Key: "CRONSUN_JOB_ID"}, ErrIllegalJobEnv},
+		{&JobEnv{Key: "cronsun_x"}, ErrIllegalJobEnv},
+		{nil, ErrIllegalJobEnv},
+	}
+
+	for i, test := range tests {
+		if err := test.env.check(); err != test.err {
+			t.Errorf("#%d: expected %v, got %v", i, test.err, err)
+		}
+	}
+}
+
+func TestJobCheckEnv(t *testing.T) {
+	security := conf.Config.Security
+	defer func() { conf.Config.Security = security }()
+	conf.Config.Security = &conf.Security{}
+
+	tests := []struct {
+		body string
+		err  error
+	}{
+		{`{"env":[{"key":" A ","value":"1"}],"work_dir":" /tmp "}`, nil},
+		{`{"env":[null]}`, ErrIllegalJobEnv},
+		{`{"env":[{"key":"CRONSUN_FILE"}]}`, ErrIllegalJobEnv},
+		{`{"work_dir":"tmp"}`, ErrIllegalJobWorkDir},
+		{`{"depends":[null]}`, ErrIllegalDependJob},
+	}
+
+	for i, test := range tests {
+		j := &Job{}
+		if err := json.Unmarshal([]byte(test.body), j); err != nil {
+			t.Fatal(err)
+		}
+		j.ID, j.Name, j.Command = "j1", "job", "echo"
+		if err := j.Check(); err != test.err {
+			t.Errorf("#%d: expected %v, got %v", i, test.err, err)
+			continue
+		}
+		if test.err == nil && (j.Env[0].Key != "A" || j.WorkDir != "/tmp") {
+			t.Errorf("#%d: expected trimmed env key and work dir, got %q %q", i, j.Env[0].Key, j.WorkDir)
+		}
+	}
+}
+
+func TestJobEnviron(t *testing.T) {
+	j := &Job{ID: "j1", Env: []*JobEnv{{Key: "A", Value: "1"}, {Key: "B", Value: "1"}}}
+	env := j.environ(&Trigger{RuleID: "r1", Env: []*JobEnv{{Key: "A", Value: "2"}}})
+
+	index := func(kv string) int {
+		for i := len(env) - 1; i >= 0; i-- {
+			if env[i] == kv {
+				return i
+			}
+		}
+		return -1
+	}
+	// 同名的环境变量以后面的为准：进程环境变量、任务的、触发的，最后是内置的
+	job, tr, builtin := index("A=1"), index("A=2"), index(EnvJobID+"=j1")
+	if job < 0 || index("B=1") < 0 || !(job < tr && tr < builtin) {
+		t.Errorf("unexpected environ order: job %d, trigger %d, builtin %d", job, tr, builtin)
+	}
+	if index(EnvRuleID+"=r1") < builtin {
+		t.Errorf("expected %s after job env", EnvRuleID)
+	}
+}
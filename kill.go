@@ -0,0 +1,122 @@
+package cronsun
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// 结束任务时，发送信号后等待进程退出的默认时间，单位秒
+const DefaultKillGrace = 10
+
+var killSignals = map[string]syscall.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGKILL": syscall.SIGKILL,
+	"SIGUSR1": syscall.SIGUSR1,
+	"SIGUSR2": syscall.SIGUSR2,
+	"SIGTERM": syscall.SIGTERM,
+}
+
+// 进程被信号结束时，日志中记录的信号名称
+var signalNames = map[syscall.Signal]string{
+	syscall.SIGHUP:  "SIGHUP",
+	syscall.SIGINT:  "SIGINT",
+	syscall.SIGQUIT: "SIGQUIT",
+	syscall.SIGILL:  "SIGILL",
+	syscall.SIGTRAP: "SIGTRAP",
+	syscall.SIGABRT: "SIGABRT",
+	syscall.SIGBUS:  "SIGBUS",
+	syscall.SIGFPE:  "SIGFPE",
+	syscall.SIGKILL: "SIGKILL",
+	syscall.SIGUSR1: "SIGUSR1",
+	syscall.SIGSEGV: "SIGSEGV",
+	syscall.SIGUSR2: "SIGUSR2",
+	syscall.SIGPIPE: "SIGPIPE",
+	syscall.SIGALRM: "SIGALRM",
+	syscall.SIGTERM: "SIGTERM",
+	syscall.SIGXCPU: "SIGXCPU",
+	syscall.SIGXFSZ: "SIGXFSZ",
+}
+
+func signalName(sig syscall.Signal) string {
+	if name, ok := signalNames[sig]; ok {
+		return name
+	}
+	return fmt.Sprintf("SIG%d", int(sig))
+}
+
+func parseKillSignal(name string) (syscall.Signal, bool) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if len(name) == 0 {
+		return syscall.SIGTERM, true
+	}
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+
+	sig, ok := killSignals[name]
+	return sig, ok
+}
+
+func (j *Job) checkKill() error {
+	j.KillSignal = strings.ToUpper(strings.TrimSpace(j.KillSignal))
+	if _, ok := parseKillSignal(j.KillSignal); !ok {
+		return ErrIllegalKillSignal
+	}
+
+	if j.KillGrace < 0 {
+		j.KillGrace = 0
+	}
+	return nil
+}
+
+func (j *Job) killSignal() syscall.Signal {
+	sig, ok := parseKillSignal(j.KillSignal)
+	if !ok {
+		return syscall.SIGTERM
+	}
+	return sig
+}
+
+func (j *Job) killGrace() time.Duration {
+	if j.KillGrace <= 0 {
+		return DefaultKillGrace * time.Second
+	}
+	return time.Duration(j.KillGrace) * time.Second
+}
+
+// Kill 结束任务的进程组
+// 先发送任务设置的信号，等待 KillGrace 后进程组仍未退出，发送 SIGKILL
+func (j *Job) Kill(pid int) error {
+	return KillProcessGroup(pid, j.killSignal(), j.killGrace())
+}
+
+// KillProcessGroup 向进程组发送 sig，grace 时间后仍未退出则发送 SIGKILL
+// 任务以 Setpgid 启动，进程组 id 与任务进程 pid 相同
+func KillProcessGroup(pgid int, sig syscall.Signal, grace time.Duration) error {
+	if err := syscall.Kill(-pgid, sig); err != nil {
+		if err == syscall.ESRCH {
+			return nil
+		}
+		return err
+	}
+	if sig == syscall.SIGKILL {
+		return nil
+	}
+
+	const interval = 100 * time.Millisecond
+	for deadline := time.Now().Add(grace); time.Now().Before(deadline); {
+		time.Sleep(interval)
+		if syscall.Kill(-pgid, 0) == syscall.ESRCH {
+			return nil
+		}
+	}
+
+	if err := syscall.Kill(-pgid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package cronsun
+
+import (
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func startProcessGroup(t *testing.T, script string) *exec.Cmd {
+	cmd := exec.Command("sh", "-c", script)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go cmd.Wait()
+	// 等待 sh 启动子进程
+	time.Sleep(100 * time.Millisecond)
+	return cmd
+}
+
+func TestKillProcessGroup(t *testing.T) {
+	cmd := startProcessGroup(t, "sleep 30 & sleep 30")
+	pid := cmd.Process.Pid
+
+	begin := time.Now()
+	if err := KillProcessGroup(pid, syscall.SIGTERM, 3*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if cost := time.Since(begin); cost >= 3*time.Second {
+		t.Errorf("expected process group exit on SIGTERM, cost %s", cost)
+	}
+	if err := syscall.Kill(-pid, 0); err != syscall.ESRCH {
+		t.Errorf("expected process group %d not exist, got %v", pid, err)
+	}
+}
+
+func TestKillProcessGroupAfterGrace(t *testing.T) {
+	cmd := startProcessGroup(t, `trap "" TERM; sleep 30 & sleep 30`)
+	pid := cmd.Process.Pid
+
+	begin := time.Now()
+	if err := KillProcessGroup(pid, syscall.SIGTERM, 500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if cost := time.Since(begin); cost < 500*time.Millisecond {
+		t.Errorf("expected SIGKILL after grace period, cost %s", cost)
+	}
+
+	var err error
+	for i := 0; i < 20; i++ {
+		time.Sleep(100 * time.Millisecond)
+		if err = syscall.Kill(-pid, 0); err == syscall.ESRCH {
+			return
+		}
+	}
+	t.Errorf("expected process group %d not exist, got %v", pid, err)
+}
+
+func TestParseKillSignal(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected syscall.Signal
+		ok       bool
+	}{
+		{"", syscall.SIGTERM, true},
+		{"term", syscall.SIGTERM, true},
+		{"SIGINT", syscall.SIGINT, true},
+		{" sigusr1 ", syscall.SIGUSR1, true},
+		{"SIGSTOP", 0, false},
+	}
+
+	for _, test := range tests {
+		sig, ok := parseKillSignal(test.name)
+		if ok != test.ok || ok && sig != test.expected {
+			t.Errorf("%q: expected %v %v, got %v %v", test.name, test.expected, test.ok, sig, ok)
+		}
+	}
+}
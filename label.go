@@ -0,0 +1,232 @@
+package cronsun
+
+import (
+	"fmt"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"cronsun/log"
+)
+
+// 结点自动设置的标签，配置中的同名标签会被覆盖
+const (
+	LabelOS       = "os"
+	LabelArch     = "arch"
+	LabelHostname = "hostname"
+	LabelIP       = "ip"
+)
+
+var labelPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]*[A-Za-z0-9])?$`)
+
+func validLabel(s string) bool {
+	return len(s) <= 253 && labelPattern.MatchString(s)
+}
+
+// NodeLabels 结点的标签，包括配置的标签和自动检测的 os、arch、hostname、ip
+func NodeLabels(conf map[string]string, hostname, ip string) map[string]string {
+	labels := make(map[string]string, len(conf)+4)
+	for k, v := range conf {
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		if !validLabel(k) || !validLabel(v) {
+			log.Warnf("invalid node label %s=%s, ignored", k, v)
+			continue
+		}
+		labels[k] = v
+	}
+
+	labels[LabelOS], labels[LabelArch] = runtime.GOOS, runtime.GOARCH
+	labels[LabelHostname], labels[LabelIP] = hostname, ip
+	return labels
+}
+
+// EqualLabels 两组标签是否相同
+func EqualLabels(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// 标签选择器中的操作
+const (
+	selectorEquals    = "="
+	selectorNotEquals = "!="
+	selectorIn        = "in"
+	selectorNotIn     = "notin"
+	selectorExists    = "exists"
+	selectorNotExists = "!"
+)
+
+type labelRequirement struct {
+	key    string
+	op     string
+	values []string
+}
+
+func (r *labelRequirement) matches(labels map[string]string) bool {
+	v, ok := labels[r.key]
+	switch r.op {
+	case selectorExists:
+		return ok
+	case selectorNotExists:
+		return !ok
+	case selectorEquals, selectorIn:
+		return ok && containsString(r.values, v)
+	case selectorNotEquals, selectorNotIn:
+		return !ok || !containsString(r.values, v)
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// LabelSelector 标签选择器，所有条件都满足时匹配
+// 条件之间用逗号分隔，支持以下形式：
+// key=value, key==value, key!=value
+// key in (v1, v2), key notin (v1, v2)
+// key: 存在标签 key
+// !key: 不存在标签 key
+type LabelSelector []*labelRequirement
+
+var setRequirement = regexp.MustCompile(`^(\S+)\s+(in|notin)\s*\(([^()]*)\)$`)
+
+// ParseLabelSelector 解析标签选择器，空字符串返回空的选择器
+func ParseLabelSelector(s string) (LabelSelector, error) {
+	var sel LabelSelector
+	for _, part := range splitSelector(s) {
+		part = strings.TrimSpace(part)
+		if len(part) == 0 {
+			continue
+		}
+
+		r, err := parseRequirement(part)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrIllegalLabelSelector, err.Error())
+		}
+		sel = append(sel, r)
+	}
+	return sel, nil
+}
+
+// 按括号外的逗号分隔
+func splitSelector(s string) []string {
+	var (
+		parts []string
+		depth int
+		begin int
+	)
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, s[begin:i])
+				begin = i + 1
+			}
+		}
+	}
+	return append(parts, s[begin:])
+}
+
+func parseRequirement(s string) (*labelRequirement, error) {
+	r := &labelRequirement{}
+	switch {
+	case setRequirement.MatchString(s):
+		m := setRequirement.FindStringSubmatch(s)
+		r.key, r.op = m[1], m[2]
+		for _, v := range strings.Split(m[3], ",") {
+			if v = strings.TrimSpace(v); len(v) > 0 {
+				r.values = append(r.values, v)
+			}
+		}
+		if len(r.values) == 0 {
+			return nil, fmt.Errorf("empty set in %q", s)
+		}
+	case strings.HasPrefix(s, "!") && !strings.Contains(s, "="):
+		r.key, r.op = strings.TrimSpace(s[1:]), selectorNotExists
+	case strings.Contains(s, "!="):
+		i := strings.Index(s, "!=")
+		r.key, r.op, r.values = s[:i], selectorNotEquals, []string{s[i+2:]}
+	case strings.Contains(s, "=="):
+		i := strings.Index(s, "==")
+		r.key, r.op, r.values = s[:i], selectorEquals, []string{s[i+2:]}
+	case strings.Contains(s, "="):
+		i := strings.Index(s, "=")
+		r.key, r.op, r.values = s[:i], selectorEquals, []string{s[i+1:]}
+	default:
+		r.key, r.op = s, selectorExists
+	}
+
+	r.key = strings.TrimSpace(r.key)
+	if !validLabel(r.key) {
+		return nil, fmt.Errorf("invalid key in %q", s)
+	}
+	for i := range r.values {
+		r.values[i] = strings.TrimSpace(r.values[i])
+		if !validLabel(r.values[i]) {
+			return nil, fmt.Errorf("invalid value in %q", s)
+		}
+	}
+	return r, nil
+}
+
+// Matches 标签是否满足选择器的所有条件，空的选择器匹配所有标签
+func (sel LabelSelector) Matches(labels map[string]string) bool {
+	for _, r := range sel {
+		if !r.matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// 检查并解析规则的标签选择器
+func (rule *JobRule) checkSelector() (err error) {
+	rule.Selector = strings.TrimSpace(rule.Selector)
+	rule.selector, err = ParseLabelSelector(rule.Selector)
+	if err == nil {
+		rule.parsedSelector = rule.Selector
+	}
+	return
+}
+
+// 解析还没有解析的选择器
+func (rule *JobRule) parseSelector() error {
+	if rule.parsedSelector == rule.Selector {
+		return nil
+	}
+	return rule.checkSelector()
+}
+
+// ParseSelectors 解析任务所有规则的标签选择器
+// 不经过 Valid 读取的任务在匹配结点前需要先解析，无效的选择器不匹配任何结点
+func (j *Job) ParseSelectors() {
+	for _, r := range j.Rules {
+		r.parseSelector()
+	}
+}
+
+// 结点标签是否满足规则的选择器，没有设置选择器时不匹配任何结点
+// 选择器在 Valid 中解析，这里只读，可以在执行时并发调用
+func (rule *JobRule) matchLabels(labels map[string]string) bool {
+	if rule.parsedSelector != rule.Selector {
+		return false
+	}
+	return len(rule.selector) > 0 && rule.selector.Matches(labels)
+}
@@ -0,0 +1,153 @@
+package cronsun
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+
+	"cronsun/db/entries"
+)
+
+func TestParseLabelSelector(t *testing.T) {
+	labels := map[string]string{"env": "prod", "zone": "a", "ssd": "true"}
+	tests := []struct {
+		selector string
+		matches  bool
+		err      bool
+	}{
+		{"", true, false},
+		{"env=prod", true, false},
+		{"env==prod", true, false},
+		{"env = test", false, false},
+		{"env!=test", true, false},
+		{"env!=prod", false, false},
+		{"gpu!=true", true, false},
+		{"zone in (a, b)", true, false},
+		{"zone in (b,c)", false, false},
+		{"zone notin (b, c)", true, false},
+		{"gpu notin (a)", true, false},
+		{"ssd", true, false},
+		{"gpu", false, false},
+		{"!gpu", true, false},
+		{"!ssd", false, false},
+		{"env=prod, zone in (a,b), !gpu", true, false},
+		{"env=prod, zone in (b), !gpu", false, false},
+		{"zone in ()", false, true},
+		{"env=", false, true},
+		{"=prod", false, true},
+		{"!gpu=true", false, true},
+		{"env in (a b)", false, true},
+	}
+
+	for _, test := range tests {
+		sel, err := ParseLabelSelector(test.selector)
+		if test.err {
+			if !errors.Is(err, ErrIllegalLabelSelector) {
+				t.Errorf("%q => expected %v, got %v", test.selector, ErrIllegalLabelSelector, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q => unexpected error %v", test.selector, err)
+			continue
+		}
+		if m := sel.Matches(labels); m != test.matches {
+			t.Errorf("%q => expected matches %v, got %v", test.selector, test.matches, m)
+		}
+	}
+}
+
+func TestNodeLabels(t *testing.T) {
+	labels := NodeLabels(map[string]string{" env ": "prod", "os": "plan9", "bad key": "x"}, "host1", "10.0.0.1")
+	expected := map[string]string{
+		"env":         "prod",
+		LabelOS:       runtime.GOOS,
+		LabelArch:     runtime.GOARCH,
+		LabelHostname: "host1",
+		LabelIP:       "10.0.0.1",
+	}
+	if !EqualLabels(labels, expected) {
+		t.Errorf("expected %v, got %v", expected, labels)
+	}
+}
+
+func TestJobIsRunOnSelector(t *testing.T) {
+	j := &Job{Rules: []*JobRule{
+		{Timer: "0 * * * * *", Selector: " env=prod, !gpu ", ExcludeNodeIDs: []string{"n3"}},
+	}}
+
+	// 选择器在 Valid 中解析，匹配时不会修改规则
+	if j.IsRunOn(&entries.Node{ID: "n1", Labels: map[string]string{"env": "prod"}}, nil) {
+		t.Errorf("expected unparsed selector to match no node")
+	}
+	if err := j.ValidRules(); err != nil {
+		t.Fatal(err)
+	}
+	if j.Rules[0].Selector != "env=prod, !gpu" {
+		t.Errorf("expected trimmed selector, got %q", j.Rules[0].Selector)
+	}
+	tests := []struct {
+		nid      string
+		labels   map[string]string
+		expected bool
+	}{
+		{"n1", map[string]string{"env": "prod"}, true},
+		{"n2", map[string]string{"env": "prod", "gpu": "1"}, false},
+		{"n3", map[string]string{"env": "prod"}, false},
+		{"n4", nil, false},
+	}
+
+	for _, test := range tests {
+		if on := j.IsRunOn(&entries.Node{ID: test.nid, Labels: test.labels}, nil); on != test.expected {
+			t.Errorf("%s %v => expected %v, got %v", test.nid, test.labels, test.expected, on)
+		}
+	}
+
+	// 修改选择器后重新验证才生效
+	j.Rules[0].Selector = "gpu"
+	if j.IsRunOn(&entries.Node{ID: "n2", Labels: map[string]string{"gpu": "1"}}, nil) {
+		t.Errorf("expected changed selector to match no node before validation")
+	}
+	if err := j.ValidRules(); err != nil {
+		t.Fatal(err)
+	}
+	if !j.IsRunOn(&entries.Node{ID: "n2", Labels: map[string]string{"gpu": "1"}}, nil) {
+		t.Errorf("expected run on node with gpu label after selector changed")
+	}
+
+	// 没有选择器时只按结点 id 选择
+	j.Rules[0].Selector = ""
+	j.Rules[0].NodeIDs = []string{"n1"}
+	if err := j.ValidRules(); err != nil {
+		t.Fatal(err)
+	}
+	if !j.IsRunOn(&entries.Node{ID: "n1"}, nil) || j.IsRunOn(&entries.Node{ID: "n2", Labels: map[string]string{"env": "prod"}}, nil) {
+		t.Errorf("expected run on n1 only")
+	}
+}
+
+func TestRuleValidSelector(t *testing.T) {
+	tests := []struct {
+		rule *JobRule
+		err  bool
+	}{
+		{&JobRule{Timer: "0 * * * * *", Selector: "zone in (a)"}, false},
+		{&JobRule{Timer: "0 * * * * *", Selector: "zone in ()"}, true},
+		{&JobRule{Selector: "env="}, true},
+	}
+
+	for i, test := range tests {
+		if err := test.rule.Valid(); (err != nil) != test.err {
+			t.Errorf("#%d: expected error %v, got %v", i, test.err, err)
+		}
+	}
+
+	// 没有 timer 的文件触发规则也解析选择器
+	j := &Job{Rules: []*JobRule{{Watch: &FileWatch{Dir: "/data/in"}, Selector: "zone=a"}}}
+	if err := j.ValidRules(); err != nil {
+		t.Fatal(err)
+	}
+	if !j.IsRunOn(&entries.Node{ID: "n1", Labels: map[string]string{"zone": "a"}}, nil) {
+		t.Errorf("expected watch rule to match node by selector")
+	}
+}
@@ -0,0 +1,240 @@
+package cronsun
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"cronsun/db/entries"
+	"cronsun/log"
+)
+
+// 错过执行时间后的处理方式
+const (
+	MisfireIgnore = "ignore" // 忽略错过的执行，默认
+	MisfireOnce   = "once"   // 马上补执行一次
+	MisfireAll    = "all"    // 补执行每一次错过的执行，最多 MisfireLimit 次
+)
+
+// MisfireAll 默认最多补执行的次数
+const DefaultMisfireLimit = 10
+
+func (j *Job) checkMisfire() error {
+	j.MisfirePolicy = strings.ToLower(strings.TrimSpace(j.MisfirePolicy))
+	switch j.MisfirePolicy {
+	case "":
+		j.MisfirePolicy = MisfireIgnore
+	case MisfireIgnore, MisfireOnce, MisfireAll:
+	default:
+		return ErrIllegalMisfirePolicy
+	}
+
+	if j.MisfireLimit < 0 {
+		j.MisfireLimit = 0
+	}
+	return nil
+}
+
+func (j *Job) misfireLimit() int {
+	switch j.MisfirePolicy {
+	case MisfireOnce:
+		return 1
+	case MisfireAll:
+		if j.MisfireLimit > 0 {
+			return j.MisfireLimit
+		}
+		return DefaultMisfireLimit
+	}
+	return 0
+}
+
+// prev 之后到 now 之间错过的执行时间，按 MisfirePolicy 只保留最近的几次
+func (c *Cmd) missed(prev, now time.Time) []time.Time {
+	limit := c.Job.misfireLimit()
+	if limit == 0 || c.JobRule.Schedule == nil {
+		return nil
+	}
+
+	var times []time.Time
+	sch := c.ActiveSchedule()
+	for t := sch.Next(prev); !t.IsZero() && !t.After(now); t = sch.Next(t) {
+		if len(times) == limit {
+			times = append(times[:0], times[1:]...)
+		}
+		times = append(times, t)
+	}
+	return times
+}
+
+// Misfire 定时器没能按时触发时代替本次执行，first 为第一个错过的执行时间
+// ignore 时只执行 first 一次，否则按 MisfirePolicy 补执行 first 到 now 之间错过的执行
+func (c *Cmd) Misfire(first, now time.Time) {
+	if c.Job.misfireLimit() == 0 {
+		c.RunAt(first)
+		return
+	}
+	c.catchUp(first.Add(-time.Nanosecond), now)
+}
+
+// 补执行 prev 之后到 now 之间错过的执行
+// 补执行依次进行，在 job log 中记为 catchup，补执行期间规则的定时触发等待补执行结束
+func (c *Cmd) catchUp(prev, now time.Time) {
+	times := c.missed(prev, now)
+	if len(times) == 0 {
+		return
+	}
+
+	l := catchUps.get(c.GetID())
+	l.Lock()
+	defer l.Unlock()
+
+	log.Infof("job[%s] rule[%s] missed runs after %s, catch up %d run(s)",
+		c.Job.Key(), c.JobRule.ID, prev.Format(time.RFC3339), len(times))
+	for _, t := range times {
+		c.runTrigger(&Trigger{
+			Type:     TriggerCatchUp,
+			RuleID:   c.JobRule.ID,
+			FireTime: t,
+		})
+	}
+}
+
+// CatchUp 补执行结点停止期间错过的执行
+// 上次触发时间优先使用结点记录的状态文件，没有记录时使用规则最后一条定时触发的 job log
+func (c *Cmd) CatchUp(now time.Time) {
+	// 只有文件触发的规则
+	if c.JobRule.Schedule == nil || c.Job.misfireLimit() == 0 {
+		return
+	}
+
+	prev, ok := fireStates.get(c.GetID())
+	if !ok {
+		l, err := entries.GetRuleLatestLog(c.Job.runOn, c.Job.Group, c.Job.ID, c.JobRule.ID, []string{TriggerCron, TriggerCatchUp})
+		if err != nil || l == nil {
+			return
+		}
+		prev = l.FireTime
+	}
+
+	c.catchUp(prev, now)
+}
+
+// 每个规则补执行时的锁，定时触发使用读锁，等待补执行结束后再执行
+type catchUpLocks struct {
+	sync.Mutex
+	locks map[string]*sync.RWMutex
+}
+
+var catchUps = &catchUpLocks{locks: make(map[string]*sync.RWMutex)}
+
+func (l *catchUpLocks) get(id string) *sync.RWMutex {
+	l.Lock()
+	defer l.Unlock()
+
+	rw, ok := l.locks[id]
+	if !ok {
+		rw = &sync.RWMutex{}
+		l.locks[id] = rw
+	}
+	return rw
+}
+
+func (l *catchUpLocks) del(id string) {
+	l.Lock()
+	delete(l.locks, id)
+	l.Unlock()
+}
+
+// 结点记录的每个规则最后一次触发时间
+type fireState struct {
+	sync.Mutex
+	path  string
+	times map[string]time.Time
+	dirty bool
+}
+
+var fireStates = &fireState{times: make(map[string]time.Time)}
+
+// LoadFireState 读取结点记录的触发时间，path 为空时不记录
+func LoadFireState(path string) error {
+	fireStates.Lock()
+	defer fireStates.Unlock()
+
+	fireStates.path = path
+	if len(path) == 0 {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(data, &fireStates.times)
+}
+
+// SaveFireState 把有变化的触发时间写入文件
+func SaveFireState() error {
+	fireStates.Lock()
+	defer fireStates.Unlock()
+
+	if len(fireStates.path) == 0 || !fireStates.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(fireStates.times)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(fireStates.path), 0755); err != nil {
+		return err
+	}
+
+	// 先写临时文件再改名，避免写入中途退出损坏文件
+	tmp := fireStates.path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, fireStates.path); err != nil {
+		return err
+	}
+
+	fireStates.dirty = false
+	return nil
+}
+
+func (s *fireState) get(id string) (time.Time, bool) {
+	s.Lock()
+	t, ok := s.times[id]
+	s.Unlock()
+	return t, ok
+}
+
+func (s *fireState) set(id string, t time.Time) {
+	s.Lock()
+	if len(s.path) > 0 && t.After(s.times[id]) {
+		s.times[id] = t
+		s.dirty = true
+	}
+	s.Unlock()
+}
+
+func (s *fireState) del(id string) {
+	s.Lock()
+	if _, ok := s.times[id]; ok {
+		delete(s.times, id)
+		s.dirty = true
+	}
+	s.Unlock()
+}
+
+// DelFireState 规则不在结点执行后，删除记录的触发时间和补执行的锁
+func DelFireState(cmd *Cmd) {
+	fireStates.del(cmd.GetID())
+	catchUps.del(cmd.GetID())
+}
@@ -0,0 +1,116 @@
+package cronsun
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cronsun/node/cron"
+)
+
+func TestCmdMissed(t *testing.T) {
+	sch, err := cron.Parse("0 */10 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prev := time.Date(2018, 1, 1, 10, 0, 0, 0, time.Local)
+	now := time.Date(2018, 1, 1, 11, 5, 0, 0, time.Local)
+	tests := []struct {
+		policy   string
+		limit    int
+		expected []time.Time
+	}{
+		{MisfireIgnore, 0, nil},
+		{MisfireOnce, 0, []time.Time{prev.Add(time.Hour)}},
+		{MisfireAll, 2, []time.Time{prev.Add(50 * time.Minute), prev.Add(time.Hour)}},
+		{MisfireAll, 0, []time.Time{
+			prev.Add(10 * time.Minute), prev.Add(20 * time.Minute), prev.Add(30 * time.Minute),
+			prev.Add(40 * time.Minute), prev.Add(50 * time.Minute), prev.Add(time.Hour),
+		}},
+	}
+
+	for _, test := range tests {
+		c := &Cmd{
+			Job:     &Job{MisfirePolicy: test.policy, MisfireLimit: test.limit},
+			JobRule: &JobRule{Schedule: sch},
+		}
+		times := c.missed(prev, now)
+		if len(times) != len(test.expected) {
+			t.Errorf("%s[%d]: expected %v, got %v", test.policy, test.limit, test.expected, times)
+			continue
+		}
+		for i := range times {
+			if !times[i].Equal(test.expected[i]) {
+				t.Errorf("%s[%d]: expected %v, got %v", test.policy, test.limit, test.expected, times)
+				break
+			}
+		}
+	}
+}
+
+func TestFireState(t *testing.T) {
+	dir, err := os.MkdirTemp("", "cronsun")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer LoadFireState("")
+
+	path := filepath.Join(dir, "state", "fire_state.json")
+	if err = LoadFireState(path); err != nil {
+		t.Fatal(err)
+	}
+
+	fired := time.Date(2018, 1, 1, 10, 0, 0, 0, time.UTC)
+	fireStates.set("job1rule1", fired)
+	fireStates.set("job1rule1", fired.Add(-time.Minute))
+	if err = SaveFireState(); err != nil {
+		t.Fatal(err)
+	}
+
+	fireStates.times = make(map[string]time.Time)
+	if err = LoadFireState(path); err != nil {
+		t.Fatal(err)
+	}
+	if last, ok := fireStates.get("job1rule1"); !ok || !last.Equal(fired) {
+		t.Errorf("expected last fire time %s, got %s %v", fired, last, ok)
+	}
+}
+
+func TestCatchUpLock(t *testing.T) {
+	l := catchUps.get("j1r1")
+	if catchUps.get("j1r1") != l || catchUps.get("j1r2") == l {
+		t.Fatal("expected one catch-up lock per rule")
+	}
+	defer DelFireState(&Cmd{Job: &Job{ID: "j1"}, JobRule: &JobRule{ID: "r2"}})
+
+	// 补执行期间定时触发等待
+	l.Lock()
+	fired := make(chan struct{})
+	go func() {
+		rl := catchUps.get("j1r1")
+		rl.RLock()
+		close(fired)
+		rl.RUnlock()
+	}()
+
+	select {
+	case <-fired:
+		t.Fatal("expected the regular fire to wait for the catch-up")
+	case <-time.After(50 * time.Millisecond):
+	}
+	l.Unlock()
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Fatal("expected the regular fire to run after the catch-up")
+	}
+
+	DelFireState(&Cmd{Job: &Job{ID: "j1"}, JobRule: &JobRule{ID: "r1"}})
+	if catchUps.get("j1r1") == l {
+		t.Error("expected the catch-up lock removed with the rule")
+	}
+	catchUps.del("j1r1")
+}
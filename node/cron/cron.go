@@ -32,6 +32,22 @@ type Job interface {
 	Run()
 }
 
+// TimedJob is an optional interface for jobs that need to know the
+// activation time they were fired for. Cron calls RunAt instead of Run
+// when a job implements it.
+type TimedJob interface {
+	RunAt(t time.Time)
+}
+
+// MisfireJob is an optional interface for jobs that want to handle the
+// activations missed while the scheduler could not run on time, e.g. the
+// host slept or the clock jumped forward. Misfire is called instead of
+// running the job, with the first missed activation and the current time;
+// the job decides which of the activations between them to run.
+type MisfireJob interface {
+	Misfire(prev, now time.Time)
+}
+
 // The Schedule describes a job's duty cycle.
 type Schedule interface {
 	// Return the next activation time, later than the given time.
@@ -186,7 +202,7 @@ func (c *Cron) Start() {
 	go c.run()
 }
 
-func (c *Cron) runWithRecovery(j Job) {
+func (c *Cron) runWithRecovery(j Job, t time.Time) {
 	defer func() {
 		if r := recover(); r != nil {
 			const size = 64 << 10
@@ -195,9 +211,25 @@ func (c *Cron) runWithRecovery(j Job) {
 			c.logf("cron: panic running job: %v\n%s", r, buf)
 		}
 	}()
+	if tj, ok := j.(TimedJob); ok {
+		tj.RunAt(t)
+		return
+	}
 	j.Run()
 }
 
+func (c *Cron) misfireWithRecovery(j MisfireJob, prev, now time.Time) {
+	defer func() {
+		if r := recover(); r != nil {
+			const size = 64 << 10
+			buf := make([]byte, size)
+			buf = buf[:runtime.Stack(buf, false)]
+			c.logf("cron: panic handling misfire: %v\n%s", r, buf)
+		}
+	}()
+	j.Misfire(prev, now)
+}
+
 // Rebuild the indexes
 func (c *Cron) reIndex() {
 	for i, count := 0, len(c.entries); i < count; i++ {
@@ -238,7 +270,11 @@ func (c *Cron) run() {
 				if e.Next != effective {
 					break
 				}
-				go c.runWithRecovery(e.Job)
+				if mj, ok := e.Job.(MisfireJob); ok && !e.Schedule.Next(e.Next).After(now) {
+					go c.misfireWithRecovery(mj, e.Next, now)
+				} else {
+					go c.runWithRecovery(e.Job, e.Next)
+				}
 				e.Prev = e.Next
 				e.Next = e.Schedule.Next(now)
 			}
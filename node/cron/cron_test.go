@@ -328,6 +328,41 @@ func TestJob(t *testing.T) {
 	}
 }
 
+type timedJob struct {
+	fired chan time.Time
+}
+
+func (t timedJob) GetID() string {
+	return "timed"
+}
+
+func (t timedJob) Run() {
+	panic("Run should not be called on a TimedJob")
+}
+
+func (t timedJob) RunAt(at time.Time) {
+	t.fired <- at
+}
+
+// TimedJob should receive the activation time it was fired for.
+func TestTimedJob(t *testing.T) {
+	job := timedJob{make(chan time.Time, 1)}
+
+	cron := New()
+	cron.AddJob("* * * * * ?", job)
+	cron.Start()
+	defer cron.Stop()
+
+	select {
+	case <-time.After(ONE_SECOND):
+		t.FailNow()
+	case at := <-job.fired:
+		if at.Nanosecond() != 0 {
+			t.Errorf("expected activation time on the second, got %s", at)
+		}
+	}
+}
+
 func wait(wg *sync.WaitGroup) chan bool {
 	ch := make(chan bool)
 	go func() {
@@ -345,3 +380,69 @@ func stop(cron *Cron) chan bool {
 	}()
 	return ch
 }
+
+// 第一次执行时间之后的下一次执行时间已经过去，之后不再执行
+type misfireSchedule struct {
+	first time.Time
+}
+
+func (s misfireSchedule) Next(t time.Time) time.Time {
+	switch {
+	case t.Before(s.first):
+		return s.first
+	case t.Equal(s.first):
+		return s.first.Add(time.Microsecond)
+	}
+	return time.Time{}
+}
+
+type misfireJob struct {
+	fired    chan time.Time
+	misfired chan time.Time
+}
+
+func (j misfireJob) GetID() string {
+	return "misfire"
+}
+
+func (j misfireJob) Run() {
+	panic("Run should not be called on a TimedJob")
+}
+
+func (j misfireJob) RunAt(at time.Time) {
+	j.fired <- at
+}
+
+func (j misfireJob) Misfire(first, now time.Time) {
+	j.misfired <- first
+}
+
+// A MisfireJob should get a single Misfire call instead of the late run.
+func TestMisfireJob(t *testing.T) {
+	job := misfireJob{make(chan time.Time, 1), make(chan time.Time, 1)}
+	first := time.Now().Add(50 * time.Millisecond)
+
+	cron := New()
+	cron.Schedule(misfireSchedule{first}, job)
+	cron.Start()
+	defer cron.Stop()
+
+	select {
+	case <-time.After(ONE_SECOND):
+		t.Fatal("expected a misfire")
+	case at := <-job.misfired:
+		if !at.Equal(first) {
+			t.Errorf("expected misfire from %s, got %s", first, at)
+		}
+	case at := <-job.fired:
+		t.Fatalf("unexpected late run at %s", at)
+	}
+
+	select {
+	case at := <-job.fired:
+		t.Errorf("unexpected late run at %s", at)
+	case <-job.misfired:
+		t.Error("unexpected second misfire")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
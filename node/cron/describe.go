@@ -0,0 +1,252 @@
+package cron
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	monthNames = []string{"", "January", "February", "March", "April", "May", "June",
+		"July", "August", "September", "October", "November", "December"}
+	weekdayNames = []string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"}
+	ordinals     = []string{"", "first", "second", "third", "fourth", "fifth"}
+)
+
+// NextN returns the next n activation times of the schedule after the given
+// time. Fewer times are returned if the schedule is not activated any more.
+func NextN(schedule Schedule, t time.Time, n int) []time.Time {
+	times := make([]time.Time, 0, n)
+	for len(times) < n {
+		if t = schedule.Next(t); t.IsZero() {
+			break
+		}
+		times = append(times, t)
+	}
+	return times
+}
+
+// Describe returns an English description of when the schedule is activated,
+// e.g. "at 02:30:00 every Monday".
+func Describe(schedule Schedule) string {
+	switch s := schedule.(type) {
+	case *SpecSchedule:
+		return s.describe()
+	case ConstantDelaySchedule:
+		return "every " + s.Delay.String()
+	case *TimeListSchedule:
+		if len(s.timeList) == 0 {
+			return "never"
+		}
+		ts := make([]string, len(s.timeList))
+		for i, t := range s.timeList {
+			ts[i] = t.Format("2006-01-02 15:04:05")
+		}
+		return "at " + joinWords(ts, "and")
+	}
+	return ""
+}
+
+func (s *SpecSchedule) describe() string {
+	parts := []string{s.describeTime()}
+
+	day := s.describeDay()
+	if len(day) > 0 {
+		parts = append(parts, day)
+	} else if everyStep(s.Hour, hours) == 0 {
+		parts = append(parts, "every day")
+	}
+
+	if everyStep(s.Month, months) != 1 {
+		names := make([]string, 0, 12)
+		for _, m := range fieldValues(s.Month, months) {
+			names = append(names, monthNames[m])
+		}
+		parts = append(parts, "in "+joinWords(names, "and"))
+	}
+
+	if len(s.Year) > 0 {
+		ys := make([]string, len(s.Year))
+		for i, y := range s.Year {
+			ys[i] = fmt.Sprint(y)
+		}
+		parts = append(parts, "in "+joinWords(ys, "and"))
+	}
+
+	if s.Location != nil {
+		parts = append(parts, "("+s.Location.String()+")")
+	}
+	return strings.Join(parts, " ")
+}
+
+// describeTime describes the second, minute and hour fields.
+func (s *SpecSchedule) describeTime() string {
+	secs, mins, hrs := fieldValues(s.Second, seconds), fieldValues(s.Minute, minutes), fieldValues(s.Hour, hours)
+	if len(secs) == 1 && len(mins) == 1 {
+		switch step := everyStep(s.Hour, hours); {
+		case step == 1:
+			return fmt.Sprintf("at %02d:%02d past every hour", mins[0], secs[0])
+		case step > 1:
+			return fmt.Sprintf("at %02d:%02d past every %d hours", mins[0], secs[0], step)
+		}
+
+		ts := make([]string, len(hrs))
+		for i, h := range hrs {
+			ts[i] = fmt.Sprintf("%02d:%02d:%02d", h, mins[0], secs[0])
+		}
+		return "at " + joinWords(ts, "and")
+	}
+
+	fields := []struct {
+		bits uint64
+		r    bounds
+		unit string
+	}{
+		{s.Second, seconds, "second"},
+		{s.Minute, minutes, "minute"},
+		{s.Hour, hours, "hour"},
+	}
+
+	var (
+		parts []string
+		every bool // a smaller unit is activated every time, so are the larger ones
+	)
+	for _, f := range fields {
+		switch step := everyStep(f.bits, f.r); {
+		case step == 1:
+			if !every {
+				parts = append(parts, "every "+f.unit)
+			}
+			every = true
+		case step > 1:
+			parts = append(parts, fmt.Sprintf("every %d %ss", step, f.unit))
+			every = true
+		default:
+			vals := fieldValues(f.bits, f.r)
+			unit := f.unit
+			if len(vals) > 1 {
+				unit += "s"
+			}
+			parts = append(parts, "at "+unit+" "+joinWords(uintWords(vals), "and"))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// describeDay describes the day-of-month and day-of-week fields, or returns
+// an empty string if the schedule is activated every day.
+func (s *SpecSchedule) describeDay() string {
+	var doms []string
+	if everyStep(s.Dom, dom) != 1 {
+		if days := fieldValues(s.Dom, dom); len(days) == 1 {
+			doms = append(doms, fmt.Sprintf("day %d", days[0]))
+		} else if len(days) > 1 {
+			doms = append(doms, "days "+joinWords(uintWords(days), "and"))
+		}
+	}
+	for n := uint(0); n <= dom.max-dom.min; n++ {
+		switch {
+		case 1<<n&s.DomLast == 0:
+		case n == 0:
+			doms = append(doms, "the last day")
+		case n == 1:
+			doms = append(doms, "1 day before the last day")
+		default:
+			doms = append(doms, fmt.Sprintf("%d days before the last day", n))
+		}
+	}
+	for n := dom.min; n <= dom.max; n++ {
+		if 1<<n&s.DomWeekday > 0 {
+			doms = append(doms, fmt.Sprintf("the weekday nearest day %d", n))
+		}
+	}
+	if s.DomLastWeekday {
+		doms = append(doms, "the last weekday")
+	}
+
+	var dows []string
+	if everyStep(s.Dow, dow) != 1 {
+		var names []string
+		for _, d := range fieldValues(s.Dow, dow) {
+			names = append(names, weekdayNames[d])
+		}
+		if len(names) > 0 {
+			dows = append(dows, "every "+joinWords(names, "and"))
+		}
+	}
+	var nths []string
+	for n := dow.min; n <= dow.max; n++ {
+		if 1<<n&s.DowLast > 0 {
+			nths = append(nths, "the last "+weekdayNames[n])
+		}
+	}
+	for k := 1; k < len(ordinals); k++ {
+		for n := dow.min; n <= dow.max; n++ {
+			if 1<<(uint(k)*7+n)&s.DowNth > 0 {
+				nths = append(nths, "the "+ordinals[k]+" "+weekdayNames[n])
+			}
+		}
+	}
+	if len(nths) > 0 {
+		dows = append(dows, "on "+joinWords(nths, "or")+" of the month")
+	}
+
+	var dayDesc string
+	if len(doms) > 0 {
+		dayDesc = "on " + joinWords(doms, "or") + " of the month"
+	}
+	switch {
+	case len(dows) == 0:
+		return dayDesc
+	case len(doms) == 0:
+		return joinWords(dows, "or")
+	case s.Dom&starBit > 0 || s.Dow&starBit > 0:
+		// Both fields must match, see dayMatches.
+		return dayDesc + " if it is " + strings.TrimPrefix(joinWords(dows, "or"), "every ")
+	}
+	return dayDesc + " or " + joinWords(dows, "or")
+}
+
+// fieldValues returns the values set in bits within the given bounds.
+func fieldValues(bits uint64, r bounds) []uint {
+	var vals []uint
+	for i := r.min; i <= r.max; i++ {
+		if 1<<i&bits > 0 {
+			vals = append(vals, i)
+		}
+	}
+	return vals
+}
+
+// everyStep returns the step if bits are every step-th value within the
+// given bounds starting from the minimum, such as "*" or "*/5", or 0.
+func everyStep(bits uint64, r bounds) uint {
+	vals := fieldValues(bits, r)
+	if len(vals) < 2 || vals[0] != r.min {
+		return 0
+	}
+	step := vals[1] - vals[0]
+	if bits&^starBit != getBits(r.min, r.max, step) {
+		return 0
+	}
+	return step
+}
+
+func uintWords(vals []uint) []string {
+	words := make([]string, len(vals))
+	for i, v := range vals {
+		words[i] = fmt.Sprint(v)
+	}
+	return words
+}
+
+// joinWords joins words as "a", "a and b" or "a, b and c".
+func joinWords(words []string, conj string) string {
+	switch len(words) {
+	case 0:
+		return ""
+	case 1:
+		return words[0]
+	}
+	return strings.Join(words[:len(words)-1], ", ") + " " + conj + " " + words[len(words)-1]
+}
@@ -0,0 +1,65 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDescribe(t *testing.T) {
+	tests := []struct {
+		spec     string
+		expected string
+	}{
+		{"0 30 2 * * MON", "at 02:30:00 every Monday"},
+		{"0 30 2 * * *", "at 02:30:00 every day"},
+		{"0 30 2,14 * * MON-FRI", "at 02:30:00 and 14:30:00 every Monday, Tuesday, Wednesday, Thursday and Friday"},
+		{"0 0 * * * *", "at 00:00 past every hour"},
+		{"0 15 */2 * * *", "at 15:00 past every 2 hours"},
+		{"* * * * * *", "every second"},
+		{"0 */5 * * * *", "at second 0, every 5 minutes"},
+		{"*/10 * 9-17 * * *", "every 10 seconds, at hours 9, 10, 11, 12, 13, 14, 15, 16 and 17 every day"},
+		{"0 0 0 1,15 * ?", "at 00:00:00 on days 1 and 15 of the month"},
+		{"0 0 0 1 Jan ?", "at 00:00:00 on day 1 of the month in January"},
+		{"0 0 0 1 * MON", "at 00:00:00 on day 1 of the month or every Monday"},
+		{"0 0 0 */2 * MON", "at 00:00:00 on days 1, 3, 5, 7, 9, 11, 13, 15, 17, 19, 21, 23, 25, 27, 29 and 31 of the month if it is Monday"},
+		{"0 0 0 L * ?", "at 00:00:00 on the last day of the month"},
+		{"0 0 0 L-3,LW * ?", "at 00:00:00 on 3 days before the last day or the last weekday of the month"},
+		{"0 0 0 15W * ?", "at 00:00:00 on the weekday nearest day 15 of the month"},
+		{"0 0 0 ? * 5L", "at 00:00:00 on the last Friday of the month"},
+		{"0 0 0 ? * TUE#2", "at 00:00:00 on the second Tuesday of the month"},
+		{"0 0 12 1 1 ? 2030", "at 12:00:00 on day 1 of the month in January in 2030"},
+		{"CRON_TZ=Asia/Tokyo 0 30 2 * * *", "at 02:30:00 every day (Asia/Tokyo)"},
+		{"@daily", "at 00:00:00 every day"},
+		{"@every 1h30m", "every 1h30m0s"},
+		{"@at 2030-01-01 10:00:00, 2030-01-02 10:00:00", "at 2030-01-01 10:00:00 and 2030-01-02 10:00:00"},
+	}
+
+	for _, test := range tests {
+		sched, err := Parse(test.spec)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if actual := Describe(sched); actual != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.spec, test.expected, actual)
+		}
+	}
+}
+
+func TestNextN(t *testing.T) {
+	sched, _ := Parse("0 0 0 L * ? 2012")
+	times := NextN(sched, getTime("Mon Jul 9 23:35 2012"), 10)
+	expected := []string{"Tue Jul 31 00:00 2012", "Fri Aug 31 00:00 2012", "Sun Sep 30 00:00 2012", "Wed Oct 31 00:00 2012", "Fri Nov 30 00:00 2012", "Mon Dec 31 00:00 2012"}
+	if len(times) != len(expected) {
+		t.Fatalf("expected %d times, got %v", len(expected), times)
+	}
+	for i := range times {
+		if !times[i].Equal(getTime(expected[i])) {
+			t.Errorf("expected %s, got %s", expected[i], times[i])
+		}
+	}
+
+	if times := NextN(Every(time.Minute), getTime("Mon Jul 9 23:35 2012"), 3); len(times) != 3 || !times[2].Equal(getTime("Mon Jul 9 23:38 2012")) {
+		t.Errorf("expected 3 times every minute, got %v", times)
+	}
+}
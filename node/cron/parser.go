@@ -3,6 +3,7 @@ package cron
 import (
 	"fmt"
 	"math"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -15,14 +16,16 @@ import (
 type ParseOption int
 
 const (
-	Second      ParseOption = 1 << iota // Seconds field, default 0
-	Minute                              // Minutes field, default 0
-	Hour                                // Hours field, default 0
-	Dom                                 // Day of month field, default *
-	Month                               // Month field, default *
-	Dow                                 // Day of week field, default *
-	DowOptional                         // Optional day of week field, default *
-	Descriptor                          // Allow descriptors such as @monthly, @weekly, etc.
+	Second       ParseOption = 1 << iota // Seconds field, default 0
+	Minute                               // Minutes field, default 0
+	Hour                                 // Hours field, default 0
+	Dom                                  // Day of month field, default *
+	Month                                // Month field, default *
+	Dow                                  // Day of week field, default *
+	DowOptional                          // Optional day of week field, default *
+	Descriptor                           // Allow descriptors such as @monthly, @weekly, etc.
+	Year                                 // Year field, default *
+	YearOptional                         // Optional year field, default *
 )
 
 var places = []ParseOption{
@@ -32,6 +35,18 @@ var places = []ParseOption{
 	Dom,
 	Month,
 	Dow,
+	Year,
+}
+
+// fieldNames are the names of the fields in places, used in errors.
+var fieldNames = []string{
+	"second",
+	"minute",
+	"hour",
+	"day of month",
+	"month",
+	"day of week",
+	"year",
 }
 
 var defaults = []string{
@@ -41,6 +56,7 @@ var defaults = []string{
 	"*",
 	"*",
 	"*",
+	"*",
 }
 
 // A custom Parser that can be configured.
@@ -49,6 +65,17 @@ type Parser struct {
 	optionals int
 }
 
+// FieldError is returned by Parse when a field of the spec is not valid.
+type FieldError struct {
+	Field string // name of the field, e.g. "minute" or "day of week"
+	Value string // the field as given in the spec
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s field %q: %s", e.Field, e.Value, e.Err)
+}
+
 // Creates a custom Parser with custom options.
 //
 //  // Standard parser without descriptors
@@ -69,15 +96,31 @@ func NewParser(options ParseOption) Parser {
 		options |= Dow
 		optionals++
 	}
+	if options&YearOptional > 0 {
+		options |= Year
+		optionals++
+	}
 	return Parser{options, optionals}
 }
 
 // Parse returns a new crontab schedule representing the given spec.
 // It returns a descriptive error if the spec is not valid.
 // It accepts crontab specs and features configured by NewParser.
+//
+// A spec may start with a time zone as "CRON_TZ=Asia/Shanghai " or
+// "TZ=Asia/Shanghai ", the schedule is then evaluated in that time zone.
 func (p Parser) Parse(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	loc, spec, err := parseLocation(spec)
+	if err != nil {
+		return nil, err
+	}
+	if len(spec) == 0 {
+		return nil, fmt.Errorf("Empty spec string")
+	}
+
 	if spec[0] == '@' && p.options&Descriptor > 0 {
-		return parseDescriptor(spec)
+		return parseDescriptor(spec, loc)
 	}
 
 	// Figure out how many fields we need
@@ -103,36 +146,60 @@ func (p Parser) Parse(spec string) (Schedule, error) {
 	// Fill in missing fields
 	fields = expandFields(fields, p.options)
 
-	var err error
-	field := func(field string, r bounds) uint64 {
+	fieldErr := func(i int, err error) error {
+		return &FieldError{Field: fieldNames[i], Value: fields[i], Err: err}
+	}
+	field := func(i int, r bounds) uint64 {
 		if err != nil {
 			return 0
 		}
 		var bits uint64
-		bits, err = getField(field, r)
+		if bits, err = getField(fields[i], r); err != nil {
+			err = fieldErr(i, err)
+		}
 		return bits
 	}
 
-	var (
-		second     = field(fields[0], seconds)
-		minute     = field(fields[1], minutes)
-		hour       = field(fields[2], hours)
-		dayofmonth = field(fields[3], dom)
-		month      = field(fields[4], months)
-		dayofweek  = field(fields[5], dow)
-	)
+	s := &SpecSchedule{Location: loc}
+	s.Second = field(0, seconds)
+	s.Minute = field(1, minutes)
+	s.Hour = field(2, hours)
+	s.Month = field(4, months)
 	if err != nil {
 		return nil, err
 	}
+	if s.Dom, err = getDomField(fields[3], s); err != nil {
+		return nil, fieldErr(3, err)
+	}
+	if s.Dow, err = getDowField(fields[5], s); err != nil {
+		return nil, fieldErr(5, err)
+	}
+	if s.Year, err = getYearField(fields[6]); err != nil {
+		return nil, fieldErr(6, err)
+	}
 
-	return &SpecSchedule{
-		Second: second,
-		Minute: minute,
-		Hour:   hour,
-		Dom:    dayofmonth,
-		Month:  month,
-		Dow:    dayofweek,
-	}, nil
+	return s, nil
+}
+
+// parseLocation returns the time zone in the "CRON_TZ=" or "TZ=" prefix of
+// spec and the rest of spec. The time zone is nil if spec has no prefix.
+func parseLocation(spec string) (*time.Location, string, error) {
+	if !strings.HasPrefix(spec, "CRON_TZ=") && !strings.HasPrefix(spec, "TZ=") {
+		return nil, spec, nil
+	}
+
+	eq := strings.Index(spec, "=")
+	end := strings.IndexAny(spec, " \t")
+	if end == -1 {
+		end = len(spec)
+	}
+
+	name := spec[eq+1 : end]
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, spec, fmt.Errorf("Provided bad location %s: %s", name, err)
+	}
+	return loc, strings.TrimSpace(spec[end:]), nil
 }
 
 func expandFields(fields []string, options ParseOption) []string {
@@ -169,7 +236,7 @@ func ParseStandard(standardSpec string) (Schedule, error) {
 }
 
 var defaultParser = NewParser(
-	Second | Minute | Hour | Dom | Month | DowOptional | Descriptor,
+	Second | Minute | Hour | Dom | Month | DowOptional | YearOptional | Descriptor,
 )
 
 // Parse returns a new crontab schedule representing the given spec.
@@ -177,6 +244,8 @@ var defaultParser = NewParser(
 //
 // It accepts
 //   - Full crontab specs, e.g. "* * * * * ?"
+//   - Full crontab specs with a year field, e.g. "0 0 12 * * ? 2030"
+//   - Quartz extensions, e.g. "0 0 12 L * ?", "0 0 12 ? * FRI#2"
 //   - Descriptors, e.g. "@midnight", "@every 1h30m"
 func Parse(spec string) (Schedule, error) {
 	return defaultParser.Parse(spec)
@@ -198,6 +267,186 @@ func getField(field string, r bounds) (uint64, error) {
 	return bits, nil
 }
 
+// getDomField parses the day-of-month field. Besides the ranges accepted by
+// getField, the Quartz extensions below are set on s:
+//   L     the last day of the month
+//   L-n   n days before the last day of the month
+//   nW    the weekday nearest to day n, in the same month
+//   LW    the last weekday of the month
+func getDomField(field string, s *SpecSchedule) (uint64, error) {
+	var bits uint64
+	ranges := strings.FieldsFunc(field, func(r rune) bool { return r == ',' })
+	for _, expr := range ranges {
+		upper := strings.ToUpper(expr)
+		switch {
+		case upper == "L":
+			s.DomLast |= 1
+		case upper == "LW":
+			s.DomLastWeekday = true
+		case strings.HasPrefix(upper, "L-"):
+			n, err := mustParseInt(upper[2:])
+			if err != nil {
+				return 0, err
+			}
+			if n > dom.max-dom.min {
+				return 0, fmt.Errorf("Offset from the last day (%d) above maximum (%d): %s", n, dom.max-dom.min, expr)
+			}
+			s.DomLast |= 1 << n
+		case strings.HasSuffix(upper, "W"):
+			n, err := mustParseInt(upper[:len(upper)-1])
+			if err != nil {
+				return 0, err
+			}
+			if n < dom.min || n > dom.max {
+				return 0, fmt.Errorf("Day of nearest weekday (%d) out of range [%d, %d]: %s", n, dom.min, dom.max, expr)
+			}
+			s.DomWeekday |= 1 << n
+		default:
+			bit, err := getRange(expr, dom)
+			if err != nil {
+				return bits, err
+			}
+			bits |= bit
+		}
+	}
+	return bits, nil
+}
+
+// getDowField parses the day-of-week field. Besides the ranges accepted by
+// getField, the Quartz extensions below are set on s:
+//   L     the last day of the week, i.e. Saturday
+//   nL    the last weekday n of the month, e.g. "5L" or "FRIL"
+//   n#k   the k-th weekday n of the month, e.g. "2#1" or "TUE#2"
+func getDowField(field string, s *SpecSchedule) (uint64, error) {
+	var bits uint64
+	ranges := strings.FieldsFunc(field, func(r rune) bool { return r == ',' })
+	for _, expr := range ranges {
+		upper := strings.ToUpper(expr)
+		switch {
+		case upper == "L":
+			bits |= 1 << dow.max
+		case strings.HasSuffix(upper, "L"):
+			n, err := parseWeekday(expr[:len(expr)-1])
+			if err != nil {
+				return 0, err
+			}
+			s.DowLast |= 1 << n
+		case strings.Contains(upper, "#"):
+			parts := strings.Split(expr, "#")
+			if len(parts) != 2 {
+				return 0, fmt.Errorf("Too many hashes: %s", expr)
+			}
+			n, err := parseWeekday(parts[0])
+			if err != nil {
+				return 0, err
+			}
+			k, err := mustParseInt(parts[1])
+			if err != nil {
+				return 0, err
+			}
+			if k < 1 || k > 5 {
+				return 0, fmt.Errorf("Nth weekday (%d) out of range [1, 5]: %s", k, expr)
+			}
+			s.DowNth |= 1 << (k*7 + n)
+		default:
+			bit, err := getRange(expr, dow)
+			if err != nil {
+				return bits, err
+			}
+			bits |= bit
+		}
+	}
+	return bits, nil
+}
+
+// parseWeekday returns the (possibly-named) day of week contained in expr.
+func parseWeekday(expr string) (uint, error) {
+	n, err := parseIntOrName(expr, dow.names)
+	if err != nil {
+		return 0, err
+	}
+	if n > dow.max {
+		return 0, fmt.Errorf("Day of week (%d) above maximum (%d): %s", n, dow.max, expr)
+	}
+	return n, nil
+}
+
+// getYearField returns the sorted years the field represents, or nil if the
+// field is "*" or "?". A year field is a comma-separated list of ranges.
+func getYearField(field string) ([]int, error) {
+	if field == "*" || field == "?" {
+		return nil, nil
+	}
+
+	set := make(map[int]bool)
+	ranges := strings.FieldsFunc(field, func(r rune) bool { return r == ',' })
+	for _, expr := range ranges {
+		var (
+			start, end, step uint
+			rangeAndStep     = strings.Split(expr, "/")
+			lowAndHigh       = strings.Split(rangeAndStep[0], "-")
+			err              error
+		)
+
+		if lowAndHigh[0] == "*" || lowAndHigh[0] == "?" {
+			start, end = years.min, years.max
+		} else {
+			if start, err = mustParseInt(lowAndHigh[0]); err != nil {
+				return nil, err
+			}
+			end = start
+			switch len(lowAndHigh) {
+			case 1:
+				// Special handling: "N/step" means "N-max/step".
+				if len(rangeAndStep) == 2 {
+					end = years.max
+				}
+			case 2:
+				if end, err = mustParseInt(lowAndHigh[1]); err != nil {
+					return nil, err
+				}
+			default:
+				return nil, fmt.Errorf("Too many hyphens: %s", expr)
+			}
+		}
+
+		step = 1
+		switch len(rangeAndStep) {
+		case 1:
+		case 2:
+			if step, err = mustParseInt(rangeAndStep[1]); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("Too many slashes: %s", expr)
+		}
+
+		if start < years.min {
+			return nil, fmt.Errorf("Beginning of range (%d) below minimum (%d): %s", start, years.min, expr)
+		}
+		if end > years.max {
+			return nil, fmt.Errorf("End of range (%d) above maximum (%d): %s", end, years.max, expr)
+		}
+		if start > end {
+			return nil, fmt.Errorf("Beginning of range (%d) beyond end of range (%d): %s", start, end, expr)
+		}
+		if step == 0 {
+			return nil, fmt.Errorf("Step of range should be a positive number: %s", expr)
+		}
+
+		for y := start; y <= end; y += step {
+			set[int(y)] = true
+		}
+	}
+
+	list := make([]int, 0, len(set))
+	for y := range set {
+		list = append(list, y)
+	}
+	sort.Ints(list)
+	return list, nil
+}
+
 // getRange returns the bits indicated by the given expression:
 //   number | number "-" number [ "/" number ]
 // or error parsing range.
@@ -311,7 +560,8 @@ func all(r bounds) uint64 {
 }
 
 // parseDescriptor returns a predefined schedule for the expression, or error if none matches.
-func parseDescriptor(descriptor string) (Schedule, error) {
+// The schedule is evaluated in loc, nil means the time zone of the given time.
+func parseDescriptor(descriptor string, loc *time.Location) (Schedule, error) {
 	switch descriptor {
 	case "@yearly", "@annually":
 		return &SpecSchedule{
@@ -321,6 +571,8 @@ func parseDescriptor(descriptor string) (Schedule, error) {
 			Dom:    1 << dom.min,
 			Month:  1 << months.min,
 			Dow:    all(dow),
+
+			Location: loc,
 		}, nil
 
 	case "@monthly":
@@ -331,6 +583,8 @@ func parseDescriptor(descriptor string) (Schedule, error) {
 			Dom:    1 << dom.min,
 			Month:  all(months),
 			Dow:    all(dow),
+
+			Location: loc,
 		}, nil
 
 	case "@weekly":
@@ -341,6 +595,8 @@ func parseDescriptor(descriptor string) (Schedule, error) {
 			Dom:    all(dom),
 			Month:  all(months),
 			Dow:    1 << dow.min,
+
+			Location: loc,
 		}, nil
 
 	case "@daily", "@midnight":
@@ -351,6 +607,8 @@ func parseDescriptor(descriptor string) (Schedule, error) {
 			Dom:    all(dom),
 			Month:  all(months),
 			Dow:    all(dow),
+
+			Location: loc,
 		}, nil
 
 	case "@hourly":
@@ -361,6 +619,8 @@ func parseDescriptor(descriptor string) (Schedule, error) {
 			Dom:    all(dom),
 			Month:  all(months),
 			Dow:    all(dow),
+
+			Location: loc,
 		}, nil
 	}
 
@@ -379,7 +639,11 @@ func parseDescriptor(descriptor string) (Schedule, error) {
 		atls := make([]time.Time, 0, len(tss))
 		for _, ts := range tss {
 			ts = strings.TrimSpace(ts)
-			att, err := time.ParseInLocation("2006-01-02 15:04:05", ts, time.Local)
+			atLoc := loc
+			if atLoc == nil {
+				atLoc = time.Local
+			}
+			att, err := time.ParseInLocation("2006-01-02 15:04:05", ts, atLoc)
 			if err != nil {
 				return nil, fmt.Errorf("Failed to parse time %s: %s", descriptor, err)
 			}
@@ -173,7 +173,96 @@ func TestParse(t *testing.T) {
 		},
 		{
 			expr: "* * * *",
-			err:  "Expected 5 to 6 fields",
+			err:  "Expected 5 to 7 fields",
+		},
+		{
+			expr: "0 0 0 L * ?",
+			expected: &SpecSchedule{
+				Second:  1 << seconds.min,
+				Minute:  1 << minutes.min,
+				Hour:    1 << hours.min,
+				DomLast: 1,
+				Month:   all(months),
+				Dow:     all(dow),
+			},
+		},
+		{
+			expr: "0 0 0 L-2,15W,LW,1 * ?",
+			expected: &SpecSchedule{
+				Second:         1 << seconds.min,
+				Minute:         1 << minutes.min,
+				Hour:           1 << hours.min,
+				Dom:            1 << 1,
+				DomLast:        1 << 2,
+				DomWeekday:     1 << 15,
+				DomLastWeekday: true,
+				Month:          all(months),
+				Dow:            all(dow),
+			},
+		},
+		{
+			expr: "0 0 0 ? * 5L,TUE#2,L,Mon",
+			expected: &SpecSchedule{
+				Second:  1 << seconds.min,
+				Minute:  1 << minutes.min,
+				Hour:    1 << hours.min,
+				Dom:     all(dom),
+				Month:   all(months),
+				Dow:     1<<6 | 1<<1,
+				DowLast: 1 << 5,
+				DowNth:  1 << (2*7 + 2),
+			},
+		},
+		{
+			expr: "0 0 0 * * ? 2020-2030/5,2040,2025",
+			expected: &SpecSchedule{
+				Second: 1 << seconds.min,
+				Minute: 1 << minutes.min,
+				Hour:   1 << hours.min,
+				Dom:    all(dom),
+				Month:  all(months),
+				Dow:    all(dow),
+				Year:   []int{2020, 2025, 2030, 2040},
+			},
+		},
+		{
+			expr: "0 0 0 * * ? *",
+			expected: &SpecSchedule{
+				Second: 1 << seconds.min,
+				Minute: 1 << minutes.min,
+				Hour:   1 << hours.min,
+				Dom:    all(dom),
+				Month:  all(months),
+				Dow:    all(dow),
+			},
+		},
+		{
+			expr: "0 0 0 L-31 * ?",
+			err:  "Offset from the last day",
+		},
+		{
+			expr: "0 0 0 32W * ?",
+			err:  "Day of nearest weekday",
+		},
+		{
+			expr: "0 0 0 ? * 2#6",
+			err:  "Nth weekday",
+		},
+		{
+			expr: "0 0 0 ? * 1#2#3",
+			err:  "Too many hashes",
+		},
+		{
+			expr: "0 0 0 ? * 8L",
+			err:  "Day of week",
+		},
+		{
+			expr: "0 0 0 * * ? 1969",
+			err:  "below minimum",
+		},
+		{
+			expr: "0 0 0 * * ? 2020-2100",
+			err:  "above maximum",
 		},
 	}
 
@@ -198,8 +287,15 @@ func TestStandardSpecSchedule(t *testing.T) {
 		err      string
 	}{
 		{
-			expr:     "5 * * * *",
-			expected: &SpecSchedule{1 << seconds.min, 1 << 5, all(hours), all(dom), all(months), all(dow)},
+			expr: "5 * * * *",
+			expected: &SpecSchedule{
+				Second: 1 << seconds.min,
+				Minute: 1 << 5,
+				Hour:   all(hours),
+				Dom:    all(dom),
+				Month:  all(months),
+				Dow:    all(dow),
+			},
 		},
 		{
 			expr:     "@every 5m",
@@ -228,3 +324,29 @@ func TestStandardSpecSchedule(t *testing.T) {
 		}
 	}
 }
+
+func TestParseFieldError(t *testing.T) {
+	entries := []struct {
+		expr  string
+		field string
+		value string
+	}{
+		{"* 5 j * * *", "hour", "j"},
+		{"0 0 0 32W * ?", "day of month", "32W"},
+		{"0 0 0 ? * MON#6", "day of week", "MON#6"},
+		{"0 0 0 * 13 ?", "month", "13"},
+		{"0 0 0 * * ? 1969", "year", "1969"},
+	}
+
+	for _, c := range entries {
+		_, err := Parse(c.expr)
+		fe, ok := err.(*FieldError)
+		if !ok {
+			t.Errorf("%s => expected field error, got %v", c.expr, err)
+			continue
+		}
+		if fe.Field != c.field || fe.Value != c.value {
+			t.Errorf("%s => expected field %s %q, got %s %q", c.expr, c.field, c.value, fe.Field, fe.Value)
+		}
+	}
+}
@@ -1,11 +1,30 @@
 package cron
 
-import "time"
+import (
+	"sort"
+	"time"
+)
 
 // SpecSchedule specifies a duty cycle (to the second granularity), based on a
 // traditional crontab specification. It is computed initially and stored as bit sets.
 type SpecSchedule struct {
 	Second, Minute, Hour, Dom, Month, Dow uint64
+
+	// Quartz extensions of the day-of-month and day-of-week fields, they match
+	// in addition to the Dom and Dow bits.
+	DomLast        uint64 // bit n: n days before the last day of the month, "L" or "L-n"
+	DomWeekday     uint64 // bit n: the weekday nearest to day n, "nW"
+	DomLastWeekday bool   // the last weekday of the month, "LW"
+	DowLast        uint64 // bit n: the last weekday n of the month, "nL"
+	DowNth         uint64 // bit k*7+n: the k-th weekday n of the month, "n#k"
+
+	// Year lists the years the schedule is active in, sorted. A nil Year
+	// means every year.
+	Year []int
+
+	// Location is the time zone the schedule is evaluated in. A nil Location
+	// means the time zone of the time passed to Next.
+	Location *time.Location
 }
 
 // bounds provides a range of acceptable values (plus a map of name to value).
@@ -34,7 +53,8 @@ var (
 		"nov": 11,
 		"dec": 12,
 	}}
-	dow = bounds{0, 6, map[string]uint{
+	years = bounds{1970, 2099, nil}
+	dow   = bounds{0, 6, map[string]uint{
 		"sun": 0,
 		"mon": 1,
 		"tue": 2,
@@ -61,6 +81,13 @@ func (s *SpecSchedule) Next(t time.Time) time.Time {
 	// of the field list (since it is necessary to re-verify previous field
 	// values)
 
+	// Convert the given time into the schedule's time zone, and back into
+	// the original time zone when returning.
+	origLocation := t.Location()
+	if s.Location != nil {
+		t = t.In(s.Location)
+	}
+
 	// Start at the earliest possible time (the upcoming second).
 	t = t.Add(1*time.Second - time.Duration(t.Nanosecond())*time.Nanosecond)
 
@@ -75,6 +102,19 @@ WRAP:
 		return time.Time{}
 	}
 
+	// Find the first applicable year.
+	if !s.yearMatches(t.Year()) {
+		y := s.nextYear(t.Year())
+		if y == 0 {
+			return time.Time{}
+		}
+		added = true
+		t = time.Date(y, time.January, 1, 0, 0, 0, 0, t.Location())
+		if yearLimit < y {
+			yearLimit = y
+		}
+	}
+
 	// Find the first applicable month.
 	// If it's this month, then do nothing.
 	for 1<<uint(t.Month())&s.Month == 0 {
@@ -141,18 +181,101 @@ WRAP:
 		}
 	}
 
-	return t
+	return t.In(origLocation)
+}
+
+// yearMatches returns true if the schedule is active in the given year.
+func (s *SpecSchedule) yearMatches(year int) bool {
+	if s.Year == nil {
+		return true
+	}
+	i := sort.SearchInts(s.Year, year)
+	return i < len(s.Year) && s.Year[i] == year
+}
+
+// nextYear returns the first year after the given one the schedule is active
+// in, or 0 if there is none.
+func (s *SpecSchedule) nextYear(year int) int {
+	i := sort.SearchInts(s.Year, year+1)
+	if i == len(s.Year) {
+		return 0
+	}
+	return s.Year[i]
 }
 
 // dayMatches returns true if the schedule's day-of-week and day-of-month
 // restrictions are satisfied by the given time.
 func dayMatches(s *SpecSchedule, t time.Time) bool {
 	var (
-		domMatch bool = 1<<uint(t.Day())&s.Dom > 0
-		dowMatch bool = 1<<uint(t.Weekday())&s.Dow > 0
+		domMatch bool = 1<<uint(t.Day())&s.Dom > 0 || domExtMatches(s, t)
+		dowMatch bool = 1<<uint(t.Weekday())&s.Dow > 0 || dowExtMatches(s, t)
 	)
 	if s.Dom&starBit > 0 || s.Dow&starBit > 0 {
 		return domMatch && dowMatch
 	}
 	return domMatch || dowMatch
 }
+
+// daysIn returns the number of days in the month of t.
+func daysIn(t time.Time) int {
+	return time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, t.Location()).Day()
+}
+
+// nearestWeekday returns the weekday nearest to the given day in the month of
+// t, without crossing into another month.
+func nearestWeekday(t time.Time, day int) int {
+	last := daysIn(t)
+	switch time.Date(t.Year(), t.Month(), day, 0, 0, 0, 0, t.Location()).Weekday() {
+	case time.Saturday:
+		if day == 1 {
+			return day + 2
+		}
+		return day - 1
+	case time.Sunday:
+		if day == last {
+			return day - 2
+		}
+		return day + 1
+	}
+	return day
+}
+
+// domExtMatches returns true if the day of t matches the Quartz extensions
+// of the day-of-month field.
+func domExtMatches(s *SpecSchedule, t time.Time) bool {
+	if s.DomLast == 0 && s.DomWeekday == 0 && !s.DomLastWeekday {
+		return false
+	}
+
+	day, last := t.Day(), daysIn(t)
+	if 1<<uint(last-day)&s.DomLast > 0 {
+		return true
+	}
+
+	if s.DomLastWeekday && day == nearestWeekday(t, last) {
+		return true
+	}
+
+	for n := dom.min; n <= dom.max && int(n) <= last; n++ {
+		if 1<<n&s.DomWeekday > 0 && nearestWeekday(t, int(n)) == day {
+			return true
+		}
+	}
+	return false
+}
+
+// dowExtMatches returns true if the day of t matches the Quartz extensions
+// of the day-of-week field.
+func dowExtMatches(s *SpecSchedule, t time.Time) bool {
+	if s.DowLast == 0 && s.DowNth == 0 {
+		return false
+	}
+
+	day, wd := t.Day(), uint(t.Weekday())
+	if 1<<wd&s.DowLast > 0 && day+7 > daysIn(t) {
+		return true
+	}
+
+	k := uint(day-1)/7 + 1
+	return 1<<(k*7+wd)&s.DowNth > 0
+}
@@ -150,6 +150,50 @@ func TestNext(t *testing.T) {
 		// Unsatisfiable
 		{"Mon Jul 9 23:35 2012", "0 0 0 30 Feb ?", ""},
 		{"Mon Jul 9 23:35 2012", "0 0 0 31 Apr ?", ""},
+
+		// Last day of month
+		{"Mon Jul 9 23:35 2012", "0 0 0 L * ?", "Tue Jul 31 00:00 2012"},
+		{"Wed Feb 1 00:00 2012", "0 0 0 L * ?", "Wed Feb 29 00:00 2012"},
+		{"Fri Feb 1 00:00 2013", "0 0 0 L * ?", "Thu Feb 28 00:00 2013"},
+		{"Mon Jul 9 23:35 2012", "0 0 0 1,L * ?", "Tue Jul 31 00:00 2012"},
+		{"Mon Jul 9 23:35 2012", "0 0 0 L-3 * ?", "Sat Jul 28 00:00 2012"},
+		{"Wed Feb 1 00:00 2012", "0 0 0 L-1 Feb ?", "Tue Feb 28 00:00 2012"},
+		{"Mon Jul 9 23:35 2012", "0 0 0 L Feb ?", "Fri Feb 28 00:00 2013"},
+
+		// Nearest weekday
+		{"Mon Jul 9 23:35 2012", "0 0 0 15W * ?", "Mon Jul 16 00:00 2012"},
+		{"Mon Jul 9 23:35 2012", "0 0 0 20W * ?", "Fri Jul 20 00:00 2012"},
+		{"Mon Jul 9 23:35 2012", "0 0 0 14W * ?", "Fri Jul 13 00:00 2012"},
+		{"Mon Aug 6 00:00 2012", "0 0 0 1W * ?", "Mon Sep 3 00:00 2012"},
+		{"Mon Sep 3 00:00 2012", "0 0 0 30W * ?", "Fri Sep 28 00:00 2012"},
+		{"Wed Feb 1 00:00 2012", "0 0 0 30W * ?", "Fri Mar 30 00:00 2012"},
+
+		// Last weekday of month
+		{"Mon Sep 3 00:00 2012", "0 0 0 LW * ?", "Fri Sep 28 00:00 2012"},
+		{"Fri Jun 1 00:00 2012", "0 0 0 LW * ?", "Fri Jun 29 00:00 2012"},
+		{"Mon Jul 9 23:35 2012", "0 0 0 LW * ?", "Tue Jul 31 00:00 2012"},
+
+		// Last given weekday of month
+		{"Mon Jul 9 23:35 2012", "0 0 0 ? * 5L", "Fri Jul 27 00:00 2012"},
+		{"Mon Jul 9 23:35 2012", "0 0 0 ? * FRIL", "Fri Jul 27 00:00 2012"},
+		{"Wed Feb 1 00:00 2012", "0 0 0 ? * 3L", "Wed Feb 29 00:00 2012"},
+		{"Mon Jul 9 23:35 2012", "0 0 0 ? * L", "Sat Jul 14 00:00 2012"},
+
+		// Nth weekday of month
+		{"Mon Jul 9 23:35 2012", "0 0 0 ? * 2#2", "Tue Jul 10 00:00 2012"},
+		{"Wed Jul 11 00:00 2012", "0 0 0 ? * TUE#2", "Tue Aug 14 00:00 2012"},
+		{"Mon Jul 9 23:35 2012", "0 0 0 ? * 5#5", "Fri Aug 31 00:00 2012"},
+		{"Mon Jul 9 23:35 2012", "0 0 0 ? * 1#1,5L", "Fri Jul 27 00:00 2012"},
+		{"Wed Feb 1 00:00 2012", "0 0 0 ? Feb 3#5", "Wed Feb 29 00:00 2012"},
+
+		// Year
+		{"Mon Jul 9 23:35 2012", "0 0 0 1 Jan ? 2015", "Thu Jan 1 00:00 2015"},
+		{"Mon Jul 9 23:35 2012", "0 0 12 1 Jan ? 2030", "Tue Jan 1 12:00 2030"},
+		{"Mon Jul 9 23:35 2012", "0 0 0 1 Jan ? 2013/5", "Tue Jan 1 00:00 2013"},
+		{"Tue Jan 1 00:00 2013", "0 0 0 1 Jan ? 2013/5", "Sat Jan 1 00:00 2018"},
+		{"Mon Jul 9 23:35 2012", "0 0 0 L Feb ? 2016", "Mon Feb 29 00:00 2016"},
+		{"Mon Jul 9 23:35 2012", "0 0 0 29 Feb ? 2013-2015", ""},
+		{"Mon Jul 9 23:35 2012", "0 0 0 * * ? 2011", ""},
 	}
 
 	for _, c := range runs {
@@ -247,3 +291,40 @@ func getTimeTZ(value string) time.Time {
 
 	return t
 }
+
+func TestNextWithCronTz(t *testing.T) {
+	runs := []struct {
+		time, spec string
+		expected   string
+	}{
+		{"2016-01-03T13:09:03+0800", "CRON_TZ=Asia/Tokyo 0 0 9 * * *", "2016-01-04T08:00:00+0800"},
+		{"2016-01-03T07:09:03+0800", "TZ=Asia/Tokyo 0 0 9 * * *", "2016-01-03T08:00:00+0800"},
+		{"2016-01-03T13:09:03+0000", "CRON_TZ=Asia/Kolkata 0 30 20 * * *", "2016-01-03T15:00:00+0000"},
+		{"2016-01-03T13:09:03+0000", "CRON_TZ=Asia/Tokyo @daily", "2016-01-03T15:00:00+0000"},
+		// Daylight savings time in New York begins at 2016-03-13 02:00
+		{"2016-03-12T12:00:00+0000", "CRON_TZ=America/New_York 0 0 9 * * *", "2016-03-12T14:00:00+0000"},
+		{"2016-03-12T15:00:00+0000", "CRON_TZ=America/New_York 0 0 9 * * *", "2016-03-13T13:00:00+0000"},
+	}
+	for _, c := range runs {
+		sched, err := Parse(c.spec)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		at := getTimeTZ(c.time)
+		actual := sched.Next(at)
+		expected := getTimeTZ(c.expected)
+		if !actual.Equal(expected) {
+			t.Errorf("%s, \"%s\": (expected) %v != %v (actual)", c.time, c.spec, expected, actual)
+		}
+		if actual.Location() != at.Location() {
+			t.Errorf("%s, \"%s\": expected time in %s, got %s", c.time, c.spec, at.Location(), actual.Location())
+		}
+	}
+
+	for _, spec := range []string{"CRON_TZ=Mars/Olympus 0 0 9 * * *", "CRON_TZ=Asia/Tokyo"} {
+		if _, err := Parse(spec); err == nil {
+			t.Error("expected an error parsing: ", spec)
+		}
+	}
+}
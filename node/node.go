@@ -8,7 +8,7 @@ import (
 	"path"
 	"strconv"
 	"strings"
-	"syscall"
+	"sync"
 	"time"
 
 	"cronsun"
@@ -28,6 +28,10 @@ type Node struct {
 	jobs   Jobs // 和结点相关的任务
 	groups Groups
 	cmds   map[string]*cronsun.Cmd
+	// 规则的文件触发
+	watchers map[string]*cronsun.FileWatcher
+	// 结束时间后暂停任务的计时器
+	expiries map[string]*time.Timer
 
 	link
 	// 删除的 job id，用于 group 更新
@@ -36,6 +40,9 @@ type Node struct {
 	ttl  int64
 	lID  client.LeaseID // lease id
 	done chan struct{}
+
+	// 任务、分组的监听和配置更新都会修改上面的 map，需要串行处理
+	mu sync.Mutex
 }
 
 func NewNode(cfg *conf.Conf) (n *Node, err error) {
@@ -64,6 +71,8 @@ func NewNode(cfg *conf.Conf) (n *Node, err error) {
 				PIDFile:  strings.TrimSpace(cfg.PIDFile),
 				IP:       ip.String(),
 				Hostname: hostname,
+				Version:  cronsun.Version,
+				Labels:   cronsun.NodeLabels(cfg.Labels, hostname, ip.String()),
 			},
 		},
 		Cron: cron.New(),
@@ -71,6 +80,9 @@ func NewNode(cfg *conf.Conf) (n *Node, err error) {
 		jobs: make(Jobs, 8),
 		cmds: make(map[string]*cronsun.Cmd),
 
+		watchers: make(map[string]*cronsun.FileWatcher),
+		expiries: make(map[string]*time.Timer),
+
 		link:   newLink(8),
 		delIDs: make(map[string]bool, 8),
 
@@ -176,7 +188,7 @@ func (n *Node) keepAlive() {
 }
 
 func (n *Node) loadJobs() (err error) {
-	if n.groups, err = cronsun.GetGroups(""); err != nil {
+	if n.groups, err = cronsun.GetGroups(nil); err != nil {
 		return
 	}
 
@@ -199,12 +211,13 @@ func (n *Node) loadJobs() (err error) {
 
 func (n *Node) addJob(job *cronsun.Job, notice bool) {
 	n.link.addJob(job)
+	cronsun.AddJobDepends(job)
 
-	if job.IsRunOn(n.Data.ID, n.groups) {
+	if job.IsRunOn(n.Data, n.groups) {
 		n.jobs[job.ID] = job
 	}
 
-	cmds := job.Cmds(n.Data.ID, n.groups)
+	cmds := job.Cmds(n.Data, n.groups)
 	if len(cmds) == 0 {
 		return
 	}
@@ -217,6 +230,7 @@ func (n *Node) addJob(job *cronsun.Job, notice bool) {
 
 func (n *Node) delJob(id string) {
 	n.delIDs[id] = true
+	cronsun.DelJobDepends(id)
 	job, ok := n.jobs[id]
 	// 之前此任务没有在当前结点执行
 	if !ok {
@@ -226,7 +240,7 @@ func (n *Node) delJob(id string) {
 	delete(n.jobs, id)
 	n.link.delJob(job)
 
-	cmds := job.Cmds(n.Data.ID, n.groups)
+	cmds := job.Cmds(n.Data, n.groups)
 	if len(cmds) == 0 {
 		return
 	}
@@ -246,11 +260,12 @@ func (n *Node) modJob(job *cronsun.Job) {
 	}
 
 	n.link.delJob(oJob)
-	prevCmds := oJob.Cmds(n.Data.ID, n.groups)
+	prevCmds := oJob.Cmds(n.Data, n.groups)
 
 	job.Count = oJob.Count
 	*oJob = *job
-	cmds := oJob.Cmds(n.Data.ID, n.groups)
+	cronsun.AddJobDepends(oJob)
+	cmds := oJob.Cmds(n.Data, n.groups)
 
 	for id, cmd := range cmds {
 		n.modCmd(cmd, true)
@@ -265,8 +280,14 @@ func (n *Node) modJob(job *cronsun.Job) {
 }
 
 func (n *Node) addCmd(cmd *cronsun.Cmd, notice bool) {
-	n.Cron.Schedule(cmd.JobRule.Schedule, cmd)
+	if cmd.JobRule.Schedule != nil {
+		n.Cron.Schedule(cmd.ActiveSchedule(), cmd)
+	}
 	n.cmds[cmd.GetID()] = cmd
+	if cmd.JobRule.Watch != nil {
+		n.addWatcher(cmd)
+	}
+	n.addExpiry(cmd)
 
 	if notice {
 		log.Infof("job[%s] group[%s] rule[%s] timer[%s] has added", cmd.Job.ID, cmd.Job.Group, cmd.JobRule.ID, cmd.JobRule.Timer)
@@ -281,15 +302,31 @@ func (n *Node) modCmd(cmd *cronsun.Cmd, notice bool) {
 		return
 	}
 
-	sch := c.JobRule.Timer
+	sch, tz, w := c.JobRule.Timer, c.JobRule.Timezone, c.JobRule.Watch
 	*c = *cmd
 
 	// 节点执行时间改变，更新 cron
+	// 设置了有效期时，有效期可能改变，也更新 cron
 	// 否则不用更新 cron
-	if c.JobRule.Timer != sch {
-		n.Cron.Schedule(c.JobRule.Schedule, c)
+	if c.JobRule.Timer != sch || c.JobRule.Timezone != tz || c.Job.StartAt != nil || c.Job.EndAt != nil {
+		if c.JobRule.Schedule != nil {
+			n.Cron.Schedule(c.ActiveSchedule(), c)
+		} else {
+			n.Cron.DelJob(c)
+		}
 	}
 
+	// 文件触发改变时重新监听
+	if !c.JobRule.Watch.Equal(w) {
+		n.delWatcher(c)
+		if c.JobRule.Watch != nil {
+			n.addWatcher(c)
+		}
+	}
+
+	// 结束时间可能改变
+	n.addExpiry(c)
+
 	if notice {
 		log.Infof("job[%s] group[%s] rule[%s] timer[%s] has updated", c.Job.ID, c.Job.Group, c.JobRule.ID, c.JobRule.Timer)
 	}
@@ -298,9 +335,45 @@ func (n *Node) modCmd(cmd *cronsun.Cmd, notice bool) {
 func (n *Node) delCmd(cmd *cronsun.Cmd) {
 	delete(n.cmds, cmd.GetID())
 	n.Cron.DelJob(cmd)
+	n.delWatcher(cmd)
+	n.delExpiry(cmd)
+	cronsun.DelFireState(cmd)
 	log.Infof("job[%s] group[%s] rule[%s] timer[%s] has deleted", cmd.Job.ID, cmd.Job.Group, cmd.JobRule.ID, cmd.JobRule.Timer)
 }
 
+// 监听规则的目录，cmd 更新时也使用更新后的任务执行
+func (n *Node) addWatcher(cmd *cronsun.Cmd) {
+	n.delWatcher(cmd)
+
+	fw := cronsun.NewFileWatcher(cmd.JobRule.Watch, cmd.RunFile)
+	if err := fw.Start(); err != nil {
+		log.Warnf("job[%s] group[%s] rule[%s] watch dir[%s] err: %s", cmd.Job.ID, cmd.Job.Group, cmd.JobRule.ID, cmd.JobRule.Watch.Dir, err.Error())
+		return
+	}
+	n.watchers[cmd.GetID()] = fw
+}
+
+func (n *Node) delWatcher(cmd *cronsun.Cmd) {
+	if fw, ok := n.watchers[cmd.GetID()]; ok {
+		fw.Stop()
+		delete(n.watchers, cmd.GetID())
+	}
+}
+
+func (n *Node) addExpiry(cmd *cronsun.Cmd) {
+	n.delExpiry(cmd)
+	if t := cmd.ExpireTimer(); t != nil {
+		n.expiries[cmd.GetID()] = t
+	}
+}
+
+func (n *Node) delExpiry(cmd *cronsun.Cmd) {
+	if t, ok := n.expiries[cmd.GetID()]; ok {
+		t.Stop()
+		delete(n.expiries, cmd.GetID())
+	}
+}
+
 func (n *Node) addGroup(g *cronsun.Group) {
 	n.groups[g.ID] = g
 }
@@ -322,7 +395,7 @@ func (n *Node) delGroup(id string) {
 			continue
 		}
 
-		cmds := job.Cmds(n.Data.ID, n.groups)
+		cmds := job.Cmds(n.Data, n.groups)
 		if len(cmds) == 0 {
 			continue
 		}
@@ -343,13 +416,13 @@ func (n *Node) modGroup(g *cronsun.Group) {
 	}
 
 	// 都包含/都不包含当前节点，对当前节点任务无影响
-	if (oGroup.Included(n.Data.ID) && g.Included(n.Data.ID)) || (!oGroup.Included(n.Data.ID) && !g.Included(n.Data.ID)) {
+	if oGroup.Included(n.Data) == g.Included(n.Data) {
 		*oGroup = *g
 		return
 	}
 
 	// 增加当前节点
-	if !oGroup.Included(n.Data.ID) && g.Included(n.Data.ID) {
+	if g.Included(n.Data) {
 		n.groupAddNode(g)
 		return
 	}
@@ -392,7 +465,7 @@ func (n *Node) groupAddNode(g *cronsun.Group) {
 			n.jobs[jid] = job
 		}
 
-		cmds := job.Cmds(n.Data.ID, n.groups)
+		cmds := job.Cmds(n.Data, n.groups)
 		for _, cmd := range cmds {
 			n.addCmd(cmd, true)
 		}
@@ -416,9 +489,9 @@ func (n *Node) groupRmNode(g, og *cronsun.Group) {
 		}
 
 		n.groups[og.ID] = og
-		prevCmds := job.Cmds(n.Data.ID, n.groups)
+		prevCmds := job.Cmds(n.Data, n.groups)
 		n.groups[g.ID] = g
-		cmds := job.Cmds(n.Data.ID, n.groups)
+		cmds := job.Cmds(n.Data, n.groups)
 
 		for id, cmd := range cmds {
 			n.addCmd(cmd, true)
@@ -433,43 +506,105 @@ func (n *Node) groupRmNode(g, og *cronsun.Group) {
 	n.groups[g.ID] = g
 }
 
+// ReloadLabels 配置更新后重新设置结点标签
+// 标签改变时，按新的标签重新计算需要执行的任务
+func (n *Node) ReloadLabels(i interface{}) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	labels := cronsun.NodeLabels(conf.Config.Labels, n.Data.Hostname, n.Data.IP)
+	if cronsun.EqualLabels(labels, n.Data.Labels) {
+		return
+	}
+
+	// 标签改变后可能需要执行之前不在本结点执行的任务
+	jobs, err := cronsun.GetJobs()
+	if err != nil {
+		log.Warnf("%s labels changed, get jobs err: %s", n.String(), err.Error())
+		return
+	}
+
+	prevCmds := make(map[string]*cronsun.Cmd, len(n.cmds))
+	for _, job := range n.jobs {
+		for id, cmd := range job.Cmds(n.Data, n.groups) {
+			prevCmds[id] = cmd
+		}
+	}
+
+	// 和监听到任务修改时一样更新分组关联、上游依赖和规则
+	// 之前的规则按旧的标签计算，新标签下不再执行的在最后删除
+	n.Data.Labels = labels
+	for _, job := range jobs {
+		job.Init(n.Data.ID, n.Data.Hostname, n.Data.IP)
+		n.modJob(job)
+		for cid := range job.Cmds(n.Data, n.groups) {
+			delete(prevCmds, cid)
+		}
+	}
+
+	for id, cmd := range prevCmds {
+		if _, ok := n.cmds[id]; ok {
+			n.delCmd(cmd)
+		}
+	}
+
+	n.Node.SyncToMgo()
+	log.Infof("%s labels changed: %v", n.String(), labels)
+}
+
+// KillExcutingProc 按任务设置的信号和等待时间结束进程组
 func (n *Node) KillExcutingProc(process *cronsun.Process) {
 	pid, _ := strconv.Atoi(process.ID)
-	if err := syscall.Kill(-pid, syscall.SIGKILL); err != nil {
-		log.Warnf("process:[%d] force kill failed, error:[%s]\n", pid, err)
-		return
+	n.mu.Lock()
+	job, ok := n.jobs[process.JobID]
+	n.mu.Unlock()
+	if !ok {
+		job = &cronsun.Job{}
 	}
+
+	go func() {
+		if err := job.Kill(pid); err != nil {
+			log.Warnf("process:[%d] kill failed, error:[%s]\n", pid, err)
+		}
+	}()
 }
 
 func (n *Node) watchJobs() {
 	rch := cronsun.WatchJobs()
 	for wresp := range rch {
 		for _, ev := range wresp.Events {
-			switch {
-			case ev.IsCreate():
-				job, err := cronsun.GetJobFromKv(ev.Kv.Key, ev.Kv.Value)
-				if err != nil {
-					log.Warnf("err: %s, kv: %s", err.Error(), ev.Kv.String())
-					continue
-				}
+			n.jobEvent(ev)
+		}
+	}
+}
 
-				job.Init(n.Data.ID, n.Data.Hostname, n.Data.IP)
-				n.addJob(job, true)
-			case ev.IsModify():
-				job, err := cronsun.GetJobFromKv(ev.Kv.Key, ev.Kv.Value)
-				if err != nil {
-					log.Warnf("err: %s, kv: %s", err.Error(), ev.Kv.String())
-					continue
-				}
+func (n *Node) jobEvent(ev *client.Event) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
 
-				job.Init(n.Data.ID, n.Data.Hostname, n.Data.IP)
-				n.modJob(job)
-			case ev.Type == client.EventTypeDelete:
-				n.delJob(cronsun.GetIDFromKey(string(ev.Kv.Key)))
-			default:
-				log.Warnf("unknown event type[%v] from job[%s]", ev.Type, string(ev.Kv.Key))
-			}
+	switch {
+	case ev.IsCreate():
+		job, err := cronsun.GetJobFromKv(ev.Kv.Key, ev.Kv.Value)
+		if err != nil {
+			log.Warnf("err: %s, kv: %s", err.Error(), ev.Kv.String())
+			return
+		}
+
+		job.Init(n.Data.ID, n.Data.Hostname, n.Data.IP)
+		n.addJob(job, true)
+	case ev.IsModify():
+		job, err := cronsun.GetJobFromKv(ev.Kv.Key, ev.Kv.Value)
+		if err != nil {
+			log.Warnf("err: %s, kv: %s", err.Error(), ev.Kv.String())
+			return
 		}
+
+		job.Init(n.Data.ID, n.Data.Hostname, n.Data.IP)
+		n.modJob(job)
+	case ev.Type == client.EventTypeDelete:
+		n.delJob(cronsun.GetIDFromKey(string(ev.Kv.Key)))
+	default:
+		log.Warnf("unknown event type[%v] from job[%s]", ev.Type, string(ev.Kv.Key))
 	}
 }
 
@@ -506,27 +641,54 @@ func (n *Node) watchGroups() {
 	rch := cronsun.WatchGroups()
 	for wresp := range rch {
 		for _, ev := range wresp.Events {
-			switch {
-			case ev.IsCreate():
-				g, err := cronsun.GetGroupFromKv(ev.Kv.Key, ev.Kv.Value)
-				if err != nil {
-					log.Warnf("err: %s, kv: %s", err.Error(), ev.Kv.String())
-					continue
-				}
+			n.groupEvent(ev)
+		}
+	}
+}
 
-				n.addGroup(g)
-			case ev.IsModify():
-				g, err := cronsun.GetGroupFromKv(ev.Kv.Key, ev.Kv.Value)
+func (n *Node) groupEvent(ev *client.Event) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	switch {
+	case ev.IsCreate():
+		g, err := cronsun.GetGroupFromKv(ev.Kv.Key, ev.Kv.Value)
+		if err != nil {
+			log.Warnf("err: %s, kv: %s", err.Error(), ev.Kv.String())
+			return
+		}
+
+		n.addGroup(g)
+	case ev.IsModify():
+		g, err := cronsun.GetGroupFromKv(ev.Kv.Key, ev.Kv.Value)
+		if err != nil {
+			log.Warnf("err: %s, kv: %s", err.Error(), ev.Kv.String())
+			return
+		}
+
+		n.modGroup(g)
+	case ev.Type == client.EventTypeDelete:
+		n.delGroup(cronsun.GetIDFromKey(string(ev.Kv.Key)))
+	default:
+		log.Warnf("unknown event type[%v] from group[%s]", ev.Type, string(ev.Kv.Key))
+	}
+}
+
+func (n *Node) watchCalendars() {
+	rch := cronsun.WatchCalendars()
+	for wresp := range rch {
+		for _, ev := range wresp.Events {
+			switch {
+			case ev.IsCreate(), ev.IsModify():
+				c, err := cronsun.GetCalendarFromKv(ev.Kv.Key, ev.Kv.Value)
 				if err != nil {
 					log.Warnf("err: %s, kv: %s", err.Error(), ev.Kv.String())
 					continue
 				}
 
-				n.modGroup(g)
+				cronsun.PutCalendar(c)
 			case ev.Type == client.EventTypeDelete:
-				n.delGroup(cronsun.GetIDFromKey(string(ev.Kv.Key)))
-			default:
-				log.Warnf("unknown event type[%v] from group[%s]", ev.Type, string(ev.Kv.Key))
+				cronsun.DelCalendar(cronsun.GetIDFromKey(string(ev.Kv.Key)))
 			}
 		}
 	}
@@ -538,21 +700,63 @@ func (n *Node) watchOnce() {
 		for _, ev := range wresp.Events {
 			switch {
 			case ev.IsCreate(), ev.IsModify():
-				if len(ev.Kv.Value) != 0 && string(ev.Kv.Value) != n.Data.ID {
+				once := cronsun.ParseOnce(ev.Kv.Value)
+				if !once.IsRunOn(n.Data.ID) {
 					continue
 				}
 
-				job, ok := n.jobs[cronsun.GetIDFromKey(string(ev.Kv.Key))]
-				if !ok || !job.IsRunOn(n.Data.ID, n.groups) {
+				run := n.onceRun(cronsun.GetIDFromKey(string(ev.Kv.Key)), once)
+				if run == nil {
 					continue
 				}
-
-				go job.RunWithRecovery()
+				go func(trs []*cronsun.Trigger) {
+					for _, tr := range trs {
+						run(tr)
+					}
+				}(once.Triggers(n.Data.ID))
 			}
 		}
 	}
 }
 
+// 立即执行的任务在本结点的执行方式，不在本结点执行时返回 nil
+func (n *Node) onceRun(id string, once *cronsun.Once) func(*cronsun.Trigger) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	job, ok := n.jobs[id]
+	if !ok || !job.IsRunOn(n.Data, n.groups) {
+		return nil
+	}
+
+	// 调度到本结点的定时触发按规则执行，同一结点的多个分片依次执行
+	// 上游任务的触发按本结点的第一个规则执行
+	switch {
+	case len(once.RuleID) > 0:
+		if cmd, ok := n.cmds[job.ID+once.RuleID]; ok {
+			return cmd.RunDispatched
+		}
+	case once.TriggerType == cronsun.TriggerDepend:
+		cmd := n.dependCmd(job)
+		if cmd == nil {
+			log.Warnf("job[%s] has no rule for depend trigger on %s", job.Key(), n.String())
+			return nil
+		}
+		return cmd.RunDepend
+	}
+	return job.RunWithRecovery
+}
+
+// 任务在本结点的第一个规则
+func (n *Node) dependCmd(job *cronsun.Job) *cronsun.Cmd {
+	for _, r := range job.Rules {
+		if cmd, ok := n.cmds[job.ID+r.ID]; ok {
+			return cmd
+		}
+	}
+	return nil
+}
+
 func (n *Node) watchCsctl() {
 	rch := cronsun.WatchCsctl()
 	for wresp := range rch {
@@ -565,6 +769,39 @@ func (n *Node) watchCsctl() {
 	}
 }
 
+// 定时保存每个规则最后一次触发时间
+func (n *Node) saveFireState() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-ticker.C:
+			if err := cronsun.SaveFireState(); err != nil {
+				log.Warnf("save fire state err: %s", err.Error())
+			}
+		}
+	}
+}
+
+// 定时删除过期的任务输出文件
+func (n *Node) cleanSpillFiles() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		if err := cronsun.CleanSpillFiles(time.Now()); err != nil {
+			log.Warnf("clean job output files err: %s", err.Error())
+		}
+
+		select {
+		case <-n.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 // 启动服务
 func (n *Node) Run() (err error) {
 	go n.keepAlive()
@@ -575,14 +812,31 @@ func (n *Node) Run() (err error) {
 		}
 	}()
 
+	if err = cronsun.LoadFireState(conf.Config.FireStateFile); err != nil {
+		log.Warnf("load fire state from %s err: %s", conf.Config.FireStateFile, err.Error())
+	}
+
+	if err = cronsun.LoadCalendars(); err != nil {
+		return
+	}
+
 	if err = n.loadJobs(); err != nil {
 		return
 	}
 
+	// 补执行结点停止期间错过的任务
+	now := time.Now()
+	for _, cmd := range n.cmds {
+		go cmd.CatchUp(now)
+	}
+
 	n.Cron.Start()
+	go n.saveFireState()
+	go n.cleanSpillFiles()
 	go n.watchJobs()
 	go n.watchExcutingProc()
 	go n.watchGroups()
+	go n.watchCalendars()
 	go n.watchOnce()
 	go n.watchCsctl()
 	n.Node.On()
@@ -596,5 +850,16 @@ func (n *Node) Stop(i interface{}) {
 	n.Node.Del()
 	n.Client.Close()
 	n.Cron.Stop()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, fw := range n.watchers {
+		fw.Stop()
+	}
+	for _, t := range n.expiries {
+		t.Stop()
+	}
+	if err := cronsun.SaveFireState(); err != nil {
+		log.Warnf("save fire state err: %s", err.Error())
+	}
 	n.removePIDFile()
 }
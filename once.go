@@ -1,6 +1,9 @@
 package cronsun
 
 import (
+	"encoding/json"
+	"time"
+
 	client "github.com/coreos/etcd/clientv3"
 
 	"cronsun/conf"
@@ -16,6 +19,126 @@ func PutOnce(group, jobID, nodeID string) error {
 	return err
 }
 
+// 需要记录触发方式时，value 为 json 格式的 Once
+func putOnce(group, jobID string, o *Once) error {
+	b, err := json.Marshal(o)
+	if err != nil {
+		return err
+	}
+
+	_, err = DefalutClient.Put(conf.Config.Once+group+"/"+jobID, string(b))
+	return err
+}
+
 func WatchOnce() client.WatchChan {
 	return DefalutClient.Watch(conf.Config.Once, client.WithPrefix())
 }
+
+// 一次执行请求
+type Once struct {
+	// 执行的结点，为空时 job 所在的结点都需执行
+	NodeID string `json:"node_id,omitempty"`
+	// 手动执行指定的多个结点，不为空时代替 NodeID
+	NodeIDs []string `json:"node_ids,omitempty"`
+	// 触发方式，为空时为手动执行
+	TriggerType string `json:"trigger_type,omitempty"`
+
+	// 任一结点任务调度到 NodeID 的定时触发
+	RuleID         string    `json:"rule_id,omitempty"`
+	FireTime       time.Time `json:"fire_time"`
+	DispatchedBy   string    `json:"dispatched_by,omitempty"`
+	DispatchReason string    `json:"dispatch_reason,omitempty"`
+
+	// 分片任务每个结点执行的分片序号
+	ExecutionID string           `json:"execution_id,omitempty"`
+	Shards      map[string][]int `json:"shards,omitempty"`
+	ShardTotal  int              `json:"shard_total,omitempty"`
+
+	// 手动执行追加在命令后的参数和覆盖的环境变量
+	Args []string  `json:"args,omitempty"`
+	Env  []*JobEnv `json:"env,omitempty"`
+
+	// webhook 触发的来源、调用方的 IP 和请求的 JSON 内容
+	Source   string `json:"source,omitempty"`
+	CallerIP string `json:"caller_ip,omitempty"`
+	Payload  string `json:"payload,omitempty"`
+}
+
+// ParseOnce 解析 once key 的值，兼容只有 NodeID 的格式
+func ParseOnce(value []byte) *Once {
+	o := &Once{}
+	if len(value) > 0 && value[0] == '{' && json.Unmarshal(value, o) == nil {
+		if len(o.TriggerType) == 0 {
+			o.TriggerType = TriggerOnce
+		}
+		return o
+	}
+
+	o.NodeID = string(value)
+	o.TriggerType = TriggerOnce
+	return o
+}
+
+func (o *Once) IsRunOn(nodeID string) bool {
+	if len(o.Shards) > 0 {
+		_, ok := o.Shards[nodeID]
+		return ok
+	}
+	if len(o.NodeIDs) > 0 {
+		for _, id := range o.NodeIDs {
+			if id == nodeID {
+				return true
+			}
+		}
+		return false
+	}
+	return len(o.NodeID) == 0 || o.NodeID == nodeID
+}
+
+// 此次执行的触发信息
+func (o *Once) Trigger() *Trigger {
+	tr := &Trigger{
+		Type:           o.TriggerType,
+		RuleID:         o.RuleID,
+		FireTime:       o.FireTime,
+		DispatchedBy:   o.DispatchedBy,
+		DispatchReason: o.DispatchReason,
+		ExecutionID:    o.ExecutionID,
+		Args:           o.Args,
+		Env:            o.Env,
+		Source:         o.Source,
+		CallerIP:       o.CallerIP,
+		Payload:        o.Payload,
+	}
+	if tr.FireTime.IsZero() {
+		tr.FireTime = time.Now()
+	}
+	return tr
+}
+
+// 分片分配到的结点
+func (o *Once) shardNode(index int) string {
+	for id, shards := range o.Shards {
+		for _, i := range shards {
+			if i == index {
+				return id
+			}
+		}
+	}
+	return ""
+}
+
+// 结点需要执行的触发，分片任务每个分片一个触发
+func (o *Once) Triggers(nodeID string) []*Trigger {
+	if len(o.Shards) == 0 {
+		return []*Trigger{o.Trigger()}
+	}
+
+	trs := make([]*Trigger, 0, len(o.Shards[nodeID]))
+	for _, i := range o.Shards[nodeID] {
+		tr := o.Trigger()
+		tr.ShardIndex, tr.ShardTotal = i, o.ShardTotal
+		trs = append(trs, tr)
+	}
+	return trs
+}
@@ -0,0 +1,25 @@
+package cronsun
+
+import (
+	"testing"
+)
+
+func TestParseOnce(t *testing.T) {
+	tests := []struct {
+		value string
+		node  string
+		typ   string
+	}{
+		{"", "", TriggerOnce},
+		{"node-1", "node-1", TriggerOnce},
+		{`{"trigger_type":"depend"}`, "", TriggerDepend},
+		{`{"node_id":"node-2"}`, "node-2", TriggerOnce},
+	}
+
+	for _, test := range tests {
+		o := ParseOnce([]byte(test.value))
+		if o.NodeID != test.node || o.TriggerType != test.typ {
+			t.Errorf("%q: expected %q %q, got %q %q", test.value, test.node, test.typ, o.NodeID, o.TriggerType)
+		}
+	}
+}
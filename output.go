@@ -0,0 +1,320 @@
+package cronsun
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"cronsun/conf"
+	"cronsun/db/entries"
+	"cronsun/log"
+)
+
+// 任务的输出，内存中只保留开头和结尾部分
+// 输出超出保留长度时，如果设置了 spill 文件，完整的输出会写入文件
+type outputBuffer struct {
+	head    []byte
+	tail    []byte
+	headMax int
+	tailMax int
+	size    int64
+
+	spillPath string
+	spillMax  int64
+	spilled   int64
+	spill     *os.File
+}
+
+func newOutputBuffer(headMax, tailMax int, spillPath string, spillMax int64) *outputBuffer {
+	return &outputBuffer{
+		headMax:   headMax,
+		tailMax:   tailMax,
+		spillPath: spillPath,
+		spillMax:  spillMax,
+	}
+}
+
+// 按配置创建任务输出
+func (j *Job) newOutputBuffer(t time.Time, stream string) *outputBuffer {
+	cf := conf.Config.JobOutput
+	var spillPath string
+	if len(cf.SpillDir) > 0 {
+		spillPath = filepath.Join(cf.SpillDir, fmt.Sprintf("%s_%s_%d.%s", j.Group, j.ID, t.UnixNano(), stream))
+	}
+	return newOutputBuffer(int(cf.HeadKB)<<10, int(cf.TailKB)<<10, spillPath, cf.SpillMaxMB<<20)
+}
+
+// CleanSpillFiles 删除 SpillDir 中超过保留天数的输出文件
+// 文件的总长度超过 SpillDirMaxMB 时，从最早的文件开始删除
+func CleanSpillFiles(now time.Time) error {
+	cf := conf.Config.JobOutput
+	if len(cf.SpillDir) == 0 {
+		return nil
+	}
+	return cleanSpillDir(cf.SpillDir, time.Duration(cf.SpillMaxAgeDays)*24*time.Hour, cf.SpillDirMaxMB<<20, now)
+}
+
+// 只处理任务输出的 .stdout 和 .stderr 文件
+func cleanSpillDir(dir string, maxAge time.Duration, maxSize int64, now time.Time) error {
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var (
+		files []os.FileInfo
+		total int64
+	)
+	for _, de := range des {
+		ext := filepath.Ext(de.Name())
+		if !de.Type().IsRegular() || (ext != ".stdout" && ext != ".stderr") {
+			continue
+		}
+		fi, err := de.Info()
+		if err != nil {
+			continue
+		}
+
+		if maxAge > 0 && now.Sub(fi.ModTime()) > maxAge {
+			removeSpill(filepath.Join(dir, fi.Name()))
+			continue
+		}
+		files = append(files, fi)
+		total += fi.Size()
+	}
+
+	if maxSize <= 0 || total <= maxSize {
+		return nil
+	}
+	sort.Slice(files, func(i, k int) bool { return files[i].ModTime().Before(files[k].ModTime()) })
+	for _, fi := range files {
+		if total <= maxSize {
+			break
+		}
+		removeSpill(filepath.Join(dir, fi.Name()))
+		total -= fi.Size()
+	}
+	return nil
+}
+
+func removeSpill(path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Warnf("failed to remove job output file[%s]: %s", path, err.Error())
+	}
+}
+
+func (b *outputBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	b.size += int64(n)
+	if b.spill != nil {
+		b.writeSpill(p)
+	}
+
+	if len(b.head) < b.headMax {
+		k := b.headMax - len(b.head)
+		if k > len(p) {
+			k = len(p)
+		}
+		b.head = append(b.head, p[:k]...)
+		p = p[k:]
+	}
+
+	if len(p) == 0 {
+		return n, nil
+	}
+
+	// 第一次丢弃输出前，把已有的完整输出写入文件
+	if b.spill == nil && len(b.spillPath) > 0 && len(b.tail)+len(p) > b.tailMax {
+		b.openSpill()
+		b.writeSpill(b.head)
+		b.writeSpill(b.tail)
+		b.writeSpill(p)
+	}
+
+	b.tail = append(b.tail, p...)
+	// 保留最多两倍的长度，减少复制
+	if len(b.tail) > 2*b.tailMax {
+		b.tail = append(b.tail[:0], b.tail[len(b.tail)-b.tailMax:]...)
+	}
+	return n, nil
+}
+
+func (b *outputBuffer) openSpill() {
+	if err := os.MkdirAll(filepath.Dir(b.spillPath), 0755); err != nil {
+		log.Warnf("failed to create job output file[%s]: %s", b.spillPath, err.Error())
+		b.spillPath = ""
+		return
+	}
+
+	f, err := os.OpenFile(b.spillPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		log.Warnf("failed to create job output file[%s]: %s", b.spillPath, err.Error())
+		b.spillPath = ""
+		return
+	}
+	b.spill = f
+}
+
+func (b *outputBuffer) writeSpill(p []byte) {
+	if b.spill == nil {
+		return
+	}
+
+	if b.spillMax > 0 && b.spilled+int64(len(p)) > b.spillMax {
+		p = p[:b.spillMax-b.spilled]
+	}
+	if len(p) == 0 {
+		return
+	}
+
+	n, err := b.spill.Write(p)
+	b.spilled += int64(n)
+	if err != nil {
+		log.Warnf("failed to write job output file[%s]: %s", b.spillPath, err.Error())
+		b.Close()
+		b.spillPath = ""
+	}
+}
+
+// 被丢弃的输出长度
+func (b *outputBuffer) Truncated() int64 {
+	if n := b.size - int64(len(b.head)) - int64(b.tailMax); n > 0 {
+		return n
+	}
+	return 0
+}
+
+func (b *outputBuffer) Size() int64 {
+	return b.size
+}
+
+// 写入完整输出的文件路径，输出没有超出保留长度时为空
+func (b *outputBuffer) File() string {
+	if b.spilled == 0 {
+		return ""
+	}
+	return b.spillPath
+}
+
+func (b *outputBuffer) String() string {
+	tail := b.tail
+	if len(tail) > b.tailMax {
+		tail = tail[len(tail)-b.tailMax:]
+	}
+
+	n := b.Truncated()
+	if n == 0 {
+		return string(b.head) + string(tail)
+	}
+	return fmt.Sprintf("%s\n... [%d bytes truncated] ...\n%s", b.head, n, tail)
+}
+
+func (b *outputBuffer) Close() {
+	if b.spill == nil {
+		return
+	}
+
+	if err := b.spill.Close(); err != nil {
+		log.Warnf("failed to close job output file[%s]: %s", b.spillPath, err.Error())
+	}
+	b.spill = nil
+}
+
+// 任务执行结果，用于记录 job log
+type ExecResult struct {
+	BeginTime time.Time
+	Success   bool
+
+	Output     string // 标准输出，执行出错时附加错误信息
+	Stderr     string
+	OutputSize int64 // 标准输出的完整长度
+	StderrSize int64
+	OutputFile string // 写入完整输出的文件
+	StderrFile string
+	Truncated  bool
+	TimedOut   bool          // 是否因超时被结束
+	StartError bool          // 是否启动失败
+	Skipped    bool          // 是否跳过了本次执行
+	ExitCode   int           // 进程退出码，没有正常退出时为 -1
+	Signal     string        // 结束进程的信号
+	UserTime   time.Duration // 用户态 CPU 时间
+	SysTime    time.Duration // 内核态 CPU 时间
+	MaxRSS     int64         // 最大常驻内存，单位 KB
+
+	WindowClosed bool // 是否因执行时间段结束被结束
+
+	HTTPStatus  int                 // HTTP 任务的响应状态码，没有收到响应时为 0
+	HTTPHeaders map[string][]string // HTTP 任务的响应头
+	HTTPTiming  *entries.HTTPTiming // HTTP 任务各阶段的耗时
+
+	exited bool // 是否取得了进程的退出状态
+}
+
+func newExecResult(t time.Time, stdout, stderr *outputBuffer, ps *os.ProcessState, err error) *ExecResult {
+	stdout.Close()
+	stderr.Close()
+
+	r := &ExecResult{
+		BeginTime:  t,
+		Success:    err == nil,
+		ExitCode:   -1,
+		Output:     stdout.String(),
+		Stderr:     stderr.String(),
+		OutputSize: stdout.Size(),
+		StderrSize: stderr.Size(),
+		OutputFile: stdout.File(),
+		StderrFile: stderr.File(),
+		Truncated:  stdout.Truncated() > 0 || stderr.Truncated() > 0,
+	}
+	if ps != nil {
+		r.exited = true
+		r.ExitCode = ps.ExitCode()
+		r.UserTime = ps.UserTime()
+		r.SysTime = ps.SystemTime()
+		if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			r.Signal = signalName(ws.Signal())
+		}
+		if ru, ok := ps.SysUsage().(*syscall.Rusage); ok {
+			r.MaxRSS = ru.Maxrss
+		}
+	}
+	if err != nil {
+		r.Output = fmt.Sprintf("%s\n%s", r.Output, err.Error())
+	}
+	return r
+}
+
+// 用于通知的执行信息
+func (r *ExecResult) Message() string {
+	if len(r.Stderr) == 0 {
+		return r.Output
+	}
+	return r.Output + "\nStderr:\n" + r.Stderr
+}
+
+// 进程的退出状态和资源使用情况，进程没有启动时为空
+// HTTP 任务为响应状态码和耗时
+func (r *ExecResult) Status() string {
+	if r.HTTPStatus > 0 {
+		s := fmt.Sprintf("http status: %d", r.HTTPStatus)
+		if r.HTTPTiming != nil {
+			s += fmt.Sprintf(", first byte: %dms, total: %dms", r.HTTPTiming.FirstByte, r.HTTPTiming.Total)
+		}
+		return s
+	}
+	if !r.exited {
+		return ""
+	}
+
+	s := fmt.Sprintf("exit code: %d", r.ExitCode)
+	if len(r.Signal) > 0 {
+		s += ", signal: " + r.Signal
+	}
+	return s + fmt.Sprintf(", user: %s, sys: %s, max rss: %dKB", r.UserTime, r.SysTime, r.MaxRSS)
+}
@@ -0,0 +1,184 @@
+package cronsun
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutputBuffer(t *testing.T) {
+	tests := []struct {
+		writes    []string
+		expected  string
+		truncated int64
+	}{
+		{[]string{"abc"}, "abc", 0},
+		{[]string{"abcd", "efgh"}, "abcdefgh", 0},
+		{[]string{"abcdefghij"}, "abcd\n... [2 bytes truncated] ...\nghij", 2},
+		{[]string{"ab", "cdefg", "hij", "klmnop"}, "abcd\n... [8 bytes truncated] ...\nmnop", 8},
+	}
+
+	for _, test := range tests {
+		b := newOutputBuffer(4, 4, "", 0)
+		for _, w := range test.writes {
+			b.Write([]byte(w))
+		}
+
+		if b.String() != test.expected {
+			t.Errorf("%v: expected %q, got %q", test.writes, test.expected, b.String())
+		}
+		if b.Truncated() != test.truncated {
+			t.Errorf("%v: expected %d bytes truncated, got %d", test.writes, test.truncated, b.Truncated())
+		}
+	}
+}
+
+func TestOutputBufferSpill(t *testing.T) {
+	dir, err := os.MkdirTemp("", "cronsun")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	full := strings.Repeat("0123456789", 10)
+	path := filepath.Join(dir, "out", "job.stdout")
+	b := newOutputBuffer(8, 8, path, 0)
+	for i := 0; i < len(full); i += 7 {
+		end := i + 7
+		if end > len(full) {
+			end = len(full)
+		}
+		b.Write([]byte(full[i:end]))
+	}
+	b.Close()
+
+	if b.File() != path {
+		t.Fatalf("expected output file %s, got %s", path, b.File())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != full {
+		t.Errorf("expected full output in file, got %q", data)
+	}
+
+	// 没有超出长度时不写文件
+	path = filepath.Join(dir, "short.stdout")
+	b = newOutputBuffer(8, 8, path, 0)
+	b.Write([]byte("0123456789"))
+	b.Close()
+	if b.File() != "" {
+		t.Errorf("expected no output file, got %s", b.File())
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected %s not exist, got %v", path, err)
+	}
+
+	// 文件长度限制
+	path = filepath.Join(dir, "limit.stdout")
+	b = newOutputBuffer(2, 2, path, 6)
+	b.Write([]byte(full))
+	b.Close()
+	data, _ = os.ReadFile(path)
+	if string(data) != full[:6] {
+		t.Errorf("expected %q in file, got %q", full[:6], data)
+	}
+}
+
+func TestExecResultStatus(t *testing.T) {
+	tests := []struct {
+		script   string
+		exitCode int
+		signal   string
+	}{
+		{"exit 0", 0, ""},
+		{"exit 3", 3, ""},
+		{"kill -TERM $$", -1, "SIGTERM"},
+	}
+
+	for _, test := range tests {
+		stdout, stderr := newOutputBuffer(8, 8, "", 0), newOutputBuffer(8, 8, "", 0)
+		cmd := exec.Command("sh", "-c", test.script)
+		err := cmd.Run()
+
+		r := newExecResult(time.Now(), stdout, stderr, cmd.ProcessState, err)
+		if r.ExitCode != test.exitCode || r.Signal != test.signal {
+			t.Errorf("%q: expected exit code %d signal %q, got %d %q", test.script, test.exitCode, test.signal, r.ExitCode, r.Signal)
+		}
+		if r.Success != (test.exitCode == 0) {
+			t.Errorf("%q: expected success %v", test.script, test.exitCode == 0)
+		}
+		if r.MaxRSS <= 0 {
+			t.Errorf("%q: expected max rss, got %d", test.script, r.MaxRSS)
+		}
+	}
+
+	r := newExecResult(time.Now(), newOutputBuffer(8, 8, "", 0), newOutputBuffer(8, 8, "", 0), nil, os.ErrNotExist)
+	if r.ExitCode != -1 || r.Status() != "" {
+		t.Errorf("expected no process status, got %d %q", r.ExitCode, r.Status())
+	}
+}
+
+func TestCleanSpillDir(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	files := []struct {
+		name string
+		size int
+		age  time.Duration
+	}{
+		{"g_a_1.stdout", 10, 10 * 24 * time.Hour},
+		{"g_a_1.stderr", 10, 3 * time.Hour},
+		{"g_b_2.stdout", 10, 2 * time.Hour},
+		{"g_b_3.stdout", 10, time.Hour},
+		{"notes.txt", 10, 10 * 24 * time.Hour},
+	}
+	for _, f := range files {
+		path := filepath.Join(dir, f.name)
+		if err := os.WriteFile(path, make([]byte, f.size), 0640); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, now.Add(-f.age), now.Add(-f.age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	left := func() []string {
+		des, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, de := range des {
+			names = append(names, de.Name())
+		}
+		return names
+	}
+
+	// 超过保留时间的文件被删除，其它文件不处理
+	if err := cleanSpillDir(dir, 7*24*time.Hour, 0, now); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"g_a_1.stderr", "g_b_2.stdout", "g_b_3.stdout", "notes.txt"}
+	if names := left(); !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+
+	// 超过总长度时从最早的文件开始删除
+	if err := cleanSpillDir(dir, 0, 15, now); err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{"g_b_3.stdout", "notes.txt"}
+	if names := left(); !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+
+	if err := cleanSpillDir(filepath.Join(dir, "missing"), time.Hour, 0, now); err != nil {
+		t.Errorf("expected no error for missing dir, got %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package cronsun
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"cronsun/log"
+)
+
+// 同一规则上一次执行还未结束时，再次触发的处理方式
+const (
+	OverlapAllow   = "allow"   // 同时执行，默认
+	OverlapSkip    = "skip"    // 跳过本次执行，记录为 skipped
+	OverlapQueue   = "queue"   // 上一次执行结束后马上执行，最多排队一次
+	OverlapReplace = "replace" // 结束上一次执行，重新开始
+)
+
+// 每个规则正在执行和排队的任务
+type ruleRuns struct {
+	running []*Trigger
+	queued  *Trigger
+}
+
+var overlaps = struct {
+	sync.Mutex
+	runs map[string]*ruleRuns
+}{runs: make(map[string]*ruleRuns)}
+
+func (j *Job) checkOverlap() error {
+	j.OverlapPolicy = strings.ToLower(strings.TrimSpace(j.OverlapPolicy))
+	switch j.OverlapPolicy {
+	case "":
+		j.OverlapPolicy = OverlapAllow
+	case OverlapAllow, OverlapSkip, OverlapQueue, OverlapReplace:
+	default:
+		return ErrIllegalOverlapPolicy
+	}
+	return nil
+}
+
+// 按 OverlapPolicy 处理本次触发，返回 true 时可以执行
+// 执行结束后必须调用 endOverlap
+func (c *Cmd) beginOverlap(tr *Trigger) bool {
+	id := c.GetID()
+	tr.done = make(chan struct{})
+
+	overlaps.Lock()
+	r, ok := overlaps.runs[id]
+	if !ok {
+		r = &ruleRuns{}
+		overlaps.runs[id] = r
+	}
+
+	if len(r.running) == 0 || c.Job.OverlapPolicy == OverlapAllow {
+		r.running = append(r.running, tr)
+		overlaps.Unlock()
+		return true
+	}
+
+	switch c.Job.OverlapPolicy {
+	case OverlapQueue:
+		if r.queued == nil {
+			r.queued = tr
+			overlaps.Unlock()
+			return false
+		}
+	case OverlapReplace:
+		running := append([]*Trigger{}, r.running...)
+		r.running = append(r.running, tr)
+		overlaps.Unlock()
+
+		for _, prev := range running {
+			if pid := prev.cancel("replaced by a new run"); pid > 0 {
+				go func(pid int) {
+					if err := c.Job.Kill(pid); err != nil {
+						log.Warnf("job[%s] kill replaced process[%d] err: %s", c.Job.Key(), pid, err.Error())
+					}
+				}(pid)
+			}
+		}
+		for _, prev := range running {
+			<-prev.done
+		}
+		return true
+	}
+
+	overlaps.Unlock()
+	c.Job.Skip(tr, fmt.Sprintf("job[%s] rule[%s] skipped on[%s], previous run is still running", c.Job.Key(), c.JobRule.ID, c.Job.runOn))
+	return false
+}
+
+// 结束本次执行，返回排队等待执行的触发
+func (c *Cmd) endOverlap(tr *Trigger) *Trigger {
+	id := c.GetID()
+	close(tr.done)
+
+	overlaps.Lock()
+	defer overlaps.Unlock()
+	r, ok := overlaps.runs[id]
+	if !ok {
+		return nil
+	}
+
+	for i := range r.running {
+		if r.running[i] == tr {
+			r.running = append(r.running[:i], r.running[i+1:]...)
+			break
+		}
+	}
+
+	if next := r.queued; next != nil && len(r.running) == 0 {
+		r.queued = nil
+		r.running = append(r.running, next)
+		return next
+	}
+
+	if len(r.running) == 0 && r.queued == nil {
+		delete(overlaps.runs, id)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package cronsun
+
+import (
+	"testing"
+	"time"
+)
+
+func newOverlapCmd(policy string) *Cmd {
+	return &Cmd{
+		Job:     &Job{ID: "overlap", Group: "test", OverlapPolicy: policy},
+		JobRule: &JobRule{ID: policy},
+	}
+}
+
+func TestOverlapAllow(t *testing.T) {
+	c := newOverlapCmd(OverlapAllow)
+	tr1, tr2 := &Trigger{}, &Trigger{}
+	if !c.beginOverlap(tr1) || !c.beginOverlap(tr2) {
+		t.Fatal("expected both runs allowed")
+	}
+	if next := c.endOverlap(tr1); next != nil {
+		t.Errorf("expected no queued run, got %v", next)
+	}
+	c.endOverlap(tr2)
+
+	if _, ok := overlaps.runs[c.GetID()]; ok {
+		t.Errorf("expected runs of %s cleaned", c.GetID())
+	}
+}
+
+func TestOverlapQueue(t *testing.T) {
+	c := newOverlapCmd(OverlapQueue)
+	tr1, tr2 := &Trigger{}, &Trigger{}
+	if !c.beginOverlap(tr1) {
+		t.Fatal("expected first run allowed")
+	}
+	if c.beginOverlap(tr2) {
+		t.Fatal("expected second run queued")
+	}
+	if next := c.endOverlap(tr1); next != tr2 {
+		t.Fatalf("expected queued run after the first one, got %v", next)
+	}
+	if next := c.endOverlap(tr2); next != nil {
+		t.Errorf("expected no queued run, got %v", next)
+	}
+}
+
+func TestOverlapReplace(t *testing.T) {
+	c := newOverlapCmd(OverlapReplace)
+	tr1, tr2 := &Trigger{}, &Trigger{}
+	if !c.beginOverlap(tr1) {
+		t.Fatal("expected first run allowed")
+	}
+
+	replaced := make(chan bool)
+	go func() {
+		replaced <- c.beginOverlap(tr2)
+	}()
+
+	select {
+	case <-replaced:
+		t.Fatal("expected replacing run wait for the previous one")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if !tr1.isCanceled() {
+		t.Error("expected previous run canceled")
+	}
+
+	c.endOverlap(tr1)
+	if ok := <-replaced; !ok {
+		t.Fatal("expected replacing run allowed")
+	}
+	c.endOverlap(tr2)
+}
+
+func TestOverlapReplaceWaits(t *testing.T) {
+	c := newOverlapCmd(OverlapReplace)
+	c.Job.ConcurrencyPolicy, c.Job.ConcurrencyWait = ConcurrencyWait, 600
+	tr1, tr2 := &Trigger{}, &Trigger{}
+	if !c.beginOverlap(tr1) {
+		t.Fatal("expected first run allowed")
+	}
+
+	// 被替换的执行不再等待重试间隔和信号量
+	slept := make(chan bool)
+	go func() {
+		slept <- tr1.sleep(time.Minute)
+	}()
+	ctx, cancel := c.Job.semaphoreContext(tr1)
+	defer cancel()
+
+	go func() {
+		c.beginOverlap(tr2)
+	}()
+	select {
+	case ok := <-slept:
+		if ok {
+			t.Error("expected retry sleep interrupted")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected retry sleep ended by replacing run")
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected semaphore wait ended by replacing run")
+	}
+
+	c.endOverlap(tr1)
+	c.endOverlap(tr2)
+
+	// 已取消后不再等待
+	if tr1.sleep(time.Minute) {
+		t.Error("expected no sleep after canceled")
+	}
+}
+
+func TestCheckOverlap(t *testing.T) {
+	j := &Job{}
+	if err := j.checkOverlap(); err != nil || j.OverlapPolicy != OverlapAllow {
+		t.Errorf("expected default policy %s, got %s %v", OverlapAllow, j.OverlapPolicy, err)
+	}
+
+	j.OverlapPolicy = "Queue "
+	if err := j.checkOverlap(); err != nil || j.OverlapPolicy != OverlapQueue {
+		t.Errorf("expected policy %s, got %s %v", OverlapQueue, j.OverlapPolicy, err)
+	}
+
+	j.OverlapPolicy = "wait"
+	if err := j.checkOverlap(); err != ErrIllegalOverlapPolicy {
+		t.Errorf("expected %v, got %v", ErrIllegalOverlapPolicy, err)
+	}
+}
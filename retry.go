@@ -0,0 +1,145 @@
+package cronsun
+
+import (
+	"math/rand"
+	"time"
+)
+
+// 重试间隔的增长方式
+const (
+	BackoffFixed       = "fixed"       // 固定间隔
+	BackoffLinear      = "linear"      // 间隔随重试次数线性增长
+	BackoffExponential = "exponential" // 间隔随重试次数成倍增长
+)
+
+// 需要重试的失败类型
+const (
+	RetryOnExit    = "exit"    // 进程以非 0 退出码退出或被信号结束，HTTP 任务的响应不符合预期
+	RetryOnTimeout = "timeout" // 执行超时
+	RetryOnStart   = "start"   // 启动失败，HTTP 任务没有收到响应
+)
+
+// 任务失败重试策略
+// 重试次数和基础间隔使用 Job.Retry 和 Job.Interval
+type RetryPolicy struct {
+	// 重试间隔的增长方式，默认 fixed
+	Backoff string `json:"backoff"`
+	// 在计算出的间隔上增加随机时间，避免多个结点同时重试
+	Jitter bool `json:"jitter"`
+	// 最大重试间隔，单位秒，0 为不限制
+	MaxDelay int64 `json:"max_delay"`
+	// 需要重试的失败类型，为空时所有失败都重试
+	RetryOn []string `json:"retry_on"`
+	// 需要重试的退出码，为空时所有非 0 退出码都重试
+	// HTTP 任务为响应状态码，如 502, 503
+	ExitCodes []int `json:"exit_codes"`
+}
+
+func (p *RetryPolicy) check() error {
+	if p == nil {
+		return nil
+	}
+
+	switch p.Backoff {
+	case "":
+		p.Backoff = BackoffFixed
+	case BackoffFixed, BackoffLinear, BackoffExponential:
+	default:
+		return ErrIllegalRetryPolicy
+	}
+
+	if p.MaxDelay < 0 {
+		return ErrIllegalRetryPolicy
+	}
+
+	for _, on := range p.RetryOn {
+		switch on {
+		case RetryOnExit, RetryOnTimeout, RetryOnStart:
+		default:
+			return ErrIllegalRetryPolicy
+		}
+	}
+
+	return nil
+}
+
+func (p *RetryPolicy) retryOn(kind string) bool {
+	if len(p.RetryOn) == 0 {
+		return true
+	}
+
+	for _, on := range p.RetryOn {
+		if on == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// 执行结果是否需要重试
+func (p *RetryPolicy) retryable(r *ExecResult) bool {
+	// 执行时间段已结束，重试也不会执行
+	if r.Success || r.WindowClosed {
+		return false
+	}
+	if p == nil {
+		return true
+	}
+
+	switch {
+	case r.StartError:
+		return p.retryOn(RetryOnStart)
+	case r.TimedOut:
+		return p.retryOn(RetryOnTimeout)
+	}
+
+	if !p.retryOn(RetryOnExit) {
+		return false
+	}
+	if len(p.ExitCodes) == 0 {
+		return true
+	}
+	for _, code := range p.ExitCodes {
+		if code == r.ExitCode {
+			return true
+		}
+	}
+	return false
+}
+
+// 第 attempt 次执行失败后，到下次重试的等待时间
+// interval 为任务设置的基础间隔，单位秒
+func (p *RetryPolicy) delay(interval, attempt int) time.Duration {
+	base := time.Duration(interval) * time.Second
+	if p == nil {
+		return base
+	}
+
+	if base <= 0 && p.Backoff != BackoffFixed {
+		base = time.Second
+	}
+
+	d := base
+	switch p.Backoff {
+	case BackoffLinear:
+		d = base * time.Duration(attempt)
+	case BackoffExponential:
+		// 避免溢出
+		n := attempt - 1
+		if n > 30 {
+			n = 30
+		}
+		d = base << uint(n)
+	}
+
+	max := time.Duration(p.MaxDelay) * time.Second
+	if max > 0 && d > max {
+		d = max
+	}
+
+	// 在 [d/2, d] 之间取随机值
+	if p.Jitter && d > 1 {
+		d = d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+	}
+	return d
+}
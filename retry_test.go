@@ -0,0 +1,84 @@
+package cronsun
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	tests := []struct {
+		policy   *RetryPolicy
+		interval int
+		attempt  int
+		expected time.Duration
+	}{
+		{nil, 5, 3, 5 * time.Second},
+		{&RetryPolicy{Backoff: BackoffFixed}, 0, 3, 0},
+		{&RetryPolicy{Backoff: BackoffLinear}, 5, 3, 15 * time.Second},
+		{&RetryPolicy{Backoff: BackoffLinear}, 0, 2, 2 * time.Second},
+		{&RetryPolicy{Backoff: BackoffExponential}, 5, 1, 5 * time.Second},
+		{&RetryPolicy{Backoff: BackoffExponential}, 5, 4, 40 * time.Second},
+		{&RetryPolicy{Backoff: BackoffExponential, MaxDelay: 30}, 5, 4, 30 * time.Second},
+		{&RetryPolicy{Backoff: BackoffExponential}, 1, 100, time.Second << 30},
+	}
+
+	for i, test := range tests {
+		if d := test.policy.delay(test.interval, test.attempt); d != test.expected {
+			t.Errorf("#%d: expected %s, got %s", i, test.expected, d)
+		}
+	}
+
+	p := &RetryPolicy{Backoff: BackoffFixed, Jitter: true}
+	for i := 0; i < 100; i++ {
+		if d := p.delay(10, 1); d < 5*time.Second || d > 10*time.Second {
+			t.Fatalf("expected jitter delay in [5s, 10s], got %s", d)
+		}
+	}
+}
+
+func TestRetryPolicyRetryable(t *testing.T) {
+	exit1 := &ExecResult{ExitCode: 1}
+	exit2 := &ExecResult{ExitCode: 2}
+	timeout := &ExecResult{ExitCode: -1, TimedOut: true}
+	start := &ExecResult{ExitCode: -1, StartError: true}
+
+	tests := []struct {
+		policy   *RetryPolicy
+		r        *ExecResult
+		expected bool
+	}{
+		{nil, &ExecResult{Success: true}, false},
+		{nil, exit1, true},
+		{nil, timeout, true},
+		{&RetryPolicy{}, start, true},
+		{&RetryPolicy{RetryOn: []string{RetryOnTimeout}}, exit1, false},
+		{&RetryPolicy{RetryOn: []string{RetryOnTimeout}}, timeout, true},
+		{&RetryPolicy{RetryOn: []string{RetryOnExit}}, start, false},
+		{&RetryPolicy{ExitCodes: []int{2}}, exit1, false},
+		{&RetryPolicy{ExitCodes: []int{2}}, exit2, true},
+		{&RetryPolicy{ExitCodes: []int{2}}, timeout, true},
+	}
+
+	for i, test := range tests {
+		if ok := test.policy.retryable(test.r); ok != test.expected {
+			t.Errorf("#%d: expected %v, got %v", i, test.expected, ok)
+		}
+	}
+}
+
+func TestRetryPolicyCheck(t *testing.T) {
+	p := &RetryPolicy{}
+	if err := p.check(); err != nil || p.Backoff != BackoffFixed {
+		t.Errorf("expected default backoff %s, got %s %v", BackoffFixed, p.Backoff, err)
+	}
+
+	for _, p := range []*RetryPolicy{
+		{Backoff: "random"},
+		{MaxDelay: -1},
+		{RetryOn: []string{"signal"}},
+	} {
+		if err := p.check(); err != ErrIllegalRetryPolicy {
+			t.Errorf("%+v: expected %v, got %v", p, ErrIllegalRetryPolicy, err)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package cronsun
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"cronsun/conf"
+)
+
+// 脚本任务支持的解释器
+const (
+	InterpreterSh      = "sh"
+	InterpreterBash    = "bash"
+	InterpreterPython  = "python"
+	InterpreterPython3 = "python3"
+)
+
+// 保存在任务中的脚本，每次执行时写入临时文件
+type JobScript struct {
+	// 解释器，sh, bash, python 或 python3，默认 sh
+	Interpreter string `json:"interpreter"`
+	Content     string `json:"content"`
+	// 脚本内容的 SHA-256，保存任务时计算，执行前校验
+	SHA256 string `json:"sha256"`
+}
+
+func scriptSHA256(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *JobScript) check() error {
+	if s == nil || len(strings.TrimSpace(s.Content)) == 0 {
+		return ErrEmptyJobScript
+	}
+
+	s.Interpreter = strings.ToLower(strings.TrimSpace(s.Interpreter))
+	switch s.Interpreter {
+	case "":
+		s.Interpreter = InterpreterSh
+	case InterpreterSh, InterpreterBash, InterpreterPython, InterpreterPython3:
+	default:
+		return ErrIllegalInterpreter
+	}
+
+	s.SHA256 = scriptSHA256(s.Content)
+	return nil
+}
+
+// 开启安全选项时，解释器需要在 Security.Interpreters 中
+func (s *JobScript) validInterpreter() bool {
+	if s == nil {
+		return false
+	}
+	if len(conf.Config.Security.Interpreters) == 0 {
+		return true
+	}
+
+	for _, i := range conf.Config.Security.Interpreters {
+		if s.Interpreter == i {
+			return true
+		}
+	}
+	return false
+}
+
+// 把脚本写入只有执行用户可以读写的临时文件，返回执行脚本的命令
+// 执行结束后需要删除返回的文件
+func (s *JobScript) materialize(j *Job, attr *syscall.SysProcAttr) (cmd []string, file string, err error) {
+	if scriptSHA256(s.Content) != s.SHA256 {
+		return nil, "", fmt.Errorf("script of job[%s] does not match its sha256 %s", j.Key(), s.SHA256)
+	}
+
+	interpreter, err := exec.LookPath(s.Interpreter)
+	if err != nil {
+		return nil, "", err
+	}
+
+	f, err := os.CreateTemp("", fmt.Sprintf("cronsun_%s_%s_*", j.Group, j.ID))
+	if err != nil {
+		return nil, "", err
+	}
+	file = f.Name()
+	defer func() {
+		if err != nil {
+			os.Remove(file)
+		}
+	}()
+
+	_, err = f.WriteString(s.Content)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	// 以其它用户执行时，临时文件属于执行用户
+	if attr != nil && attr.Credential != nil {
+		if err = os.Chown(file, int(attr.Credential.Uid), int(attr.Credential.Gid)); err != nil {
+			return nil, "", err
+		}
+	}
+
+	return []string{interpreter, file}, file, nil
+}
@@ -0,0 +1,90 @@
+package cronsun
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cronsun/conf"
+)
+
+func TestJobScriptCheck(t *testing.T) {
+	tests := []struct {
+		script      *JobScript
+		interpreter string
+		err         error
+	}{
+		{nil, "", ErrEmptyJobScript},
+		{&JobScript{Content: " \n"}, "", ErrEmptyJobScript},
+		{&JobScript{Content: "echo hi"}, InterpreterSh, nil},
+		{&JobScript{Content: "print(1)", Interpreter: " Python3 "}, InterpreterPython3, nil},
+		{&JobScript{Content: "puts 1", Interpreter: "ruby"}, "", ErrIllegalInterpreter},
+	}
+
+	for i, test := range tests {
+		if err := test.script.check(); err != test.err {
+			t.Errorf("#%d: expected %v, got %v", i, test.err, err)
+			continue
+		}
+		if test.err != nil {
+			continue
+		}
+		if test.script.Interpreter != test.interpreter {
+			t.Errorf("#%d: expected interpreter %q, got %q", i, test.interpreter, test.script.Interpreter)
+		}
+		if test.script.SHA256 != scriptSHA256(test.script.Content) || len(test.script.SHA256) != 64 {
+			t.Errorf("#%d: unexpected sha256 %q", i, test.script.SHA256)
+		}
+	}
+}
+
+func TestJobScriptValidInterpreter(t *testing.T) {
+	security := conf.Config.Security
+	defer func() { conf.Config.Security = security }()
+
+	s := &JobScript{Interpreter: InterpreterPython}
+	conf.Config.Security = &conf.Security{Open: true}
+	if !s.validInterpreter() {
+		t.Errorf("expected any interpreter allowed without whitelist")
+	}
+	conf.Config.Security.Interpreters = []string{InterpreterSh, InterpreterBash}
+	if s.validInterpreter() {
+		t.Errorf("expected %s not allowed", s.Interpreter)
+	}
+}
+
+func TestJobScriptMaterialize(t *testing.T) {
+	j := &Job{ID: "script", Group: "test", Type: JobTypeScript, Script: &JobScript{Content: "echo done"}}
+	if err := j.Script.check(); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd, file, err := j.Script.materialize(j, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file)
+
+	if len(cmd) != 2 || filepath.Base(cmd[0]) != InterpreterSh || cmd[1] != file {
+		t.Errorf("unexpected command %v", cmd)
+	}
+	if !strings.HasPrefix(filepath.Base(file), "cronsun_test_script_") {
+		t.Errorf("unexpected script file %s", file)
+	}
+	fi, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("expected script file mode 0600, got %v", fi.Mode().Perm())
+	}
+	if b, _ := os.ReadFile(file); string(b) != j.Script.Content {
+		t.Errorf("unexpected script content %q", b)
+	}
+
+	j.Script.Content = "echo changed"
+	if _, _, err = j.Script.materialize(j, nil); err == nil || !strings.Contains(err.Error(), "sha256") {
+		t.Errorf("expected sha256 mismatch, got %v", err)
+	}
+}
@@ -0,0 +1,282 @@
+package cronsun
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	client "github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+
+	"cronsun/conf"
+	"cronsun/log"
+)
+
+// 集群并发数已满时的处理方式
+const (
+	ConcurrencySkip = "skip" // 跳过本次执行
+	ConcurrencyWait = "wait" // 等待其它结点执行完毕
+)
+
+// 信号量 lease 的过期时间，单位秒
+// 结点异常退出后，占用的信号量在过期后释放
+const semaphoreTtl = 10
+
+// 续租的间隔和续租出错后重试的间隔
+var (
+	semaphoreKeepAlive = semaphoreTtl * time.Second / 3
+	semaphoreRetry     = time.Second
+)
+
+// 信号量的持有者和等待者
+// 注册到 /cronsun/lock/sem/<jobID>/<leaseID>
+type SemaphoreHolder struct {
+	Node     string    `json:"node"`
+	Hostname string    `json:"hostname"`
+	IP       string    `json:"ip"`
+	RuleID   string    `json:"rule_id,omitempty"`
+	Trigger  string    `json:"trigger"`
+	Time     time.Time `json:"time"`
+	// 是否已取得信号量，为 false 时在等待
+	Holding bool `json:"holding"`
+}
+
+func SemaphoreKey(jobID string) string {
+	return conf.Config.Lock + "sem/" + jobID + "/"
+}
+
+// 集群范围的任务信号量
+// 每次执行写入一个带 lease 的 key，按创建顺序排在前 MaxConcurrency 个的取得信号量
+type semaphore struct {
+	prefix string
+	key    string
+	lID    client.LeaseID
+
+	done chan struct{}
+	// lease 过期后关闭，信号量可能已被其它结点占用
+	lost chan struct{}
+	// lease 过期时取消的执行
+	job *Job
+	tr  *Trigger
+}
+
+func (j *Job) checkConcurrency() error {
+	if j.MaxConcurrency < 0 {
+		j.MaxConcurrency = 0
+	}
+	if j.ConcurrencyWait < 0 {
+		j.ConcurrencyWait = 0
+	}
+
+	switch j.ConcurrencyPolicy {
+	case "":
+		j.ConcurrencyPolicy = ConcurrencySkip
+	case ConcurrencySkip, ConcurrencyWait:
+	default:
+		return ErrIllegalConcurrencyPolicy
+	}
+	return nil
+}
+
+// 取得集群信号量，没有设置集群并发数时返回 nil, true
+// 未取得时记录原因，返回 false
+func (j *Job) acquireSemaphore(tr *Trigger) (*semaphore, bool) {
+	if j.MaxConcurrency <= 0 {
+		return nil, true
+	}
+
+	s, err := j.newSemaphore(tr)
+	if err != nil {
+		j.Fail(tr, time.Now(), fmt.Sprintf("job[%s] acquire semaphore err: %s", j.Key(), err.Error()))
+		return nil, false
+	}
+
+	ctx, cancel := j.semaphoreContext(tr)
+	defer cancel()
+
+	ok, err := s.acquire(ctx, j.MaxConcurrency, j.ConcurrencyPolicy == ConcurrencyWait)
+	if ok {
+		return s, true
+	}
+
+	s.release()
+	switch {
+	case tr.isCanceled():
+		j.Skip(tr, fmt.Sprintf("job[%s] running on[%s] %s", j.Key(), j.runOn, tr.cancelReason()))
+	case err == nil:
+		j.Skip(tr, fmt.Sprintf("job[%s] skipped on[%s], max concurrency[%d] reached", j.Key(), j.runOn, j.MaxConcurrency))
+	case err == context.DeadlineExceeded:
+		j.Fail(tr, time.Now(), fmt.Sprintf("job[%s] running on[%s] wait for semaphore timeout after %ds, max concurrency[%d]",
+			j.Key(), j.runOn, j.ConcurrencyWait, j.MaxConcurrency))
+	default:
+		j.Fail(tr, time.Now(), fmt.Sprintf("job[%s] acquire semaphore err: %s", j.Key(), err.Error()))
+	}
+	return nil, false
+}
+
+// 等待信号量的 context，超过等待时间或者执行被取消时结束
+func (j *Job) semaphoreContext(tr *Trigger) (ctx context.Context, cancel context.CancelFunc) {
+	if j.ConcurrencyPolicy == ConcurrencyWait && j.ConcurrencyWait > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), time.Duration(j.ConcurrencyWait)*time.Second)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+
+	go func() {
+		select {
+		case <-tr.canceledC():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return
+}
+
+func (j *Job) newSemaphore(tr *Trigger) (*semaphore, error) {
+	resp, err := DefalutClient.Grant(semaphoreTtl)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &semaphore{
+		prefix: SemaphoreKey(j.ID),
+		lID:    resp.ID,
+		done:   make(chan struct{}),
+		lost:   make(chan struct{}),
+		job:    j,
+		tr:     tr,
+	}
+	s.key = fmt.Sprintf("%s%x", s.prefix, s.lID)
+	go s.keepAlive(semaphoreKeepAlive, semaphoreRetry)
+
+	b, err := json.Marshal(&SemaphoreHolder{
+		Node:     j.runOn,
+		Hostname: j.hostname,
+		IP:       j.ip,
+		RuleID:   tr.RuleID,
+		Trigger:  tr.Type,
+		Time:     time.Now(),
+	})
+	if err == nil {
+		_, err = DefalutClient.Put(s.key, string(b), client.WithLease(s.lID))
+	}
+	if err != nil {
+		s.release()
+		return nil, err
+	}
+	return s, nil
+}
+
+// 检查是否取得信号量，wait 为 true 时等待其它持有者释放，直到 ctx 结束
+// 不等待且信号量已满时返回 false, nil
+func (s *semaphore) acquire(ctx context.Context, max int64, wait bool) (bool, error) {
+	for {
+		resp, err := DefalutClient.Get(s.prefix, client.WithPrefix(), client.WithKeysOnly(),
+			client.WithSort(client.SortByCreateRevision, client.SortAscend), client.WithLimit(max))
+		if err != nil {
+			return false, err
+		}
+
+		for _, kv := range resp.Kvs {
+			if string(kv.Key) == s.key {
+				return true, nil
+			}
+		}
+
+		if !wait {
+			return false, nil
+		}
+
+		if err = s.waitRelease(ctx, resp.Header.Revision+1); err != nil {
+			return false, err
+		}
+	}
+}
+
+// 等待 rev 之后有持有者释放信号量
+func (s *semaphore) waitRelease(ctx context.Context, rev int64) error {
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	wch := DefalutClient.Client.Watch(wctx, s.prefix, client.WithPrefix(), client.WithFilterPut(), client.WithRev(rev))
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.lost:
+		return ErrSemaphoreLost
+	case wresp, ok := <-wch:
+		if !ok {
+			return ctx.Err()
+		}
+		return wresp.Err()
+	}
+}
+
+// 释放前一直续租，出错时重试
+// lease 已过期时信号量可能被其它结点取得，取消本次执行以免超出集群并发数
+func (s *semaphore) keepAlive(interval, retry time.Duration) {
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-timer.C:
+			_, err := DefalutClient.KeepAliveOnce(s.lID)
+			switch {
+			case err == nil:
+				timer.Reset(interval)
+			case err == rpctypes.ErrLeaseNotFound:
+				s.expire()
+				return
+			default:
+				log.Warnf("semaphore[%s] keep alive err: %s", s.key, err.Error())
+				timer.Reset(retry)
+			}
+		}
+	}
+}
+
+// lease 过期，停止等待并结束执行中的进程
+func (s *semaphore) expire() {
+	log.Errorf("semaphore[%s] of job[%s] expired on[%s], cancel the run", s.key, s.job.Key(), s.job.runOn)
+	close(s.lost)
+	if pid := s.tr.cancel(ErrSemaphoreLost.Error()); pid > 0 {
+		if err := s.job.Kill(pid); err != nil {
+			log.Warnf("job[%s] kill process[%d] err: %s", s.job.Key(), pid, err.Error())
+		}
+	}
+}
+
+func (s *semaphore) release() {
+	if s == nil {
+		return
+	}
+
+	close(s.done)
+	if _, err := DefalutClient.Revoke(s.lID); err != nil {
+		log.Warnf("semaphore[%s] revoke err: %s", s.key, err.Error())
+	}
+}
+
+// GetSemaphoreHolders 返回任务信号量的持有者和等待者，按申请的先后排序
+func GetSemaphoreHolders(job *Job) ([]*SemaphoreHolder, error) {
+	resp, err := DefalutClient.Get(SemaphoreKey(job.ID), client.WithPrefix(),
+		client.WithSort(client.SortByCreateRevision, client.SortAscend))
+	if err != nil {
+		return nil, err
+	}
+
+	holders := make([]*SemaphoreHolder, 0, len(resp.Kvs))
+	for i, kv := range resp.Kvs {
+		h := &SemaphoreHolder{}
+		if err = json.Unmarshal(kv.Value, h); err != nil {
+			log.Warnf("semaphore[%s] unmarshal err: %s", string(kv.Key), err.Error())
+			continue
+		}
+		h.Holding = job.MaxConcurrency <= 0 || int64(i) < job.MaxConcurrency
+		holders = append(holders, h)
+	}
+	return holders, nil
+}
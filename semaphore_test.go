@@ -0,0 +1,139 @@
+package cronsun
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newSemaphoreJob(max int64) *Job {
+	return &Job{ID: "sem", Group: "test", MaxConcurrency: max, runOn: "n1", hostname: "host1", ip: "10.0.0.1"}
+}
+
+func TestSemaphoreAcquire(t *testing.T) {
+	newFakeEtcd(t)
+	j := newSemaphoreJob(2)
+
+	var sems []*semaphore
+	defer func() {
+		for _, s := range sems {
+			s.release()
+		}
+	}()
+	for i := 0; i < 3; i++ {
+		s, err := j.newSemaphore(&Trigger{Type: TriggerCron, RuleID: "r1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sems = append(sems, s)
+	}
+
+	// 前两个在并发数以内
+	for i, s := range sems[:2] {
+		if ok, err := s.acquire(context.Background(), j.MaxConcurrency, false); !ok || err != nil {
+			t.Errorf("#%d: expected acquired, got %v, %v", i, ok, err)
+		}
+	}
+
+	// 已满时不等待直接跳过
+	if ok, err := sems[2].acquire(context.Background(), j.MaxConcurrency, false); ok || err != nil {
+		t.Errorf("expected skipped when full, got %v, %v", ok, err)
+	}
+
+	// 等待到期后返回超时
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if ok, err := sems[2].acquire(ctx, j.MaxConcurrency, true); ok || err != context.DeadlineExceeded {
+		t.Errorf("expected wait deadline exceeded, got %v, %v", ok, err)
+	}
+
+	// 有持有者释放后等待者取得信号量
+	acquired := make(chan bool)
+	go func(s *semaphore) {
+		ok, _ := s.acquire(context.Background(), j.MaxConcurrency, true)
+		acquired <- ok
+	}(sems[2])
+	sems[0].release()
+	sems = sems[1:]
+	select {
+	case ok := <-acquired:
+		if !ok {
+			t.Error("expected acquired after release")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("expected waiter to acquire after release")
+	}
+}
+
+func TestSemaphoreHolders(t *testing.T) {
+	newFakeEtcd(t)
+	j := newSemaphoreJob(1)
+
+	for _, rid := range []string{"r1", "r2"} {
+		s, err := j.newSemaphore(&Trigger{Type: TriggerCron, RuleID: rid})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.release()
+	}
+
+	holders, err := GetSemaphoreHolders(j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(holders) != 2 {
+		t.Fatalf("expected 2 holders, got %d", len(holders))
+	}
+	for i, expected := range []struct {
+		rule    string
+		holding bool
+	}{{"r1", true}, {"r2", false}} {
+		h := holders[i]
+		if h.RuleID != expected.rule || h.Holding != expected.holding || h.Node != "n1" || h.Trigger != TriggerCron {
+			t.Errorf("#%d: unexpected holder %+v", i, h)
+		}
+	}
+}
+
+func TestSemaphoreKeepAlive(t *testing.T) {
+	f := newFakeEtcd(t)
+	keepAlive, retry := semaphoreKeepAlive, semaphoreRetry
+	semaphoreKeepAlive, semaphoreRetry = 20*time.Millisecond, 10*time.Millisecond
+	defer func() { semaphoreKeepAlive, semaphoreRetry = keepAlive, retry }()
+
+	j := newSemaphoreJob(1)
+	tr := &Trigger{Type: TriggerCron}
+	s, err := j.newSemaphore(tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.release()
+
+	// 续租出错时一直重试
+	f.setAliveErr(errors.New("etcd unavailable"))
+	time.Sleep(100 * time.Millisecond)
+	f.setAliveErr(nil)
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-s.lost:
+		t.Fatal("expected semaphore kept after keep alive errors")
+	default:
+	}
+
+	// lease 过期后取消执行
+	f.mu.Lock()
+	delete(f.leases, s.lID)
+	f.mu.Unlock()
+	select {
+	case <-s.lost:
+	case <-time.After(time.Second):
+		t.Fatal("expected semaphore lost after lease expired")
+	}
+	if tr.cancelReason() != ErrSemaphoreLost.Error() {
+		t.Errorf("expected run canceled by lost semaphore, got %q", tr.cancelReason())
+	}
+	if err := s.waitRelease(context.Background(), 0); err != ErrSemaphoreLost {
+		t.Errorf("expected %v, got %v", ErrSemaphoreLost, err)
+	}
+}
@@ -0,0 +1,256 @@
+package cronsun
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	client "github.com/coreos/etcd/clientv3"
+
+	"cronsun/conf"
+	"cronsun/db/entries"
+	"cronsun/log"
+)
+
+const (
+	// 任务没有设置超时时间时，分片执行的最长时间
+	DefaultShardTimeout = 24 * time.Hour
+	// 分片执行记录在截止时间后保留的时间，用于截止时间后结束执行
+	shardStateMargin = 5 * time.Minute
+)
+
+func (j *Job) checkShards() {
+	if j.Kind != KindShard || j.Shards < 0 {
+		j.Shards = 0
+	}
+}
+
+// 把本次触发分片到在线的结点，结点不够时一个结点执行多个分片
+// 下线的结点不分配分片
+func (c *Cmd) dispatchShards(tr *Trigger, nodes []*entries.Node) {
+	total := c.Job.Shards
+	if total <= 0 {
+		total = len(nodes)
+	}
+
+	assign := make(map[string][]int, len(nodes))
+	for i := 0; i < total; i++ {
+		n := nodes[i%len(nodes)]
+		assign[n.ID] = append(assign[n.ID], i)
+	}
+
+	o := &Once{
+		TriggerType:    tr.Type,
+		RuleID:         c.JobRule.ID,
+		FireTime:       tr.FireTime,
+		DispatchedBy:   c.Job.runOn,
+		DispatchReason: fmt.Sprintf("shard, %d shards over %d nodes", total, len(assign)),
+		ExecutionID:    NextID(),
+		Shards:         assign,
+		ShardTotal:     total,
+	}
+	timeout := c.Job.shardTimeout()
+	if err := startShards(o, timeout); err != nil {
+		log.Warnf("job[%s] start shards err: %s", c.Job.Key(), err.Error())
+		return
+	}
+	if err := putOnce(c.Job.Group, c.Job.ID, o); err != nil {
+		log.Warnf("job[%s] dispatch shards err: %s", c.Job.Key(), err.Error())
+		return
+	}
+	log.Infof("job[%s] rule[%s] execution[%s] dispatched: %s", c.Job.Key(), c.JobRule.ID, o.ExecutionID, o.DispatchReason)
+
+	// 结点下线或者没有执行时，截止时间后结束本次执行
+	// 调度的结点重启后不再检查，记录在租约过期后删除
+	job := c.Job
+	time.AfterFunc(timeout, func() { job.expireShards(o) })
+}
+
+// 分片执行的截止时间，包括重试的时间和 1 分钟的调度时间
+func (j *Job) shardTimeout() time.Duration {
+	if j.Timeout <= 0 {
+		return DefaultShardTimeout
+	}
+
+	d := time.Duration(j.Timeout) * time.Second * time.Duration(j.Retry+1)
+	for i := 1; i <= j.Retry; i++ {
+		d += j.RetryPolicy.delay(j.Interval, i)
+	}
+	return d + time.Minute
+}
+
+// 分片执行的结果
+type shardState struct {
+	Total   int          `json:"total"`
+	Results map[int]bool `json:"results"` // 已结束的分片是否执行成功
+	// 截止时间，之后还没有结束的分片记为失败
+	Deadline time.Time `json:"deadline"`
+}
+
+// 调度前记录分片执行，记录的租约在截止时间后过期
+func startShards(o *Once, timeout time.Duration) error {
+	b, err := json.Marshal(&shardState{Total: o.ShardTotal, Results: make(map[int]bool), Deadline: time.Now().Add(timeout)})
+	if err != nil {
+		return err
+	}
+
+	resp, err := DefalutClient.Grant(int64((timeout + shardStateMargin) / time.Second))
+	if err != nil {
+		return err
+	}
+	_, err = DefalutClient.Put(ShardStateKey(o.ExecutionID), string(b), client.WithLease(resp.ID))
+	return err
+}
+
+// ShardStateKey 记录分片执行结果的 key
+func ShardStateKey(execID string) string {
+	return conf.Config.Lock + "shards/" + execID
+}
+
+// 记录分片的执行结果
+// 所有分片都结束时返回汇总的结果和 true，所有分片都成功才算成功
+func (j *Job) finishShard(tr *Trigger, r *ExecResult) (*ExecResult, bool) {
+	key := ShardStateKey(tr.ExecutionID)
+	for {
+		resp, err := DefalutClient.Get(key)
+		if err != nil {
+			log.Warnf("job[%s] execution[%s] get shard results err: %s", j.Key(), tr.ExecutionID, err.Error())
+			return nil, false
+		}
+
+		// 已过截止时间，执行已经结束
+		if len(resp.Kvs) == 0 {
+			log.Infof("job[%s] execution[%s] has finished, result of shard %d ignored", j.Key(), tr.ExecutionID, tr.ShardIndex)
+			return nil, false
+		}
+
+		kv := resp.Kvs[0]
+		state := &shardState{}
+		if err = json.Unmarshal(kv.Value, state); err != nil {
+			log.Warnf("job[%s] execution[%s] unmarshal shard results err: %s", j.Key(), tr.ExecutionID, err.Error())
+			return nil, false
+		}
+		if state.Results == nil {
+			state.Results = make(map[int]bool)
+		}
+		state.Results[tr.ShardIndex] = r.Success
+
+		done := len(state.Results) >= state.Total
+		op := client.OpDelete(key)
+		if !done {
+			b, err := json.Marshal(state)
+			if err != nil {
+				log.Warnf("job[%s] execution[%s] marshal shard results err: %s", j.Key(), tr.ExecutionID, err.Error())
+				return nil, false
+			}
+			op = client.OpPut(key, string(b), client.WithLease(client.LeaseID(kv.Lease)))
+		}
+
+		ctx, cancel := NewEtcdTimeoutContext(DefalutClient)
+		tresp, err := DefalutClient.Txn(ctx).
+			If(client.Compare(client.ModRevision(key), "=", kv.ModRevision)).
+			Then(op).
+			Commit()
+		cancel()
+		if err != nil {
+			log.Warnf("job[%s] execution[%s] save shard results err: %s", j.Key(), tr.ExecutionID, err.Error())
+			return nil, false
+		}
+		if !tresp.Succeeded {
+			continue
+		}
+		if !done {
+			return nil, false
+		}
+		return state.result(tr, r), true
+	}
+}
+
+// 截止时间后还没有结束的分片记为失败，结束本次执行
+func (j *Job) expireShards(o *Once) {
+	key := ShardStateKey(o.ExecutionID)
+	state := &shardState{}
+	for {
+		resp, err := DefalutClient.Get(key)
+		if err != nil {
+			log.Warnf("job[%s] execution[%s] get shard results err: %s", j.Key(), o.ExecutionID, err.Error())
+			return
+		}
+		// 所有分片都已结束
+		if len(resp.Kvs) == 0 {
+			return
+		}
+
+		if err = json.Unmarshal(resp.Kvs[0].Value, state); err != nil {
+			log.Warnf("job[%s] execution[%s] unmarshal shard results err: %s", j.Key(), o.ExecutionID, err.Error())
+			return
+		}
+
+		ctx, cancel := NewEtcdTimeoutContext(DefalutClient)
+		tresp, err := DefalutClient.Txn(ctx).
+			If(client.Compare(client.ModRevision(key), "=", resp.Kvs[0].ModRevision)).
+			Then(client.OpDelete(key)).
+			Commit()
+		cancel()
+		if err != nil {
+			log.Warnf("job[%s] execution[%s] delete shard results err: %s", j.Key(), o.ExecutionID, err.Error())
+			return
+		}
+		if tresp.Succeeded {
+			break
+		}
+	}
+
+	now := time.Now()
+	missing := state.missing()
+	if state.Results == nil {
+		state.Results = make(map[int]bool)
+	}
+	for _, i := range missing {
+		tr := o.Trigger()
+		tr.ShardIndex, tr.ShardTotal = i, o.ShardTotal
+		CreateJobLog(j, tr, &ExecResult{BeginTime: now, ExitCode: -1,
+			Output: fmt.Sprintf("shard %d of %d in execution[%s] assigned to node[%s] did not finish before %s",
+				i, o.ShardTotal, o.ExecutionID, o.shardNode(i), state.Deadline.Format(time.RFC3339))})
+		state.Results[i] = false
+	}
+
+	tr := o.Trigger()
+	tr.ShardTotal = o.ShardTotal
+	r := state.result(tr, &ExecResult{BeginTime: now})
+	r.Output += fmt.Sprintf(", shards %v did not finish before %s", missing, state.Deadline.Format(time.RFC3339))
+	log.Warnf("job[%s] %s", j.Key(), r.Output)
+
+	j.Notify(tr, r)
+	j.runDepends(tr, false)
+}
+
+// 还没有结束的分片
+func (s *shardState) missing() []int {
+	var missing []int
+	for i := 0; i < s.Total; i++ {
+		if _, ok := s.Results[i]; !ok {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
+// 汇总的执行结果
+func (s *shardState) result(tr *Trigger, r *ExecResult) *ExecResult {
+	var failed []int
+	for i, ok := range s.Results {
+		if !ok {
+			failed = append(failed, i)
+		}
+	}
+	sort.Ints(failed)
+
+	if len(failed) == 0 {
+		return &ExecResult{BeginTime: r.BeginTime, Success: true,
+			Output: fmt.Sprintf("all %d shards of execution[%s] succeeded", s.Total, tr.ExecutionID)}
+	}
+	return &ExecResult{BeginTime: r.BeginTime, ExitCode: -1,
+		Output: fmt.Sprintf("shards %v of %d in execution[%s] failed", failed, s.Total, tr.ExecutionID)}
+}
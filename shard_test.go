@@ -0,0 +1,96 @@
+package cronsun
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOnceShardTriggers(t *testing.T) {
+	o := ParseOnce([]byte(`{"trigger_type":"cron","rule_id":"r1","execution_id":"e1","shards":{"n1":[0,2],"n2":[1]},"shard_total":3}`))
+	if !o.IsRunOn("n1") || !o.IsRunOn("n2") || o.IsRunOn("n3") {
+		t.Errorf("expected run on n1 and n2 only")
+	}
+
+	trs := o.Triggers("n1")
+	if len(trs) != 2 {
+		t.Fatalf("expected 2 triggers, got %d", len(trs))
+	}
+	for i, expected := range []int{0, 2} {
+		if tr := trs[i]; tr.ShardIndex != expected || tr.ShardTotal != 3 || tr.ExecutionID != "e1" || tr.RuleID != "r1" {
+			t.Errorf("#%d: unexpected trigger %+v", i, tr)
+		}
+	}
+
+	if trs = ParseOnce([]byte("n1")).Triggers("n1"); len(trs) != 1 || trs[0].ShardTotal != 0 {
+		t.Errorf("expected one trigger without shard, got %+v", trs)
+	}
+}
+
+func TestShardStateResult(t *testing.T) {
+	tr := &Trigger{ExecutionID: "e1"}
+	r := &ExecResult{}
+
+	s := &shardState{Total: 3, Results: map[int]bool{0: true, 1: true, 2: true}}
+	if res := s.result(tr, r); !res.Success {
+		t.Errorf("expected success, got %s", res.Output)
+	}
+
+	s.Results[2], s.Results[0] = false, false
+	res := s.result(tr, r)
+	if res.Success || !strings.Contains(res.Output, "[0 2]") {
+		t.Errorf("expected shards [0 2] failed, got %s", res.Output)
+	}
+}
+
+func TestShardDeadline(t *testing.T) {
+	s := &shardState{Total: 4, Results: map[int]bool{0: true, 2: false}}
+	if missing := s.missing(); !reflect.DeepEqual(missing, []int{1, 3}) {
+		t.Errorf("expected shards [1 3] missing, got %v", missing)
+	}
+
+	o := ParseOnce([]byte(`{"execution_id":"e1","shards":{"n1":[0,2],"n2":[1,3]},"shard_total":4}`))
+	if n := o.shardNode(3); n != "n2" {
+		t.Errorf("expected shard 3 on n2, got %q", n)
+	}
+	if n := o.shardNode(4); n != "" {
+		t.Errorf("expected no node for shard 4, got %q", n)
+	}
+
+	tests := []struct {
+		job      *Job
+		expected time.Duration
+	}{
+		{&Job{}, DefaultShardTimeout},
+		{&Job{Timeout: 60}, 2 * time.Minute},
+		{&Job{Timeout: 60, Retry: 2, Interval: 10}, 3*time.Minute + 20*time.Second + time.Minute},
+		{&Job{Timeout: 60, Retry: 2, Interval: 10, RetryPolicy: &RetryPolicy{Backoff: BackoffExponential}}, 3*time.Minute + 30*time.Second + time.Minute},
+	}
+	for i, test := range tests {
+		if d := test.job.shardTimeout(); d != test.expected {
+			t.Errorf("#%d: expected %s, got %s", i, test.expected, d)
+		}
+	}
+}
+
+func TestShardEnviron(t *testing.T) {
+	j := &Job{ID: "j1"}
+	env := j.environ(&Trigger{ExecutionID: "e1", ShardIndex: 2, ShardTotal: 4})
+	var shard []string
+	for _, e := range env {
+		if strings.HasPrefix(e, EnvShardIndex+"=") || strings.HasPrefix(e, EnvShardTotal+"=") || strings.HasPrefix(e, EnvExecutionID+"=") {
+			shard = append(shard, e)
+		}
+	}
+	expected := []string{EnvExecutionID + "=e1", EnvShardIndex + "=2", EnvShardTotal + "=4"}
+	if !reflect.DeepEqual(shard, expected) {
+		t.Errorf("expected %v, got %v", expected, shard)
+	}
+
+	for _, e := range j.environ(&Trigger{}) {
+		if strings.HasPrefix(e, EnvShardIndex+"=") {
+			t.Errorf("unexpected %s without shard", e)
+		}
+	}
+}
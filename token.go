@@ -0,0 +1,286 @@
+package cronsun
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	client "github.com/coreos/etcd/clientv3"
+
+	"cronsun/conf"
+)
+
+const (
+	// 每分钟允许触发的次数的默认值
+	DefaultTokenRateLimit = 60
+	// 请求内容的最大长度，通过环境变量和标准输入传给任务
+	MaxTriggerPayload = 64 << 10
+
+	// 签名的请求头，值为 sha256=<hex>
+	// 签名内容为 <timestamp>.<body>，密钥为创建 token 时返回的签名密钥
+	TriggerSignatureHeader = "X-Cronsun-Signature"
+	// 签名时间的请求头，unix 时间戳，单位秒
+	TriggerTimestampHeader = "X-Cronsun-Timestamp"
+	// 签名时间与服务端时间最多相差的时间
+	TriggerSignatureMaxAge = 5 * time.Minute
+)
+
+// 任务的触发 token，通过 /v1/trigger/{token} 触发任务执行
+// 只保存 token 的 SHA-256，创建后无法再次获取 token
+// token 会出现在 URL 中，签名使用单独的签名密钥，同样只在创建时返回
+type JobToken struct {
+	ID    string `json:"id"`
+	Group string `json:"group"`
+	JobID string `json:"job_id"`
+	Name  string `json:"name"`
+	// 是否需要请求带有 HMAC 签名
+	RequireSignature bool `json:"require_signature"`
+	// 每分钟允许触发的次数，不大于 0 时为 DefaultTokenRateLimit
+	RateLimit int       `json:"rate_limit"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// 用 Security.TriggerKey 加密的签名密钥，不返回给调用方
+	SigningKey string `json:"signing_key,omitempty"`
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func tokenKey(token string) string {
+	return conf.Config.Token + hashToken(token)
+}
+
+func (t *JobToken) Limit() int {
+	if t.RateLimit <= 0 {
+		return DefaultTokenRateLimit
+	}
+	return t.RateLimit
+}
+
+// 触发来源，记录在 job log 中
+func (t *JobToken) Source() string {
+	if len(t.Name) == 0 {
+		return "token:" + t.ID
+	}
+	return "token:" + t.ID + " (" + t.Name + ")"
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// 生成 token 和签名密钥，签名密钥加密后保存在 t.SigningKey 中
+// 没有设置 Security.TriggerKey 时不生成签名密钥，需要签名的 token 返回错误
+func newJobToken(t *JobToken) (token, secret string, err error) {
+	t.Name = strings.TrimSpace(t.Name)
+	t.ID = NextID()
+	t.CreatedAt = time.Now()
+
+	if token, err = randomHex(32); err != nil {
+		return
+	}
+
+	if len(conf.Config.Security.TriggerKey) == 0 {
+		if t.RequireSignature {
+			err = ErrEmptyTriggerKey
+		}
+		return
+	}
+	if secret, err = randomHex(32); err != nil {
+		return
+	}
+	t.SigningKey, err = sealTriggerSecret(secret)
+	return
+}
+
+// CreateJobToken 创建任务的触发 token，返回 token 和签名密钥
+func CreateJobToken(t *JobToken) (token, secret string, err error) {
+	if token, secret, err = newJobToken(t); err != nil {
+		return
+	}
+
+	v, err := json.Marshal(t)
+	if err != nil {
+		return
+	}
+	_, err = DefalutClient.Put(tokenKey(token), string(v))
+	return
+}
+
+// GetJobToken 按 token 取触发 token 的记录
+func GetJobToken(token string) (*JobToken, error) {
+	resp, err := DefalutClient.Get(tokenKey(token))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, ErrNotFound
+	}
+
+	t := &JobToken{}
+	if err = json.Unmarshal(resp.Kvs[0].Value, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// 任务的触发 token 和对应的 key
+func jobTokens(group, jobID string) (map[string]*JobToken, error) {
+	resp, err := DefalutClient.Get(conf.Config.Token, client.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	ts := make(map[string]*JobToken)
+	for _, kv := range resp.Kvs {
+		t := &JobToken{}
+		if err = json.Unmarshal(kv.Value, t); err != nil {
+			continue
+		}
+		if t.Group == group && t.JobID == jobID {
+			ts[string(kv.Key)] = t
+		}
+	}
+	return ts, nil
+}
+
+// GetJobTokens 任务的所有触发 token
+func GetJobTokens(group, jobID string) ([]*JobToken, error) {
+	ts, err := jobTokens(group, jobID)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*JobToken, 0, len(ts))
+	for _, t := range ts {
+		t.SigningKey = ""
+		list = append(list, t)
+	}
+	return list, nil
+}
+
+// DeleteJobToken 撤销任务的一个触发 token
+func DeleteJobToken(group, jobID, id string) error {
+	ts, err := jobTokens(group, jobID)
+	if err != nil {
+		return err
+	}
+
+	for key, t := range ts {
+		if t.ID == id {
+			_, err = DefalutClient.Delete(key)
+			return err
+		}
+	}
+	return ErrNotFound
+}
+
+// DelJobTokens 撤销任务的所有触发 token
+func DelJobTokens(group, jobID string) error {
+	ts, err := jobTokens(group, jobID)
+	if err != nil {
+		return err
+	}
+
+	for key := range ts {
+		if _, err = DefalutClient.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 用 Security.TriggerKey 加密签名密钥，AES-256-GCM
+func triggerCipher() (cipher.AEAD, error) {
+	if len(conf.Config.Security.TriggerKey) == 0 {
+		return nil, ErrEmptyTriggerKey
+	}
+	key := sha256.Sum256([]byte(conf.Config.Security.TriggerKey))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func sealTriggerSecret(secret string) (string, error) {
+	aead, err := triggerCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(secret), nil)), nil
+}
+
+func openTriggerSecret(sealed string) (string, error) {
+	aead, err := triggerCipher()
+	if err != nil {
+		return "", err
+	}
+	b, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(b) < aead.NonceSize() {
+		return "", ErrIllegalTriggerSignature
+	}
+	secret, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], nil)
+	if err != nil {
+		return "", ErrIllegalTriggerSignature
+	}
+	return string(secret), nil
+}
+
+// Verify 用 token 的签名密钥校验触发请求的签名
+// 没有签名密钥的 token 不能通过校验
+func (t *JobToken) Verify(signature, timestamp string, body []byte, now time.Time) error {
+	if len(t.SigningKey) == 0 {
+		return ErrIllegalTriggerSignature
+	}
+	secret, err := openTriggerSecret(t.SigningKey)
+	if err != nil {
+		return err
+	}
+	return VerifyTrigger(secret, signature, timestamp, body, now)
+}
+
+// SignTrigger 返回触发请求的签名，secret 为创建 token 时返回的签名密钥
+func SignTrigger(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyTrigger 校验触发请求的签名，签名时间需要在 TriggerSignatureMaxAge 内
+func VerifyTrigger(secret, signature, timestamp string, body []byte, now time.Time) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signature) == 0 {
+		return ErrIllegalTriggerSignature
+	}
+
+	d := now.Sub(time.Unix(ts, 0))
+	if d < -TriggerSignatureMaxAge || d > TriggerSignatureMaxAge {
+		return ErrIllegalTriggerSignature
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(SignTrigger(secret, ts, body))) {
+		return ErrIllegalTriggerSignature
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package cronsun
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"cronsun/conf"
+)
+
+func TestVerifyTrigger(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	body := []byte(`{"ref":"main"}`)
+	ts := strconv.FormatInt(now.Unix(), 10)
+	sig := SignTrigger("t0ken", now.Unix(), body)
+
+	tests := []struct {
+		token, sig, ts string
+		body           []byte
+		now            time.Time
+		err            error
+	}{
+		{"t0ken", sig, ts, body, now, nil},
+		{"t0ken", sig, ts, body, now.Add(TriggerSignatureMaxAge), nil},
+		{"t0ken", sig, ts, body, now.Add(TriggerSignatureMaxAge + time.Second), ErrIllegalTriggerSignature},
+		{"t0ken", sig, ts, body, now.Add(-TriggerSignatureMaxAge - time.Second), ErrIllegalTriggerSignature},
+		{"other", sig, ts, body, now, ErrIllegalTriggerSignature},
+		{"t0ken", sig, ts, []byte(`{"ref":"dev"}`), now, ErrIllegalTriggerSignature},
+		{"t0ken", sig, "1500000001", body, now, ErrIllegalTriggerSignature},
+		{"t0ken", "", ts, body, now, ErrIllegalTriggerSignature},
+		{"t0ken", sig, "", body, now, ErrIllegalTriggerSignature},
+	}
+
+	for i, test := range tests {
+		if err := VerifyTrigger(test.token, test.sig, test.ts, test.body, test.now); err != test.err {
+			t.Errorf("#%d: expected %v, got %v", i, test.err, err)
+		}
+	}
+}
+
+func TestJobToken(t *testing.T) {
+	if h := hashToken("t0ken"); len(h) != 64 || h == "t0ken" || h != hashToken("t0ken") {
+		t.Errorf("unexpected token hash %q", h)
+	}
+
+	jt := &JobToken{ID: "a1"}
+	if jt.Limit() != DefaultTokenRateLimit || jt.Source() != "token:a1" {
+		t.Errorf("unexpected limit %d or source %q", jt.Limit(), jt.Source())
+	}
+	jt.RateLimit, jt.Name = 5, "ci"
+	if jt.Limit() != 5 || jt.Source() != "token:a1 (ci)" {
+		t.Errorf("unexpected limit %d or source %q", jt.Limit(), jt.Source())
+	}
+}
+
+func TestJobTokenVerify(t *testing.T) {
+	if err := initID(); err != nil {
+		t.Fatal(err)
+	}
+	security := conf.Config.Security
+	conf.Config.Security = &conf.Security{}
+	defer func() { conf.Config.Security = security }()
+
+	now := time.Unix(1500000000, 0)
+	ts := strconv.FormatInt(now.Unix(), 10)
+	body := []byte(`{"ref":"main"}`)
+
+	// 没有加密密钥时不能创建需要签名的 token
+	if _, _, err := newJobToken(&JobToken{RequireSignature: true}); err != ErrEmptyTriggerKey {
+		t.Errorf("expected %v, got %v", ErrEmptyTriggerKey, err)
+	}
+	jt := &JobToken{}
+	token, secret, err := newJobToken(jt)
+	if err != nil || len(token) == 0 || len(secret) > 0 || len(jt.SigningKey) > 0 {
+		t.Fatalf("expected a token without signing secret, got %q %q %v", token, secret, err)
+	}
+	if err = jt.Verify(SignTrigger(token, now.Unix(), body), ts, body, now); err != ErrIllegalTriggerSignature {
+		t.Errorf("expected %v for token without signing secret, got %v", ErrIllegalTriggerSignature, err)
+	}
+
+	conf.Config.Security.TriggerKey = "k3y"
+	jt = &JobToken{RequireSignature: true}
+	token, secret, err = newJobToken(jt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret) == 0 || secret == token || len(jt.SigningKey) == 0 || jt.SigningKey == secret {
+		t.Fatalf("expected an encrypted signing secret apart from the token, got %q %q %q", token, secret, jt.SigningKey)
+	}
+
+	tests := []struct {
+		sig string
+		err error
+	}{
+		{SignTrigger(secret, now.Unix(), body), nil},
+		// token 在 URL 中，用 token 签名的请求不能通过
+		{SignTrigger(token, now.Unix(), body), ErrIllegalTriggerSignature},
+		{"", ErrIllegalTriggerSignature},
+	}
+	for i, test := range tests {
+		if err = jt.Verify(test.sig, ts, body, now); err != test.err {
+			t.Errorf("#%d: expected %v, got %v", i, test.err, err)
+		}
+	}
+
+	// 加密密钥改变后无法解密签名密钥
+	conf.Config.Security.TriggerKey = "other"
+	if err = jt.Verify(SignTrigger(secret, now.Unix(), body), ts, body, now); err != ErrIllegalTriggerSignature {
+		t.Errorf("expected %v with another trigger key, got %v", ErrIllegalTriggerSignature, err)
+	}
+}
+
+func TestTriggerPayload(t *testing.T) {
+	tests := []struct {
+		payload string
+		err     error
+	}{
+		{"", nil},
+		{`{"ref":"main"}`, nil},
+		{`[1, 2]`, nil},
+		{`{"ref":`, ErrIllegalTriggerPayload},
+		{`"` + string(make([]byte, MaxTriggerPayload)) + `"`, ErrIllegalTriggerPayload},
+	}
+	for i, test := range tests {
+		if err := (&Execution{Payload: test.payload}).check(); err != test.err {
+			t.Errorf("#%d: expected %v, got %v", i, test.err, err)
+		}
+	}
+
+	o := ParseOnce([]byte(`{"trigger_type":"webhook","execution_id":"e1","source":"token:a1","caller_ip":"10.0.0.1","payload":"{\"ref\":\"main\"}"}`))
+	tr := o.Trigger()
+	if tr.Type != TriggerWebhook || tr.Source != "token:a1" || tr.CallerIP != "10.0.0.1" || tr.Payload != `{"ref":"main"}` {
+		t.Errorf("unexpected trigger %+v", tr)
+	}
+
+	var payload string
+	for _, e := range (&Job{}).environ(tr) {
+		if len(e) > len(EnvPayload) && e[:len(EnvPayload)+1] == EnvPayload+"=" {
+			payload = e[len(EnvPayload)+1:]
+		}
+	}
+	if payload != tr.Payload {
+		t.Errorf("expected %s=%s, got %q", EnvPayload, tr.Payload, payload)
+	}
+}
@@ -0,0 +1,142 @@
+package cronsun
+
+import (
+	"sync"
+	"time"
+)
+
+// 任务的触发方式
+const (
+	TriggerCron    = "cron"    // 定时器规则
+	TriggerOnce    = "once"    // 手动执行一次
+	TriggerRetry   = "retry"   // 失败重试
+	TriggerDepend  = "depend"  // 上游任务执行完毕
+	TriggerCatchUp = "catchup" // 补执行错过的定时执行
+	TriggerWebhook = "webhook" // 通过触发 token 调用接口
+	TriggerFile    = "file"    // 监听的目录中有匹配的文件出现或修改
+)
+
+// 触发一次任务执行的相关信息
+type Trigger struct {
+	// 触发方式
+	Type string
+	// 触发执行的定时器规则 id，非定时器触发时为空
+	RuleID string
+	// 计划执行时间
+	FireTime time.Time
+	// 第几次执行，重试时递增，从 1 开始
+	Attempt int
+	// 任一结点任务，调度本次执行的结点和选择本结点的原因
+	DispatchedBy   string
+	DispatchReason string
+	// 同一次执行的 id，分片任务的所有分片使用同一个 id
+	ExecutionID string
+	// 分片任务的分片序号，从 0 开始，和分片总数，非分片执行时 ShardTotal 为 0
+	ShardIndex int
+	ShardTotal int
+	// 手动执行追加在命令后的参数和覆盖的环境变量
+	Args []string
+	Env  []*JobEnv
+	// 触发来源和调用方的 IP，webhook 触发时为使用的 token
+	Source   string
+	CallerIP string
+	// webhook 触发时请求的 JSON 内容
+	Payload string
+	// 文件触发时匹配的文件路径
+	File string
+
+	// 执行中的进程，用于被新的执行替换时结束进程
+	mu       sync.Mutex
+	pid      int
+	canceled string // 取消的原因，为空时没有取消
+	stop     chan struct{}
+	done     chan struct{}
+	// 执行中的 HTTP 请求，用于被新的执行替换时取消请求
+	abort func()
+}
+
+// 本次执行的触发方式，重试的执行都记为 retry
+func (tr *Trigger) AttemptType() string {
+	if tr.Attempt > 1 {
+		return TriggerRetry
+	}
+	return tr.Type
+}
+
+// 记录启动的进程，已被取消时返回 false
+func (tr *Trigger) started(pid int) bool {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.pid = pid
+	return len(tr.canceled) == 0
+}
+
+// 记录发出的 HTTP 请求，已被取消时返回 false
+func (tr *Trigger) requested(abort func()) bool {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.abort = abort
+	return len(tr.canceled) == 0
+}
+
+func (tr *Trigger) exited() {
+	tr.mu.Lock()
+	tr.pid = 0
+	tr.abort = nil
+	tr.mu.Unlock()
+}
+
+// 取消本次执行，不再重试，取消执行中的 HTTP 请求，返回正在执行的进程
+func (tr *Trigger) cancel(reason string) (pid int) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	if len(tr.canceled) == 0 {
+		tr.canceled = reason
+		if tr.stop != nil {
+			close(tr.stop)
+		}
+	}
+	if tr.abort != nil {
+		tr.abort()
+	}
+	return tr.pid
+}
+
+func (tr *Trigger) isCanceled() bool {
+	return len(tr.cancelReason()) > 0
+}
+
+// 取消后关闭的 channel，用于结束重试的等待和信号量的等待
+func (tr *Trigger) canceledC() <-chan struct{} {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	if tr.stop == nil {
+		tr.stop = make(chan struct{})
+		if len(tr.canceled) > 0 {
+			close(tr.stop)
+		}
+	}
+	return tr.stop
+}
+
+// 等待 d，执行被取消时提前返回 false
+func (tr *Trigger) sleep(d time.Duration) bool {
+	if d <= 0 {
+		return !tr.isCanceled()
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-tr.canceledC():
+		return false
+	}
+}
+
+func (tr *Trigger) cancelReason() string {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	return tr.canceled
+}
@@ -3,6 +3,8 @@ package cronsun
 import (
 	"fmt"
 	"runtime"
+	"strconv"
+	"strings"
 )
 
 const VersionNumber = "0.3.5"
@@ -10,3 +12,118 @@ const VersionNumber = "0.3.5"
 var (
 	Version = fmt.Sprintf("v%s (build %s)", VersionNumber, runtime.Version())
 )
+
+// 版本约束，多个条件用逗号分隔，都满足时匹配，如 >=0.3.5, <0.4
+type versionConstraint []*versionCondition
+
+type versionCondition struct {
+	op      string
+	version []int
+}
+
+// 按最长的操作符优先匹配
+var versionOps = []string{">=", "<=", "!=", "==", ">", "<", "="}
+
+func parseVersionConstraint(s string) (versionConstraint, error) {
+	var vc versionConstraint
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if len(part) == 0 {
+			continue
+		}
+
+		c := &versionCondition{op: "="}
+		for _, op := range versionOps {
+			if strings.HasPrefix(part, op) {
+				c.op, part = op, strings.TrimSpace(part[len(op):])
+				break
+			}
+		}
+		if c.op == "==" {
+			c.op = "="
+		}
+
+		v, ok := parseVersion(part)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrIllegalNodeGroupVersion, s)
+		}
+		c.version = v
+		vc = append(vc, c)
+	}
+	return vc, nil
+}
+
+// 解析 v0.3.5 (build go1.10) 形式的版本号
+func parseVersion(s string) ([]int, bool) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexAny(s, " -+"); i >= 0 {
+		s = s[:i]
+	}
+	if len(s) == 0 {
+		return nil, false
+	}
+
+	parts := strings.Split(s, ".")
+	v := make([]int, len(parts))
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		v[i] = n
+	}
+	return v, true
+}
+
+// 比较版本号，缺少的部分视为 0
+func compareVersion(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
+
+func (vc versionConstraint) match(version string) bool {
+	if len(vc) == 0 {
+		return true
+	}
+
+	v, ok := parseVersion(version)
+	if !ok {
+		return false
+	}
+	for _, c := range vc {
+		cmp := compareVersion(v, c.version)
+		var ok bool
+		switch c.op {
+		case ">=":
+			ok = cmp >= 0
+		case "<=":
+			ok = cmp <= 0
+		case "!=":
+			ok = cmp != 0
+		case ">":
+			ok = cmp > 0
+		case "<":
+			ok = cmp < 0
+		default:
+			ok = cmp == 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -182,6 +182,17 @@ func getStringArrayFromQuery(name, sep string, r *http.Request) (arr []string) {
 	return strings.Split(val, sep)
 }
 
+func getIntArrayFromQuery(name, sep string, r *http.Request) (arr []int) {
+	for _, s := range getStringArrayFromQuery(name, sep, r) {
+		i, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			continue
+		}
+		arr = append(arr, i)
+	}
+	return
+}
+
 func getPage(page string) int {
 	p, err := strconv.Atoi(page)
 	if err != nil || p < 1 {
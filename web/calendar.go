@@ -0,0 +1,115 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"cronsun"
+)
+
+type Calendar struct{}
+
+func (c *Calendar) GetList(ctx *Context) {
+	list, err := cronsun.GetCalendars()
+	if err != nil {
+		outJSONWithCode(ctx.W, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	outJSON(ctx.W, list)
+}
+
+func (c *Calendar) GetCalendar(ctx *Context) {
+	vars := mux.Vars(ctx.R)
+	cal, err := cronsun.GetCalendarById(vars["id"])
+	if err != nil {
+		outJSONWithCode(ctx.W, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if cal == nil {
+		outJSONWithCode(ctx.W, http.StatusNotFound, nil)
+		return
+	}
+	outJSON(ctx.W, cal)
+}
+
+func (c *Calendar) UpdateCalendar(ctx *Context) {
+	cal := &cronsun.Calendar{}
+	if err := json.NewDecoder(ctx.R.Body).Decode(cal); err != nil {
+		outJSONWithCode(ctx.W, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer ctx.R.Body.Close()
+
+	c.putCalendar(ctx, cal)
+}
+
+// ImportICalendar 从 iCalendar 文件创建或替换日历，请求体为文件内容
+// 日历的 id 和 name 通过 query 指定，name 为空时使用原来的名称
+func (c *Calendar) ImportICalendar(ctx *Context) {
+	dates, err := cronsun.ParseICalendar(ctx.R.Body)
+	ctx.R.Body.Close()
+	if err != nil {
+		outJSONWithCode(ctx.W, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	cal := &cronsun.Calendar{
+		ID:    getStringVal("id", ctx.R),
+		Name:  getStringVal("name", ctx.R),
+		Dates: dates,
+	}
+	if len(cal.ID) > 0 && len(cal.Name) == 0 {
+		old, err := cronsun.GetCalendarById(cal.ID)
+		if err != nil {
+			outJSONWithCode(ctx.W, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if old != nil {
+			cal.Name = old.Name
+		}
+	}
+
+	c.putCalendar(ctx, cal)
+}
+
+func (c *Calendar) putCalendar(ctx *Context, cal *cronsun.Calendar) {
+	var successCode = http.StatusOK
+	cal.ID = strings.TrimSpace(cal.ID)
+	if len(cal.ID) == 0 {
+		successCode = http.StatusCreated
+		cal.ID = cronsun.NextID()
+	}
+
+	if err := cal.Check(); err != nil {
+		outJSONWithCode(ctx.W, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, err := cal.Put(0); err != nil {
+		outJSONWithCode(ctx.W, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	outJSONWithCode(ctx.W, successCode, cal)
+}
+
+func (c *Calendar) DeleteCalendar(ctx *Context) {
+	vars := mux.Vars(ctx.R)
+	id := strings.TrimSpace(vars["id"])
+	if len(id) == 0 {
+		outJSONWithCode(ctx.W, http.StatusBadRequest, "empty calendar id.")
+		return
+	}
+
+	if _, err := cronsun.DeleteCalendarById(id); err != nil {
+		outJSONWithCode(ctx.W, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	outJSONWithCode(ctx.W, http.StatusNoContent, nil)
+}
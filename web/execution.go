@@ -0,0 +1,52 @@
+package web
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	v3 "github.com/coreos/etcd/clientv3"
+	"github.com/gorilla/mux"
+
+	"cronsun"
+	"cronsun/conf"
+	"cronsun/log"
+)
+
+type Execution struct{}
+
+type executionStatus struct {
+	*cronsun.Execution
+	Nodes []*cronsun.ExecutionNode `json:"nodes"`
+}
+
+// 手动执行在每个结点上的状态
+func (e *Execution) GetExecution(ctx *Context) {
+	id := strings.TrimSpace(mux.Vars(ctx.R)["id"])
+	exec, started, err := cronsun.GetExecution(id)
+	var statusCode int
+	if err != nil {
+		if err == cronsun.ErrNotFound {
+			statusCode = http.StatusNotFound
+		} else {
+			statusCode = http.StatusInternalServerError
+		}
+		outJSONWithCode(ctx.W, statusCode, err.Error())
+		return
+	}
+
+	alive := make(map[string]bool)
+	gresp, err := cronsun.DefalutClient.Get(conf.Config.Node, v3.WithPrefix(), v3.WithKeysOnly())
+	if err == nil {
+		for i := range gresp.Kvs {
+			alive[cronsun.GetIDFromKey(string(gresp.Kvs[i].Key))] = true
+		}
+	} else {
+		log.Errorf("failed to fetch key[%s] from etcd: %s", conf.Config.Node, err.Error())
+	}
+
+	outJSON(ctx.W, &executionStatus{
+		Execution: exec,
+		Nodes:     exec.Nodes(started, alive, time.Now()),
+	})
+}
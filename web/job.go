@@ -4,9 +4,11 @@ import (
 	"cronsun/db/entries"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/coreos/etcd/clientv3"
 	"github.com/gorilla/mux"
@@ -43,6 +45,16 @@ func (j *Job) DeleteJob(ctx *Context) {
 		return
 	}
 
+	if err = cronsun.DelJobRuns(vars["id"]); err != nil {
+		log.Warnf("delete runs of job[%s] err: %s", vars["id"], err.Error())
+	}
+	if err = cronsun.DelJobDispatch(vars["id"]); err != nil {
+		log.Warnf("delete dispatch records of job[%s] err: %s", vars["id"], err.Error())
+	}
+	if err = cronsun.DelJobTokens(vars["group"], vars["id"]); err != nil {
+		log.Warnf("delete trigger tokens of job[%s] err: %s", vars["id"], err.Error())
+	}
+
 	outJSONWithCode(ctx.W, http.StatusNoContent, nil)
 }
 
@@ -76,6 +88,13 @@ func (j *Job) updateJobStatus(group, id string, isPause bool) (*cronsun.Job, err
 		return nil, err
 	}
 
+	// 恢复执行的任务重新计数
+	if !isPause && originJob.MaxRuns > 0 {
+		if err = cronsun.DelJobRuns(id); err != nil {
+			return nil, err
+		}
+	}
+
 	originJob.Pause = isPause
 	b, err := json.Marshal(originJob)
 	if err != nil {
@@ -160,6 +179,22 @@ func (j *Job) UpdateJob(ctx *Context) {
 		}
 	}
 
+	if err = cronsun.CheckDependCycle(job.Job); err != nil {
+		statusCode := http.StatusInternalServerError
+		if _, ok := err.(*cronsun.DependCycleError); ok {
+			statusCode = http.StatusBadRequest
+		}
+		outJSONWithCode(ctx.W, statusCode, err.Error())
+		return
+	}
+
+	if successCode == http.StatusOK {
+		if err = resetJobRuns(job.Job, job.OldGroup); err != nil {
+			outJSONWithCode(ctx.W, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
 	b, err := json.Marshal(job)
 	if err != nil {
 		outJSONWithCode(ctx.W, http.StatusInternalServerError, err.Error())
@@ -185,6 +220,22 @@ func (j *Job) UpdateJob(ctx *Context) {
 	outJSONWithCode(ctx.W, successCode, nil)
 }
 
+// 任务恢复执行或修改了 MaxRuns 时，在保存任务前删除执行次数
+func resetJobRuns(job *cronsun.Job, group string) error {
+	prev, err := cronsun.GetJob(group, job.ID)
+	if err != nil {
+		if err == cronsun.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+
+	if !job.ResetsRuns(prev) {
+		return nil
+	}
+	return cronsun.DelJobRuns(job.ID)
+}
+
 func (j *Job) GetGroups(ctx *Context) {
 	resp, err := cronsun.DefalutClient.Get(conf.Config.Cmd, clientv3.WithPrefix(), clientv3.WithKeysOnly())
 	if err != nil {
@@ -221,6 +272,8 @@ func (j *Job) GetList(ctx *Context) {
 		*cronsun.Job
 		LatestStatus *entries.JobLatestLog `json:"latestStatus"`
 		NextRunTime  string                `json:"nextRunTime"`
+		Runs         int64                 `json:"runs"`        // 已执行的次数，设置了 MaxRuns 时记录
+		ActiveState  string                `json:"activeState"` // 有效期状态：active, pending, expired, exhausted
 	}
 
 	resp, err := cronsun.DefalutClient.Get(prefix, clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
@@ -229,7 +282,10 @@ func (j *Job) GetList(ctx *Context) {
 		return
 	}
 
-	var nodeGroupMap map[string]*cronsun.Group
+	var (
+		nodeGroupMap map[string]*cronsun.Group
+		nodeData     = &entries.Node{ID: node}
+	)
 	if len(node) > 0 {
 		nodeGrouplist, err := cronsun.GetNodeGroups()
 		if err != nil {
@@ -240,6 +296,13 @@ func (j *Job) GetList(ctx *Context) {
 		for i := range nodeGrouplist {
 			nodeGroupMap[nodeGrouplist[i].ID] = nodeGrouplist[i]
 		}
+
+		// 结点的标签、主机名等用于匹配规则的标签选择器和动态分组
+		if n, err := entries.GetNodesByID(node); err != nil {
+			log.Errorf("GetNodesByID error: %s", err.Error())
+		} else if n != nil {
+			nodeData = n
+		}
 	}
 
 	var jobIds []string
@@ -252,12 +315,23 @@ func (j *Job) GetList(ctx *Context) {
 			return
 		}
 
-		if len(node) > 0 && !job.IsRunOn(node, nodeGroupMap) {
+		job.ParseSelectors()
+		if len(node) > 0 && !job.IsRunOn(nodeData, nodeGroupMap) {
 			continue
 		}
 		jobList = append(jobList, &jobStatus{Job: &job})
 		jobIds = append(jobIds, job.ID)
 	}
+	runs, err := cronsun.GetJobRuns()
+	if err != nil {
+		log.Errorf("GetJobRuns error: %s", err.Error())
+	}
+	now := time.Now()
+	for i := range jobList {
+		jobList[i].Runs = runs[jobList[i].ID]
+		jobList[i].ActiveState = jobList[i].Job.ActiveState(now, jobList[i].Runs)
+	}
+
 	m, err := entries.GetJobLatestLogListByJobIds(jobIds)
 	if err != nil {
 		log.Errorf("GetJobLatestLogListByJobIds error: %s", err.Error())
@@ -268,7 +342,7 @@ func (j *Job) GetList(ctx *Context) {
 			if nt.IsZero() {
 				jobList[i].NextRunTime = "NO!!"
 			} else {
-				jobList[i].NextRunTime = nt.Format("2006-01-02 15:04:05")
+				jobList[i].NextRunTime = formatNextRunTime(nt)
 			}
 		}
 	}
@@ -276,6 +350,24 @@ func (j *Job) GetList(ctx *Context) {
 	outJSON(ctx.W, jobList)
 }
 
+// 下次执行时间，不是本地时区时显示时区
+func formatNextRunTime(t time.Time) string {
+	if t.Location() == time.Local {
+		return t.Format("2006-01-02 15:04:05")
+	}
+	return t.Format("2006-01-02 15:04:05 MST")
+}
+
+func (j *Job) GetDependGraph(ctx *Context) {
+	jobs, err := cronsun.GetAllJobs()
+	if err != nil {
+		outJSONWithCode(ctx.W, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	outJSON(ctx.W, cronsun.NewDependGraph(jobs))
+}
+
 func (j *Job) GetJobNodes(ctx *Context) {
 	vars := mux.Vars(ctx.R)
 	job, err := cronsun.GetJob(vars["group"], vars["id"])
@@ -290,14 +382,24 @@ func (j *Job) GetJobNodes(ctx *Context) {
 		return
 	}
 
-	var nodes []string
-	var exNodes []string
-	groups, err := cronsun.GetGroups("")
+	nodes, err := jobNodes(job)
 	if err != nil {
 		outJSONWithCode(ctx.W, http.StatusInternalServerError, err.Error())
 		return
 	}
 
+	outJSON(ctx.W, nodes)
+}
+
+// 执行任务的结点
+func jobNodes(job *cronsun.Job) ([]string, error) {
+	var nodes []string
+	var exNodes []string
+	groups, err := cronsun.GetGroups(nil)
+	if err != nil {
+		return nil, err
+	}
+
 	for i := range job.Rules {
 		inNodes := append(nodes, job.Rules[i].NodeIDs...)
 		for _, gid := range job.Rules[i].GroupIDs {
@@ -310,7 +412,45 @@ func (j *Job) GetJobNodes(ctx *Context) {
 		nodes = append(nodes, inNodes...)
 	}
 
-	outJSON(ctx.W, UniqueStringArray(nodes))
+	// 通过标签选择器和动态分组匹配的结点
+	list, err := entries.GetNodes()
+	if err != nil {
+		log.Errorf("GetNodes error: %s", err.Error())
+	}
+	for _, n := range list {
+		if job.IsRunOn(n, groups) {
+			nodes = append(nodes, n.ID)
+		}
+	}
+
+	return UniqueStringArray(nodes), nil
+}
+
+// 集群并发数的信号量持有者和等待者
+func (j *Job) GetSemaphoreHolders(ctx *Context) {
+	vars := mux.Vars(ctx.R)
+	job, err := cronsun.GetJob(vars["group"], vars["id"])
+	var statusCode int
+	if err != nil {
+		if err == cronsun.ErrNotFound {
+			statusCode = http.StatusNotFound
+		} else {
+			statusCode = http.StatusInternalServerError
+		}
+		outJSONWithCode(ctx.W, statusCode, err.Error())
+		return
+	}
+
+	holders, err := cronsun.GetSemaphoreHolders(job)
+	if err != nil {
+		outJSONWithCode(ctx.W, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	outJSON(ctx.W, struct {
+		MaxConcurrency int64                      `json:"max_concurrency"`
+		Holders        []*cronsun.SemaphoreHolder `json:"holders"`
+	}{job.MaxConcurrency, holders})
 }
 
 func (j *Job) JobExecute(ctx *Context) {
@@ -322,14 +462,49 @@ func (j *Job) JobExecute(ctx *Context) {
 		return
 	}
 
-	node := getStringVal("node", ctx.R)
-	err := cronsun.PutOnce(group, id, node)
+	// body 可以指定执行的结点、追加的参数和覆盖的环境变量
+	e := &cronsun.Execution{}
+	if ctx.R.ContentLength != 0 {
+		if err := json.NewDecoder(ctx.R.Body).Decode(e); err != nil && err != io.EOF {
+			outJSONWithCode(ctx.W, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	e.Group, e.JobID = group, id
+	if node := getStringVal("node", ctx.R); len(node) > 0 {
+		e.NodeIDs = append(e.NodeIDs, node)
+	}
+
+	job, err := cronsun.GetJob(group, id)
+	var statusCode int
 	if err != nil {
+		if err == cronsun.ErrNotFound {
+			statusCode = http.StatusNotFound
+		} else {
+			statusCode = http.StatusInternalServerError
+		}
+		outJSONWithCode(ctx.W, statusCode, err.Error())
+		return
+	}
+
+	// 没有指定结点时在任务所在的所有结点执行
+	if len(e.NodeIDs) == 0 {
+		if e.NodeIDs, err = jobNodes(job); err != nil {
+			outJSONWithCode(ctx.W, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	if err = cronsun.RunOnce(e); err != nil {
+		if err == cronsun.ErrIllegalJobEnv {
+			outJSONWithCode(ctx.W, http.StatusBadRequest, err.Error())
+			return
+		}
 		outJSONWithCode(ctx.W, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	outJSONWithCode(ctx.W, http.StatusNoContent, nil)
+	outJSON(ctx.W, e)
 }
 
 func (j *Job) GetExecutingJob(ctx *Context) {
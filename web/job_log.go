@@ -9,6 +9,7 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -62,6 +63,13 @@ func (jl *JobLog) GetList(ctx *Context) {
 	end := getTime(ctx.R.FormValue("end"))
 	page := getPage(ctx.R.FormValue("page"))
 	failedOnly := ctx.R.FormValue("failedOnly") == "true"
+	skippedOnly := ctx.R.FormValue("skippedOnly") == "true"
+	timedOutOnly := ctx.R.FormValue("timedOutOnly") == "true"
+	triggerTypes := getStringArrayFromQuery("triggerTypes", ",", ctx.R)
+	ruleIds := getStringArrayFromQuery("ruleIds", ",", ctx.R)
+	signals := getStringArrayFromQuery("signals", ",", ctx.R)
+	exitCodes := getIntArrayFromQuery("exitCodes", ",", ctx.R)
+	executionId := strings.TrimSpace(ctx.R.FormValue("executionId"))
 	pageSize := getPageSize(ctx.R.FormValue("pageSize"))
 	orderBy := bson.D{{Key: "beginTime", Value: -1}}
 
@@ -87,6 +95,50 @@ func (jl *JobLog) GetList(ctx *Context) {
 
 	if failedOnly {
 		query["success"] = false
+		query["skipped"] = bson.M{"$ne": true}
+	}
+
+	if skippedOnly {
+		query["skipped"] = true
+	}
+
+	if timedOutOnly {
+		query["timedOut"] = true
+	}
+
+	if len(triggerTypes) > 0 {
+		query["triggerType"] = bson.M{"$in": triggerTypes}
+	}
+
+	if len(ruleIds) > 0 {
+		query["ruleId"] = bson.M{"$in": ruleIds}
+	}
+
+	// 分片任务同一次执行的所有分片
+	if len(executionId) > 0 {
+		query["executionId"] = executionId
+	}
+
+	if len(signals) > 0 {
+		for i := range signals {
+			signals[i] = strings.ToUpper(strings.TrimSpace(signals[i]))
+		}
+		query["signal"] = bson.M{"$in": signals}
+	}
+
+	if len(exitCodes) > 0 {
+		query["exitCode"] = bson.M{"$in": exitCodes}
+	}
+
+	// 资源使用下限，CPU 时间单位毫秒，内存单位 KB
+	for param, field := range map[string]string{
+		"minUserTime": "userTime",
+		"minSysTime":  "sysTime",
+		"minMaxRss":   "maxRss",
+	} {
+		if v, err := strconv.ParseInt(getStringVal(param, ctx.R), 10, 64); err == nil && v > 0 {
+			query[field] = bson.M{"$gte": v}
+		}
 	}
 
 	if len(textSearch) > 0 {
@@ -72,7 +72,25 @@ func (n *Node) GetGroupByGroupId(ctx *Context) {
 		outJSONWithCode(ctx.W, http.StatusNotFound, nil)
 		return
 	}
-	outJSON(ctx.W, g)
+
+	// 返回当前属于分组的结点，用于检查动态分组的条件
+	nodes, err := entries.GetNodes()
+	if err != nil {
+		outJSONWithCode(ctx.W, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	matched := make([]*entries.Node, 0, len(nodes))
+	for _, n := range nodes {
+		if g.Included(n) {
+			matched = append(matched, n)
+		}
+	}
+
+	outJSON(ctx.W, &struct {
+		*cronsun.Group
+		Nodes []*entries.Node `json:"nodes"`
+	}{g, matched})
 }
 
 func (n *Node) DeleteGroup(ctx *Context) {
@@ -22,6 +22,10 @@ func initRouters() (s *http.Server, err error) {
 	configHandler := &Configuration{}
 	authHandler := &Authentication{}
 	adminHandler := &Administrator{}
+	scheduleHandler := &Schedule{}
+	calendarHandler := &Calendar{}
+	executionHandler := &Execution{}
+	triggerHandler := &Trigger{}
 
 	r := mux.NewRouter()
 	subrouter := r.PathPrefix("/v1").Subrouter()
@@ -66,11 +70,35 @@ func initRouters() (s *http.Server, err error) {
 	h = NewAuthHandler(jobHandler.DeleteJob, entries.Developer)
 	subrouter.Handle("/job/{group}-{id}", h).Methods("DELETE")
 
+	// get the job dependency graph
+	h = NewAuthHandler(jobHandler.GetDependGraph, entries.Reporter)
+	subrouter.Handle("/job/graph", h).Methods("GET")
+
 	h = NewAuthHandler(jobHandler.GetJobNodes, entries.Reporter)
 	subrouter.Handle("/job/{group}-{id}/nodes", h).Methods("GET")
 
+	// get the holders of the cluster-wide concurrency semaphore
+	h = NewAuthHandler(jobHandler.GetSemaphoreHolders, entries.Reporter)
+	subrouter.Handle("/job/{group}-{id}/semaphore", h).Methods("GET")
+
 	h = NewAuthHandler(jobHandler.JobExecute, entries.Developer)
 	subrouter.Handle("/job/{group}-{id}/execute", h).Methods("PUT")
+	// get the per-node status of a manual run
+	h = NewAuthHandler(executionHandler.GetExecution, entries.Reporter)
+	subrouter.Handle("/execution/{id}", h).Methods("GET")
+
+	// get the trigger tokens of a job
+	h = NewAuthHandler(triggerHandler.GetTokens, entries.Developer)
+	subrouter.Handle("/job/{group}-{id}/tokens", h).Methods("GET")
+	// create a trigger token
+	h = NewAuthHandler(triggerHandler.CreateToken, entries.Developer)
+	subrouter.Handle("/job/{group}-{id}/token", h).Methods("PUT")
+	// revoke a trigger token
+	h = NewAuthHandler(triggerHandler.DeleteToken, entries.Developer)
+	subrouter.Handle("/job/{group}-{id}/token/{tid}", h).Methods("DELETE")
+	// run a job by its trigger token, no session needed
+	h = NewBaseHandler(triggerHandler.Trigger)
+	subrouter.Handle("/trigger/{token}", h).Methods("POST")
 
 	// query executing job
 	h = NewAuthHandler(jobHandler.GetExecutingJob, entries.Reporter)
@@ -80,6 +108,10 @@ func initRouters() (s *http.Server, err error) {
 	h = NewAuthHandler(jobHandler.KillExecutingJob, entries.Developer)
 	subrouter.Handle("/job/executing", h).Methods("DELETE")
 
+	// preview the next run times of a timer
+	h = NewAuthHandler(scheduleHandler.Preview, entries.Reporter)
+	subrouter.Handle("/schedule/preview", h).Methods("GET")
+
 	// get job log list
 	h = NewAuthHandler(jobLogHandler.GetList, entries.Reporter)
 	subrouter.Handle("/logs", h).Methods("GET")
@@ -104,6 +136,22 @@ func initRouters() (s *http.Server, err error) {
 	h = NewAuthHandler(nodeHandler.DeleteGroup, entries.Developer)
 	subrouter.Handle("/node/group/{id}", h).Methods("DELETE")
 
+	// get calendar list
+	h = NewAuthHandler(calendarHandler.GetList, entries.Reporter)
+	subrouter.Handle("/calendars", h).Methods("GET")
+	// get a calendar
+	h = NewAuthHandler(calendarHandler.GetCalendar, entries.Reporter)
+	subrouter.Handle("/calendar/{id}", h).Methods("GET")
+	// create/update a calendar
+	h = NewAuthHandler(calendarHandler.UpdateCalendar, entries.Developer)
+	subrouter.Handle("/calendar", h).Methods("PUT")
+	// create/replace a calendar from an iCalendar file
+	h = NewAuthHandler(calendarHandler.ImportICalendar, entries.Developer)
+	subrouter.Handle("/calendar/ics", h).Methods("PUT")
+	// delete a calendar
+	h = NewAuthHandler(calendarHandler.DeleteCalendar, entries.Developer)
+	subrouter.Handle("/calendar/{id}", h).Methods("DELETE")
+
 	h = NewAuthHandler(infoHandler.Overview, entries.Reporter)
 	subrouter.Handle("/info/overview", h).Methods("GET")
 
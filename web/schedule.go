@@ -0,0 +1,57 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cronsun"
+	"cronsun/node/cron"
+)
+
+type Schedule struct{}
+
+// 预览最多返回的执行时间个数
+const maxPreviewCount = 100
+
+// Preview 返回定时器接下来的执行时间和英文描述
+func (s *Schedule) Preview(ctx *Context) {
+	rule := &cronsun.JobRule{
+		Timer:    getStringVal("timer", ctx.R),
+		Timezone: getStringVal("timezone", ctx.R),
+	}
+
+	n, err := strconv.Atoi(getStringVal("count", ctx.R))
+	if err != nil || n < 1 {
+		n = 10
+	} else if n > maxPreviewCount {
+		n = maxPreviewCount
+	}
+
+	p, err := rule.Preview(time.Now(), n)
+	if err != nil {
+		var field, value string
+		var fe *cron.FieldError
+		if errors.As(err, &fe) {
+			field, value = fe.Field, fe.Value
+		}
+		outJSONWithCode(ctx.W, http.StatusBadRequest, struct {
+			Error string `json:"error"`
+			Field string `json:"field,omitempty"`
+			Value string `json:"value,omitempty"`
+		}{err.Error(), field, value})
+		return
+	}
+
+	next := make([]string, len(p.Next))
+	for i := range p.Next {
+		next[i] = formatNextRunTime(p.Next[i])
+	}
+	outJSON(ctx.W, struct {
+		Timer       string   `json:"timer"`
+		Timezone    string   `json:"timezone"`
+		Description string   `json:"description"`
+		Next        []string `json:"next"`
+	}{rule.Timer, rule.Location().String(), p.Description, next})
+}
@@ -0,0 +1,207 @@
+package web
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"cronsun"
+	"cronsun/log"
+)
+
+type Trigger struct {
+	limiter rateLimiter
+}
+
+// 创建任务的触发 token，只在创建时返回 token 和签名密钥
+func (t *Trigger) CreateToken(ctx *Context) {
+	vars := mux.Vars(ctx.R)
+	jt := &cronsun.JobToken{}
+	if ctx.R.ContentLength != 0 {
+		if err := json.NewDecoder(ctx.R.Body).Decode(jt); err != nil && err != io.EOF {
+			outJSONWithCode(ctx.W, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	_, err := cronsun.GetJob(vars["group"], vars["id"])
+	var statusCode int
+	if err != nil {
+		if err == cronsun.ErrNotFound {
+			statusCode = http.StatusNotFound
+		} else {
+			statusCode = http.StatusInternalServerError
+		}
+		outJSONWithCode(ctx.W, statusCode, err.Error())
+		return
+	}
+
+	jt.Group, jt.JobID = vars["group"], vars["id"]
+	token, secret, err := cronsun.CreateJobToken(jt)
+	if err != nil {
+		if err == cronsun.ErrEmptyTriggerKey {
+			outJSONWithCode(ctx.W, http.StatusBadRequest, err.Error())
+			return
+		}
+		outJSONWithCode(ctx.W, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	jt.SigningKey = ""
+	outJSON(ctx.W, &struct {
+		*cronsun.JobToken
+		Token  string `json:"token"`
+		Secret string `json:"secret,omitempty"`
+	}{jt, token, secret})
+}
+
+func (t *Trigger) GetTokens(ctx *Context) {
+	vars := mux.Vars(ctx.R)
+	list, err := cronsun.GetJobTokens(vars["group"], vars["id"])
+	if err != nil {
+		outJSONWithCode(ctx.W, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	outJSON(ctx.W, list)
+}
+
+// 撤销任务的触发 token
+func (t *Trigger) DeleteToken(ctx *Context) {
+	vars := mux.Vars(ctx.R)
+	err := cronsun.DeleteJobToken(vars["group"], vars["id"], vars["tid"])
+	var statusCode int
+	if err != nil {
+		if err == cronsun.ErrNotFound {
+			statusCode = http.StatusNotFound
+		} else {
+			statusCode = http.StatusInternalServerError
+		}
+		outJSONWithCode(ctx.W, statusCode, err.Error())
+		return
+	}
+
+	outJSONWithCode(ctx.W, http.StatusNoContent, nil)
+}
+
+// 通过触发 token 执行任务，不需要登录
+// 请求内容为空或 JSON，通过 CRONSUN_PAYLOAD 环境变量和标准输入传给任务
+func (t *Trigger) Trigger(ctx *Context) {
+	token := mux.Vars(ctx.R)["token"]
+	jt, err := cronsun.GetJobToken(token)
+	if err != nil {
+		if err == cronsun.ErrNotFound {
+			outJSONWithCode(ctx.W, http.StatusNotFound, "Invalid trigger token.")
+			return
+		}
+		outJSONWithCode(ctx.W, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !t.limiter.allow(jt.ID, jt.Limit(), time.Now()) {
+		outJSONWithCode(ctx.W, http.StatusTooManyRequests, "Too many requests.")
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(ctx.W, ctx.R.Body, cronsun.MaxTriggerPayload))
+	if err != nil {
+		outJSONWithCode(ctx.W, http.StatusRequestEntityTooLarge, cronsun.ErrIllegalTriggerPayload.Error())
+		return
+	}
+
+	sig := ctx.R.Header.Get(cronsun.TriggerSignatureHeader)
+	if jt.RequireSignature || len(sig) > 0 {
+		if err = jt.Verify(sig, ctx.R.Header.Get(cronsun.TriggerTimestampHeader), body, time.Now()); err != nil {
+			outJSONWithCode(ctx.W, http.StatusUnauthorized, err.Error())
+			return
+		}
+	}
+
+	job, err := cronsun.GetJob(jt.Group, jt.JobID)
+	var statusCode int
+	if err != nil {
+		if err == cronsun.ErrNotFound {
+			statusCode = http.StatusNotFound
+		} else {
+			statusCode = http.StatusInternalServerError
+		}
+		outJSONWithCode(ctx.W, statusCode, err.Error())
+		return
+	}
+
+	e := &cronsun.Execution{
+		Group:       jt.Group,
+		JobID:       jt.JobID,
+		TriggerType: cronsun.TriggerWebhook,
+		Source:      jt.Source(),
+		CallerIP:    callerIP(ctx.R),
+		Payload:     strings.TrimSpace(string(body)),
+	}
+	if e.NodeIDs, err = jobNodes(job); err != nil {
+		outJSONWithCode(ctx.W, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err = cronsun.RunOnce(e); err != nil {
+		if err == cronsun.ErrIllegalTriggerPayload {
+			outJSONWithCode(ctx.W, http.StatusBadRequest, err.Error())
+			return
+		}
+		outJSONWithCode(ctx.W, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	log.Infof("job[%s/%s] triggered by %s from %s, execution[%s]", e.Group, e.JobID, e.Source, e.CallerIP, e.ID)
+	outJSONWithCode(ctx.W, http.StatusAccepted, map[string]string{"id": e.ID})
+}
+
+func callerIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// 每个 token 每分钟的调用次数限制
+type rateLimiter struct {
+	mu      sync.Mutex
+	windows map[string]*rateWindow
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+func (l *rateLimiter) allow(key string, limit int, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.windows == nil {
+		l.windows = make(map[string]*rateWindow)
+	}
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.start) >= time.Minute {
+		// 清除过期的记录
+		for k, w := range l.windows {
+			if now.Sub(w.start) >= time.Minute {
+				delete(l.windows, k)
+			}
+		}
+		w = &rateWindow{start: now}
+		l.windows[key] = w
+	}
+
+	if w.count >= limit {
+		return false
+	}
+	w.count++
+	return true
+}
@@ -0,0 +1,127 @@
+package cronsun
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 允许执行的时间段，时间格式为 15:04 或 15:04:05
+// End 早于 Start 时跨过零点，如 22:00-02:00
+type JobWindow struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+	// 时区，IANA 名称，如 Asia/Shanghai，为空时使用结点的本地时区
+	Timezone string `json:"timezone,omitempty"`
+
+	start, end int // 从零点开始的秒数
+	loc        *time.Location
+}
+
+func (w *JobWindow) String() string {
+	s := w.Start + "-" + w.End
+	if len(w.Timezone) > 0 {
+		s += " " + w.Timezone
+	}
+	return s
+}
+
+// 解析时间段，Valid 时调用
+func (w *JobWindow) parse() (err error) {
+	w.Start, w.End = strings.TrimSpace(w.Start), strings.TrimSpace(w.End)
+	w.Timezone = strings.TrimSpace(w.Timezone)
+	if w.start, err = parseClock(w.Start); err != nil {
+		return
+	}
+	if w.end, err = parseClock(w.End); err != nil {
+		return
+	}
+	if w.start == w.end {
+		return ErrIllegalJobWindow
+	}
+
+	w.loc = time.Local
+	if len(w.Timezone) > 0 {
+		if w.loc, err = time.LoadLocation(w.Timezone); err != nil {
+			return ErrIllegalJobWindow
+		}
+	}
+	return nil
+}
+
+func parseClock(s string) (int, error) {
+	for _, layout := range []string{"15:04", "15:04:05"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.Hour()*3600 + t.Minute()*60 + t.Second(), nil
+		}
+	}
+	return 0, ErrIllegalJobWindow
+}
+
+// 包含 t 时返回所在时间段的结束时间，否则返回零值
+func (w *JobWindow) until(t time.Time) time.Time {
+	if w.loc == nil {
+		return time.Time{}
+	}
+
+	t = t.In(w.loc)
+	y, m, d := t.Date()
+	// 前一天开始跨过零点的时间段，和当天开始的时间段
+	for _, day := range []int{d - 1, d} {
+		start := time.Date(y, m, day, 0, 0, w.start, 0, w.loc)
+		end := time.Date(y, m, day, 0, 0, w.end, 0, w.loc)
+		if w.end < w.start {
+			end = time.Date(y, m, day+1, 0, 0, w.end, 0, w.loc)
+		}
+		if !t.Before(start) && t.Before(end) {
+			return end
+		}
+	}
+	return time.Time{}
+}
+
+func (j *Job) ValidWindows() error {
+	for _, w := range j.Windows {
+		if err := w.parse(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// windowEnd 返回 t 所在执行时间段的结束时间，相连的时间段视为一个
+// t 不在任何时间段内时 ok 为 false，没有设置时间段时 ok 为 true，结束时间为零值
+func (j *Job) windowEnd(t time.Time) (end time.Time, ok bool) {
+	if len(j.Windows) == 0 {
+		return time.Time{}, true
+	}
+
+	// 时间段覆盖全天时不会结束，限制延长的次数
+	for i := 0; i <= len(j.Windows); i++ {
+		next := end
+		for _, w := range j.Windows {
+			if e := w.until(t); e.After(next) {
+				next = e
+			}
+		}
+		if !next.After(end) {
+			break
+		}
+		end, t = next, next
+	}
+	return end, !end.IsZero()
+}
+
+// 执行时间段外不执行，返回 false 时已记录跳过的日志
+func (j *Job) checkWindow(tr *Trigger) bool {
+	if _, ok := j.windowEnd(time.Now()); ok {
+		return true
+	}
+
+	ws := make([]string, len(j.Windows))
+	for i, w := range j.Windows {
+		ws[i] = w.String()
+	}
+	j.Skip(tr, fmt.Sprintf("job[%s] running on[%s] outside execution windows[%s]", j.Key(), j.runOn, strings.Join(ws, ", ")))
+	return false
+}
@@ -0,0 +1,87 @@
+package cronsun
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJobWindowParse(t *testing.T) {
+	tests := []struct {
+		w   JobWindow
+		err error
+	}{
+		{JobWindow{Start: "01:00", End: "05:00"}, nil},
+		{JobWindow{Start: " 22:00 ", End: "02:00:30"}, nil},
+		{JobWindow{Start: "01:00", End: "05:00", Timezone: "Asia/Shanghai"}, nil},
+		{JobWindow{Start: "01:00", End: "01:00"}, ErrIllegalJobWindow},
+		{JobWindow{Start: "1am", End: "05:00"}, ErrIllegalJobWindow},
+		{JobWindow{Start: "01:00", End: "24:00"}, ErrIllegalJobWindow},
+		{JobWindow{Start: "01:00", End: "05:00", Timezone: "Mars/Olympus"}, ErrIllegalJobWindow},
+	}
+
+	for i, test := range tests {
+		if err := test.w.parse(); err != test.err {
+			t.Errorf("#%d: expected %v, got %v", i, test.err, err)
+		}
+	}
+}
+
+func TestJobWindowEnd(t *testing.T) {
+	day := func(d, h, m int) time.Time {
+		return time.Date(2018, 3, d, h, m, 0, 0, time.Local)
+	}
+	tests := []struct {
+		windows  [][2]string
+		t        time.Time
+		expected time.Time
+		ok       bool
+	}{
+		{nil, day(1, 12, 0), time.Time{}, true},
+		{[][2]string{{"01:00", "05:00"}}, day(1, 0, 59), time.Time{}, false},
+		{[][2]string{{"01:00", "05:00"}}, day(1, 1, 0), day(1, 5, 0), true},
+		{[][2]string{{"01:00", "05:00"}}, day(1, 4, 59), day(1, 5, 0), true},
+		{[][2]string{{"01:00", "05:00"}}, day(1, 5, 0), time.Time{}, false},
+		// 跨过零点
+		{[][2]string{{"22:00", "02:00"}}, day(1, 23, 0), day(2, 2, 0), true},
+		{[][2]string{{"22:00", "02:00"}}, day(2, 1, 0), day(2, 2, 0), true},
+		{[][2]string{{"22:00", "02:00"}}, day(2, 12, 0), time.Time{}, false},
+		// 多个时间段
+		{[][2]string{{"01:00", "05:00"}, {"13:00", "14:00"}}, day(1, 13, 30), day(1, 14, 0), true},
+		// 相连的时间段
+		{[][2]string{{"22:00", "00:00"}, {"00:00", "02:00"}}, day(1, 23, 0), day(2, 2, 0), true},
+	}
+
+	for i, test := range tests {
+		j := &Job{}
+		for _, w := range test.windows {
+			j.Windows = append(j.Windows, &JobWindow{Start: w[0], End: w[1]})
+		}
+		if err := j.ValidWindows(); err != nil {
+			t.Fatalf("#%d: unexpected error %v", i, err)
+		}
+
+		end, ok := j.windowEnd(test.t)
+		if ok != test.ok || !end.Equal(test.expected) {
+			t.Errorf("#%d: expected %s %v, got %s %v", i, test.expected, test.ok, end, ok)
+		}
+	}
+
+	// 覆盖全天的时间段不会一直延长
+	j := &Job{Windows: []*JobWindow{{Start: "00:00", End: "12:00"}, {Start: "12:00", End: "00:00"}}}
+	if err := j.ValidWindows(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := j.windowEnd(day(1, 6, 0)); !ok {
+		t.Errorf("expected in window")
+	}
+}
+
+func TestWindowClosedNotRetryable(t *testing.T) {
+	r := &ExecResult{ExitCode: -1, WindowClosed: true}
+	if (*RetryPolicy)(nil).retryable(r) {
+		t.Errorf("expected not retryable when window closed")
+	}
+	if (&RetryPolicy{}).retryable(r) {
+		t.Errorf("expected not retryable when window closed")
+	}
+}